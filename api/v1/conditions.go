@@ -0,0 +1,33 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+// Condition types reported by FwLet and FwMaster.
+const (
+	// ConditionReady is True when the firewall enforces the spec and
+	// nothing is degraded or drifted.
+	ConditionReady = "Ready"
+	// ConditionApplied is True when the ruleset rendered from the current
+	// spec passed the check and was applied.
+	ConditionApplied = "Applied"
+	// ConditionDegraded is True when a failed change left the node on an
+	// older or unknown ruleset.
+	ConditionDegraded = "Degraded"
+	// ConditionDriftDetected is True when the live ruleset no longer
+	// matches the applied one.
+	ConditionDriftDetected = "DriftDetected"
+)
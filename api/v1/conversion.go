@@ -0,0 +1,212 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v2 "github.com/Yosshi72/fw-controller/api/v2"
+)
+
+// v1 is a spoke of the v2 hub. The trust and untrust interfaces of v1 are
+// the zones named trust and untrust in v2. What one version cannot
+// represent is kept in the v2.ConversionDataAnnotation of the other.
+
+// conversionData is the content of the conversion data annotation.
+type conversionData struct {
+	Spec   json.RawMessage `json:"spec"`
+	Status json.RawMessage `json:"status"`
+}
+
+// stash records spec and status in the conversion data of obj.
+func stash(obj metav1.Object, spec, status interface{}) error {
+	var data conversionData
+	var err error
+	if data.Spec, err = json.Marshal(spec); err != nil {
+		return fmt.Errorf("Failed to marshal conversion data: %v", err)
+	}
+	if data.Status, err = json.Marshal(status); err != nil {
+		return fmt.Errorf("Failed to marshal conversion data: %v", err)
+	}
+	raw, err := json.Marshal(&data)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal conversion data: %v", err)
+	}
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[v2.ConversionDataAnnotation] = string(raw)
+	obj.SetAnnotations(annotations)
+	return nil
+}
+
+// unstash removes the conversion data from obj and decodes it into spec
+// and status. It reports whether obj had conversion data.
+func unstash(obj metav1.Object, spec, status interface{}) (bool, error) {
+	annotations := obj.GetAnnotations()
+	raw, ok := annotations[v2.ConversionDataAnnotation]
+	if !ok {
+		return false, nil
+	}
+	delete(annotations, v2.ConversionDataAnnotation)
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	obj.SetAnnotations(annotations)
+
+	var data conversionData
+	if err := json.Unmarshal([]byte(raw), &data); err != nil {
+		return false, fmt.Errorf("Failed to unmarshal conversion data: %v", err)
+	}
+	if err := json.Unmarshal(data.Spec, spec); err != nil {
+		return false, fmt.Errorf("Failed to unmarshal conversion data: %v", err)
+	}
+	if err := json.Unmarshal(data.Status, status); err != nil {
+		return false, fmt.Errorf("Failed to unmarshal conversion data: %v", err)
+	}
+	return true, nil
+}
+
+func zonesToV2(trust, untrust []string, zones []ZoneSpec) []v2.Zone {
+	var out []v2.Zone
+	if len(trust) > 0 {
+		out = append(out, v2.Zone{Name: v2.ZoneTrust, Interfaces: trust})
+	}
+	if len(untrust) > 0 {
+		out = append(out, v2.Zone{Name: v2.ZoneUntrust, Interfaces: untrust})
+	}
+	for _, z := range zones {
+		out = append(out, v2.Zone{Name: v2.ZoneName(z.Name), Interfaces: z.Interfaces})
+	}
+	return out
+}
+
+// zonesFromV2 splits zones into the interfaces of the first trust and
+// untrust zones and the other zones.
+func zonesFromV2(zones []v2.Zone) (trust, untrust []string, named []ZoneSpec) {
+	var haveTrust, haveUntrust bool
+	for _, z := range zones {
+		switch {
+		case z.Name == v2.ZoneTrust && !haveTrust:
+			trust, haveTrust = z.Interfaces, true
+		case z.Name == v2.ZoneUntrust && !haveUntrust:
+			untrust, haveUntrust = z.Interfaces, true
+		default:
+			named = append(named, ZoneSpec{Name: Zone(z.Name), Interfaces: z.Interfaces})
+		}
+	}
+	return trust, untrust, named
+}
+
+func zonePoliciesToV2(in []ZonePolicy) []v2.ZonePolicy {
+	var out []v2.ZonePolicy
+	for _, p := range in {
+		out = append(out, v2.ZonePolicy{From: v2.ZoneName(p.From), To: v2.ZoneName(p.To), Default: v2.Verdict(p.Default)})
+	}
+	return out
+}
+
+func zonePoliciesFromV2(in []v2.ZonePolicy) []ZonePolicy {
+	var out []ZonePolicy
+	for _, p := range in {
+		out = append(out, ZonePolicy{From: Zone(p.From), To: Zone(p.To), Default: Verdict(p.Default)})
+	}
+	return out
+}
+
+func rulesToV2(in []PolicyRule) []v2.PolicyRule {
+	var out []v2.PolicyRule
+	for _, r := range in {
+		rule := v2.PolicyRule{
+			Name:        r.Name,
+			From:        v2.ZoneName(r.From),
+			To:          v2.ZoneName(r.To),
+			Source:      r.Source,
+			Destination: r.Destination,
+			Protocol:    r.Protocol,
+			ICMPTypes:   r.ICMPTypes,
+			Action:      v2.RuleAction(r.Action),
+		}
+		for _, p := range r.Ports {
+			rule.Ports = append(rule.Ports, v2.PortRange(p))
+		}
+		out = append(out, rule)
+	}
+	return out
+}
+
+func rulesFromV2(in []v2.PolicyRule) []PolicyRule {
+	var out []PolicyRule
+	for _, r := range in {
+		rule := PolicyRule{
+			Name:        r.Name,
+			From:        Zone(r.From),
+			To:          Zone(r.To),
+			Source:      r.Source,
+			Destination: r.Destination,
+			Protocol:    r.Protocol,
+			ICMPTypes:   r.ICMPTypes,
+			Action:      RuleAction(r.Action),
+		}
+		for _, p := range r.Ports {
+			rule.Ports = append(rule.Ports, PortRange(p))
+		}
+		out = append(out, rule)
+	}
+	return out
+}
+
+func baselineToV2(in *BaselineSpec) *v2.BaselineSpec {
+	if in == nil {
+		return nil
+	}
+	return &v2.BaselineSpec{Mode: v2.BaselineMode(in.Mode), Template: in.Template}
+}
+
+func baselineFromV2(in *v2.BaselineSpec) *BaselineSpec {
+	if in == nil {
+		return nil
+	}
+	return &BaselineSpec{Mode: BaselineMode(in.Mode), Template: in.Template}
+}
+
+func templateRefToV2(in *TemplateRef) *v2.TemplateRef {
+	if in == nil {
+		return nil
+	}
+	return &v2.TemplateRef{Name: in.Name, Key: in.Key}
+}
+
+func templateRefFromV2(in *v2.TemplateRef) *TemplateRef {
+	if in == nil {
+		return nil
+	}
+	return &TemplateRef{Name: in.Name, Key: in.Key}
+}
+
+// firstOf returns the first of list, the value of the deprecated single
+// interface fields.
+func firstOf(list []string) string {
+	if len(list) == 0 {
+		return ""
+	}
+	return list[0]
+}
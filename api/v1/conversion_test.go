@@ -0,0 +1,200 @@
+package v1
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v2 "github.com/Yosshi72/fw-controller/api/v2"
+)
+
+// viaJSON stores obj as the API server would.
+func viaJSON(t *testing.T, in, out interface{}) {
+	t.Helper()
+	raw, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testTime() *metav1.Time {
+	t := metav1.NewTime(time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC))
+	return &t
+}
+
+func TestFwLetRoundTripFromV1(t *testing.T) {
+	rules := []PolicyRule{{
+		Name:        "ssh",
+		From:        ZoneUntrust,
+		To:          ZoneTrust,
+		Source:      []string{"2001:db8:10::/48"},
+		Protocol:    "tcp",
+		Ports:       []PortRange{"22", "2222"},
+		Action:      RuleActionAccept,
+		Destination: []string{"2001:db8:20::/48"},
+	}}
+	tests := []struct {
+		name string
+		in   FwLet
+	}{
+		{"untrust list", FwLet{
+			Spec: FwLetSpec{
+				TrustIf:          []string{"eth-a", "eth-b"},
+				UntrustIfs:       []string{"vsix-bb", "vsix-ix"},
+				MgmtAddressRange: []string{"2001:db8:10:10::/64"},
+				Baseline:         &BaselineSpec{Mode: BaselineTemplate, Template: "maintenance.rule"},
+				DriftPolicy:      DriftPolicyReport,
+				TemplateRef:      &TemplateRef{Name: "fw-template"},
+				Zones:            []ZoneSpec{{Name: "dmz", Interfaces: []string{"eth-d"}}},
+				ZonePolicies:     []ZonePolicy{{From: "dmz", To: ZoneUntrust, Default: "accept"}},
+				Rules:            rules,
+			},
+			Status: FwLetStatus{
+				TrustIf:             []string{"eth-a", "eth-b"},
+				UntrustIf:           "vsix-bb",
+				UntrustIfs:          []string{"vsix-bb", "vsix-ix"},
+				MgmtAddressRange:    []string{"2001:db8:10:10::/64"},
+				Conditions:          []metav1.Condition{{Type: ConditionReady, Status: metav1.ConditionTrue, Reason: "AsExpected", LastTransitionTime: *testTime()}},
+				ObservedGeneration:  2,
+				LastAppliedTime:     testTime(),
+				RulesetHash:         "abc",
+				LastAppliedRevision: 3,
+				TemplateRevision:    "fw-template/fw-template.rule@1",
+			},
+		}},
+		{"deprecated untrust", FwLet{
+			Spec:   FwLetSpec{TrustIf: []string{"eth-a"}, UntrustIf: "vsix-bb"},
+			Status: FwLetStatus{TrustIf: []string{"eth-a"}, UntrustIf: "vsix-bb"},
+		}},
+		{"trust zone in zones", FwLet{
+			Spec: FwLetSpec{UntrustIfs: []string{"vsix-bb"}, Zones: []ZoneSpec{{Name: ZoneTrust, Interfaces: []string{"eth-a"}}}},
+		}},
+		{"empty", FwLet{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := tt.in.DeepCopy()
+			in.SetName("kote")
+			in.SetAnnotations(map[string]string{"note": "kept"})
+
+			hub := v2.FwLet{}
+			if err := in.ConvertTo(&hub); err != nil {
+				t.Fatalf("ConvertTo() error = %v", err)
+			}
+			stored := v2.FwLet{}
+			viaJSON(t, &hub, &stored)
+			out := FwLet{}
+			if err := out.ConvertFrom(&stored); err != nil {
+				t.Fatalf("ConvertFrom() error = %v", err)
+			}
+			if !equality.Semantic.DeepEqual(in, &out) {
+				t.Errorf("round trip changed the FwLet\n got %+v\nwant %+v", out, *in)
+			}
+		})
+	}
+}
+
+func TestFwLetRoundTripFromV2(t *testing.T) {
+	in := &v2.FwLet{
+		ObjectMeta: metav1.ObjectMeta{Name: "kote"},
+		Spec: v2.FwLetSpec{
+			// Named zones first and a zone v1 cannot tell from the
+			// legacy fields.
+			Zones: []v2.Zone{
+				{Name: "dmz", Interfaces: []string{"eth-d"}},
+				{Name: v2.ZoneTrust, Interfaces: []string{"eth-a"}},
+				{Name: v2.ZoneTrust, Interfaces: []string{"eth-b"}},
+			},
+			ManagementAddresses: []string{"2001:db8:10:10::/64"},
+			Rules:               []v2.PolicyRule{{From: "dmz", To: v2.ZoneTrust, Protocol: "icmpv6", ICMPTypes: []string{"echo-request"}, Action: v2.RuleActionLog}},
+		},
+		Status: v2.FwLetStatus{
+			Zones:           []v2.Zone{{Name: v2.ZoneUntrust, Interfaces: []string{"vsix-bb"}}, {Name: v2.ZoneTrust, Interfaces: []string{"eth-a"}}},
+			LastAppliedTime: testTime(),
+		},
+	}
+
+	spoke := FwLet{}
+	if err := spoke.ConvertFrom(in.DeepCopy()); err != nil {
+		t.Fatalf("ConvertFrom() error = %v", err)
+	}
+	if got := spoke.Spec.TrustIf; len(got) != 1 || got[0] != "eth-a" {
+		t.Errorf("v1 TrustIf = %v, want [eth-a]", got)
+	}
+	if spoke.Status.UntrustIf != "vsix-bb" {
+		t.Errorf("v1 Status.UntrustIf = %q, want vsix-bb", spoke.Status.UntrustIf)
+	}
+	stored := FwLet{}
+	viaJSON(t, &spoke, &stored)
+	out := v2.FwLet{}
+	if err := stored.ConvertTo(&out); err != nil {
+		t.Fatalf("ConvertTo() error = %v", err)
+	}
+	if !equality.Semantic.DeepEqual(in, &out) {
+		t.Errorf("round trip changed the FwLet\n got %+v\nwant %+v", out, *in)
+	}
+
+	// A change made through v1 wins over the stashed v2 spec.
+	stored.Spec.TrustIf = []string{"eth-c"}
+	if err := stored.ConvertTo(&out); err != nil {
+		t.Fatalf("ConvertTo() error = %v", err)
+	}
+	if got := out.Spec.Interfaces(v2.ZoneTrust); len(got) != 1 || got[0] != "eth-c" {
+		t.Errorf("v2 trust interfaces = %v, want [eth-c]", got)
+	}
+	if !equality.Semantic.DeepEqual(in.Status, out.Status) {
+		t.Errorf("unchanged status was not restored: %+v", out.Status)
+	}
+}
+
+func TestFwMasterRoundTrip(t *testing.T) {
+	in := &FwMaster{
+		ObjectMeta: metav1.ObjectMeta{Name: "master"},
+		Spec: FwMasterSpec{
+			Regions: []RegionSpec{
+				{RegionName: "kote", TrustIf: []string{"eth-a"}, UntrustIf: "vsix-bb", DriftPolicy: DriftPolicyReapply},
+				{RegionName: "note", TrustIf: []string{"eth-b"}, UntrustIfs: []string{"vsix-bb", "vsix-ix"},
+					Zones: []ZoneSpec{{Name: "dmz", Interfaces: []string{"eth-d"}}}, TemplateRef: &TemplateRef{Name: "note", Key: "fw.rule"}},
+			},
+			MgmtAddressRange: []string{"2001:db8:10:10::/64"},
+			TemplateRef:      &TemplateRef{Name: "fw-template"},
+		},
+		Status: FwMasterStatus{
+			Regions: []RegionStatus{
+				{RegionName: "kote", TrustIf: []string{"eth-a"}, UntrustIf: "vsix-bb", UntrustIfs: []string{"vsix-bb"}, Created: true, Ready: true, LastAppliedTime: testTime()},
+			},
+			ObservedGeneration: 1,
+		},
+	}
+	hub := v2.FwMaster{}
+	if err := in.ConvertTo(&hub); err != nil {
+		t.Fatalf("ConvertTo() error = %v", err)
+	}
+	if hub.Spec.Regions[0].Name != "kote" || len(hub.Spec.Regions[1].Zones) != 3 || len(hub.Spec.ManagementAddresses) != 1 {
+		t.Errorf("unexpected v2 spec %+v", hub.Spec)
+	}
+	stored := v2.FwMaster{}
+	viaJSON(t, &hub, &stored)
+	out := FwMaster{}
+	if err := out.ConvertFrom(&stored); err != nil {
+		t.Fatalf("ConvertFrom() error = %v", err)
+	}
+	if !equality.Semantic.DeepEqual(in, &out) {
+		t.Errorf("round trip changed the FwMaster\n got %+v\nwant %+v", out, *in)
+	}
+
+	// A FwMaster v1 can represent carries no conversion data.
+	in.Spec.Regions[0].UntrustIfs, in.Spec.Regions[0].UntrustIf = []string{"vsix-bb"}, ""
+	if err := in.ConvertTo(&hub); err != nil {
+		t.Fatalf("ConvertTo() error = %v", err)
+	}
+	if _, ok := hub.GetAnnotations()[v2.ConversionDataAnnotation]; ok {
+		t.Errorf("conversion data stashed for a lossless conversion")
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	v2 "github.com/Yosshi72/fw-controller/api/v2"
+)
+
+// ConvertTo converts this FwLet to the hub version.
+func (src *FwLet) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v2.FwLet)
+	src = src.DeepCopy()
+	dst.ObjectMeta = src.ObjectMeta
+	dst.Spec = fwLetSpecToV2(&src.Spec)
+	dst.Status = fwLetStatusToV2(&src.Status)
+
+	// Restore what v2 holds beyond v1, unless it was changed through v1.
+	spec, status := v2.FwLetSpec{}, v2.FwLetStatus{}
+	ok, err := unstash(dst, &spec, &status)
+	if err != nil {
+		return err
+	}
+	if ok && equality.Semantic.DeepEqual(fwLetSpecFromV2(&spec), src.Spec) {
+		dst.Spec = spec
+	}
+	if ok && equality.Semantic.DeepEqual(fwLetStatusFromV2(&status), src.Status) {
+		dst.Status = status
+	}
+	// Keep what v2 cannot represent for the way back.
+	if equality.Semantic.DeepEqual(fwLetSpecFromV2(&dst.Spec), src.Spec) &&
+		equality.Semantic.DeepEqual(fwLetStatusFromV2(&dst.Status), src.Status) {
+		return nil
+	}
+	return stash(dst, &src.Spec, &src.Status)
+}
+
+// ConvertFrom converts from the hub version to this version.
+func (dst *FwLet) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v2.FwLet).DeepCopy()
+	dst.ObjectMeta = src.ObjectMeta
+	dst.Spec = fwLetSpecFromV2(&src.Spec)
+	dst.Status = fwLetStatusFromV2(&src.Status)
+
+	// Restore what v1 holds beyond v2, unless it was changed through v2.
+	spec, status := FwLetSpec{}, FwLetStatus{}
+	ok, err := unstash(dst, &spec, &status)
+	if err != nil {
+		return err
+	}
+	if ok && equality.Semantic.DeepEqual(fwLetSpecToV2(&spec), src.Spec) {
+		dst.Spec = spec
+	}
+	if ok && equality.Semantic.DeepEqual(fwLetStatusToV2(&status), src.Status) {
+		dst.Status = status
+	}
+	// Keep what v1 cannot represent for the way back.
+	if equality.Semantic.DeepEqual(fwLetSpecToV2(&dst.Spec), src.Spec) &&
+		equality.Semantic.DeepEqual(fwLetStatusToV2(&dst.Status), src.Status) {
+		return nil
+	}
+	return stash(dst, &src.Spec, &src.Status)
+}
+
+func fwLetSpecToV2(in *FwLetSpec) v2.FwLetSpec {
+	return v2.FwLetSpec{
+		Zones:               zonesToV2(in.TrustIf, in.UntrustInterfaces(), in.Zones),
+		ZonePolicies:        zonePoliciesToV2(in.ZonePolicies),
+		Rules:               rulesToV2(in.Rules),
+		ManagementAddresses: in.MgmtAddressRange,
+		Baseline:            baselineToV2(in.Baseline),
+		DriftPolicy:         v2.DriftPolicy(in.DriftPolicy),
+		TemplateRef:         templateRefToV2(in.TemplateRef),
+	}
+}
+
+func fwLetSpecFromV2(in *v2.FwLetSpec) FwLetSpec {
+	trust, untrust, zones := zonesFromV2(in.Zones)
+	return FwLetSpec{
+		TrustIf:          trust,
+		UntrustIfs:       untrust,
+		MgmtAddressRange: in.ManagementAddresses,
+		Baseline:         baselineFromV2(in.Baseline),
+		DriftPolicy:      DriftPolicy(in.DriftPolicy),
+		TemplateRef:      templateRefFromV2(in.TemplateRef),
+		Zones:            zones,
+		ZonePolicies:     zonePoliciesFromV2(in.ZonePolicies),
+		Rules:            rulesFromV2(in.Rules),
+	}
+}
+
+func fwLetStatusToV2(in *FwLetStatus) v2.FwLetStatus {
+	return v2.FwLetStatus{
+		Zones:               zonesToV2(in.TrustIf, untrustInterfaces(in.UntrustIfs, in.UntrustIf), nil),
+		ManagementAddresses: in.MgmtAddressRange,
+		Conditions:          in.Conditions,
+		ObservedGeneration:  in.ObservedGeneration,
+		LastAppliedTime:     in.LastAppliedTime,
+		RulesetHash:         in.RulesetHash,
+		LiveRulesetHash:     in.LiveRulesetHash,
+		LastAppliedRevision: in.LastAppliedRevision,
+		TemplateRevision:    in.TemplateRevision,
+		TemplateHash:        in.TemplateHash,
+	}
+}
+
+func fwLetStatusFromV2(in *v2.FwLetStatus) FwLetStatus {
+	trust, untrust, _ := zonesFromV2(in.Zones)
+	return FwLetStatus{
+		TrustIf:             trust,
+		UntrustIf:           firstOf(untrust),
+		UntrustIfs:          untrust,
+		MgmtAddressRange:    in.ManagementAddresses,
+		Conditions:          in.Conditions,
+		ObservedGeneration:  in.ObservedGeneration,
+		LastAppliedTime:     in.LastAppliedTime,
+		RulesetHash:         in.RulesetHash,
+		LiveRulesetHash:     in.LiveRulesetHash,
+		LastAppliedRevision: in.LastAppliedRevision,
+		TemplateRevision:    in.TemplateRevision,
+		TemplateHash:        in.TemplateHash,
+	}
+}
@@ -27,9 +27,166 @@ import (
 type FwLetSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
-	TrustIf          []string `json:"trustif"`   //下流
-	UntrustIf        string   `json:"untrustif"` //上流
+	TrustIf []string `json:"trustif"` //下流
+	// UntrustIf is the single upstream interface of objects written before
+	// UntrustIfs existed. It is only read when UntrustIfs is empty.
+	// Deprecated: use UntrustIfs.
+	// +optional
+	UntrustIf string `json:"untrustif,omitempty"` //上流
+	// UntrustIfs are the upstream interfaces.
+	// +optional
+	UntrustIfs       []string `json:"untrustifs,omitempty"`
 	MgmtAddressRange []string `json:"mgmtaddressrange"`
+	// Baseline is the ruleset left on the node when this FwLet is deleted.
+	// Defaults to the agent's --default-baseline.
+	// +optional
+	Baseline *BaselineSpec `json:"baseline,omitempty"`
+	// DriftPolicy decides what happens when the live ruleset is changed
+	// behind the controller's back. Defaults to Reapply.
+	// +optional
+	DriftPolicy DriftPolicy `json:"driftpolicy,omitempty"`
+	// TemplateRef is the ConfigMap holding the ruleset template. Defaults
+	// to the template file baked into the agent image.
+	// +optional
+	TemplateRef *TemplateRef `json:"templateref,omitempty"`
+	// Zones are named zones besides trust and untrust.
+	// +optional
+	Zones []ZoneSpec `json:"zones,omitempty"`
+	// ZonePolicies form the zone pair matrix.
+	// +optional
+	ZonePolicies []ZonePolicy `json:"zonepolicies,omitempty"`
+	// Rules are rendered in order into the PAIR_<from>_to_<to> chains.
+	// +optional
+	Rules []PolicyRule `json:"rules,omitempty"`
+}
+
+// DriftPolicy is the reaction to a live ruleset that drifted.
+// +kubebuilder:validation:Enum=Reapply;Report
+type DriftPolicy string
+
+const (
+	// DriftPolicyReapply restores the applied ruleset.
+	DriftPolicyReapply DriftPolicy = "Reapply"
+	// DriftPolicyReport only sets the DriftDetected condition.
+	DriftPolicyReport DriftPolicy = "Report"
+)
+
+// BaselineMode selects the ruleset restored when a FwLet is deleted.
+// +kubebuilder:validation:Enum=FailOpen;FailClosed;Template
+type BaselineMode string
+
+const (
+	// BaselineFailOpen flushes the ruleset, letting all traffic through.
+	BaselineFailOpen BaselineMode = "FailOpen"
+	// BaselineFailClosed drops all new inbound and forwarded traffic.
+	BaselineFailClosed BaselineMode = "FailClosed"
+	// BaselineTemplate loads a named ruleset from the agent's baseline directory.
+	BaselineTemplate BaselineMode = "Template"
+)
+
+type BaselineSpec struct {
+	Mode BaselineMode `json:"mode"`
+	// Template is the name of the ruleset file used with Mode Template.
+	// +optional
+	Template string `json:"template,omitempty"`
+}
+
+// Zone is the name of a zone. trust and untrust are the zones of TrustIf
+// and UntrustIf.
+// +kubebuilder:validation:Pattern=`^[a-z][a-z0-9_]*$`
+// +kubebuilder:validation:MaxLength=24
+type Zone string
+
+const (
+	ZoneTrust   Zone = "trust"
+	ZoneUntrust Zone = "untrust"
+)
+
+// ZoneSpec is a named zone. Traffic leaving through its interfaces is
+// dispatched by the ZONE_<NAME> chain.
+type ZoneSpec struct {
+	Name       Zone     `json:"name"`
+	Interfaces []string `json:"interfaces"`
+}
+
+// Verdict is the default verdict of a zone pair.
+// +kubebuilder:validation:Enum=accept;drop;reject
+type Verdict string
+
+// ZonePolicy sets the verdict for traffic from one zone to another that no
+// rule matched. Pairs without a policy drop, except trust to untrust which
+// accepts.
+type ZonePolicy struct {
+	From    Zone    `json:"from"`
+	To      Zone    `json:"to"`
+	Default Verdict `json:"default"`
+}
+
+// RuleAction is what a policy rule does with matching traffic. Log logs
+// the packet and goes on with the next rule.
+// +kubebuilder:validation:Enum=accept;drop;reject;log
+type RuleAction string
+
+const (
+	RuleActionAccept RuleAction = "accept"
+	RuleActionDrop   RuleAction = "drop"
+	RuleActionReject RuleAction = "reject"
+	RuleActionLog    RuleAction = "log"
+)
+
+// PortRange is a port number or a range such as "8000-8080".
+// +kubebuilder:validation:Pattern=`^[0-9]{1,5}(-[0-9]{1,5})?$`
+type PortRange string
+
+// PolicyRule matches traffic from one zone to another.
+type PolicyRule struct {
+	// Name is used as the comment and log prefix of the rule.
+	// +optional
+	Name string `json:"name,omitempty"`
+	From Zone   `json:"from"`
+	To   Zone   `json:"to"`
+	// Source and Destination are IPv4 or IPv6 prefixes. Empty matches any.
+	// +optional
+	Source []string `json:"source,omitempty"`
+	// +optional
+	Destination []string `json:"destination,omitempty"`
+	// +kubebuilder:validation:Enum=tcp;udp;sctp;icmp;icmpv6
+	// +optional
+	Protocol string `json:"protocol,omitempty"`
+	// Ports are destination ports, for tcp, udp and sctp.
+	// +optional
+	Ports []PortRange `json:"ports,omitempty"`
+	// ICMPTypes are type names such as echo-request, for icmp and icmpv6.
+	// +optional
+	ICMPTypes []string   `json:"icmptypes,omitempty"`
+	Action    RuleAction `json:"action"`
+}
+
+// UntrustInterfaces returns UntrustIfs, or UntrustIf if only the
+// deprecated single value is set.
+func (s *FwLetSpec) UntrustInterfaces() []string {
+	return untrustInterfaces(s.UntrustIfs, s.UntrustIf)
+}
+
+func untrustInterfaces(list []string, single string) []string {
+	if len(list) > 0 {
+		return list
+	}
+	if single != "" {
+		return []string{single}
+	}
+	return nil
+}
+
+// DefaultTemplateKey is the ConfigMap key read when TemplateRef.Key is empty.
+const DefaultTemplateKey = "fw-template.rule"
+
+// TemplateRef selects a key of a ConfigMap in the namespace of the object.
+type TemplateRef struct {
+	Name string `json:"name"`
+	// Key defaults to DefaultTemplateKey.
+	// +optional
+	Key string `json:"key,omitempty"`
 }
 
 // FwLetStatus defines the observed state of FwLet
@@ -37,13 +194,47 @@ type FwLetStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	TrustIf          []string `json:"trustif"`
+	TrustIf []string `json:"trustif"`
+	// UntrustIf is the first of UntrustIfs.
+	// Deprecated: use UntrustIfs.
 	UntrustIf        string   `json:"untrustif"`
+	UntrustIfs       []string `json:"untrustifs,omitempty"`
 	MgmtAddressRange []string `json:"mgmtaddressrange"`
+
+	// +listType=map
+	// +listMapKey=type
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	// LastAppliedTime is when the current ruleset was applied.
+	LastAppliedTime *metav1.Time `json:"lastappliedtime,omitempty"`
+	// RulesetHash is the sha256 of the ruleset file being enforced.
+	RulesetHash string `json:"rulesethash,omitempty"`
+	// LiveRulesetHash is the sha256 of the kernel's ruleset dump taken
+	// right after the ruleset was applied. Drift is a dump that no longer
+	// hashes to it.
+	LiveRulesetHash string `json:"liverulesethash,omitempty"`
+	// LastAppliedRevision is the history revision of the ruleset that was
+	// last applied and confirmed. Rollbacks restore this revision.
+	LastAppliedRevision int64 `json:"lastappliedrevision,omitempty"`
+	// TemplateRevision identifies the template of the applied ruleset:
+	// "<configmap>/<key>@<resourceVersion>" or the path of the agent's
+	// template file.
+	TemplateRevision string `json:"templaterevision,omitempty"`
+	// TemplateHash is the sha256 of that template. A template whose hash
+	// differs is re-rendered.
+	TemplateHash string `json:"templatehash,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Applied",type=string,JSONPath=`.status.conditions[?(@.type=="Applied")].reason`
+//+kubebuilder:printcolumn:name="Untrust",type=string,JSONPath=`.status.untrustifs`
+//+kubebuilder:printcolumn:name="Revision",type=integer,JSONPath=`.status.lastappliedrevision`
+//+kubebuilder:printcolumn:name="Hash",type=string,JSONPath=`.status.rulesethash`,priority=1
+//+kubebuilder:printcolumn:name="Template",type=string,JSONPath=`.status.templaterevision`,priority=1
+//+kubebuilder:printcolumn:name="Last Applied",type=date,JSONPath=`.status.lastappliedtime`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // FwLet is the Schema for the fwlets API
 type FwLet struct {
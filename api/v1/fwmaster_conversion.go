@@ -0,0 +1,162 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	v2 "github.com/Yosshi72/fw-controller/api/v2"
+)
+
+// ConvertTo converts this FwMaster to the hub version.
+func (src *FwMaster) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v2.FwMaster)
+	src = src.DeepCopy()
+	dst.ObjectMeta = src.ObjectMeta
+	dst.Spec = fwMasterSpecToV2(&src.Spec)
+	dst.Status = fwMasterStatusToV2(&src.Status)
+
+	// Restore what v2 holds beyond v1, unless it was changed through v1.
+	spec, status := v2.FwMasterSpec{}, v2.FwMasterStatus{}
+	ok, err := unstash(dst, &spec, &status)
+	if err != nil {
+		return err
+	}
+	if ok && equality.Semantic.DeepEqual(fwMasterSpecFromV2(&spec), src.Spec) {
+		dst.Spec = spec
+	}
+	if ok && equality.Semantic.DeepEqual(fwMasterStatusFromV2(&status), src.Status) {
+		dst.Status = status
+	}
+	// Keep what v2 cannot represent for the way back.
+	if equality.Semantic.DeepEqual(fwMasterSpecFromV2(&dst.Spec), src.Spec) &&
+		equality.Semantic.DeepEqual(fwMasterStatusFromV2(&dst.Status), src.Status) {
+		return nil
+	}
+	return stash(dst, &src.Spec, &src.Status)
+}
+
+// ConvertFrom converts from the hub version to this version.
+func (dst *FwMaster) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v2.FwMaster).DeepCopy()
+	dst.ObjectMeta = src.ObjectMeta
+	dst.Spec = fwMasterSpecFromV2(&src.Spec)
+	dst.Status = fwMasterStatusFromV2(&src.Status)
+
+	// Restore what v1 holds beyond v2, unless it was changed through v2.
+	spec, status := FwMasterSpec{}, FwMasterStatus{}
+	ok, err := unstash(dst, &spec, &status)
+	if err != nil {
+		return err
+	}
+	if ok && equality.Semantic.DeepEqual(fwMasterSpecToV2(&spec), src.Spec) {
+		dst.Spec = spec
+	}
+	if ok && equality.Semantic.DeepEqual(fwMasterStatusToV2(&status), src.Status) {
+		dst.Status = status
+	}
+	// Keep what v1 cannot represent for the way back.
+	if equality.Semantic.DeepEqual(fwMasterSpecToV2(&dst.Spec), src.Spec) &&
+		equality.Semantic.DeepEqual(fwMasterStatusToV2(&dst.Status), src.Status) {
+		return nil
+	}
+	return stash(dst, &src.Spec, &src.Status)
+}
+
+func fwMasterSpecToV2(in *FwMasterSpec) v2.FwMasterSpec {
+	out := v2.FwMasterSpec{
+		ManagementAddresses: in.MgmtAddressRange,
+		TemplateRef:         templateRefToV2(in.TemplateRef),
+	}
+	for i := range in.Regions {
+		r := &in.Regions[i]
+		out.Regions = append(out.Regions, v2.RegionSpec{
+			Name:         r.RegionName,
+			Zones:        zonesToV2(r.TrustIf, r.UntrustInterfaces(), r.Zones),
+			ZonePolicies: zonePoliciesToV2(r.ZonePolicies),
+			Rules:        rulesToV2(r.Rules),
+			Baseline:     baselineToV2(r.Baseline),
+			DriftPolicy:  v2.DriftPolicy(r.DriftPolicy),
+			TemplateRef:  templateRefToV2(r.TemplateRef),
+		})
+	}
+	return out
+}
+
+func fwMasterSpecFromV2(in *v2.FwMasterSpec) FwMasterSpec {
+	out := FwMasterSpec{
+		MgmtAddressRange: in.ManagementAddresses,
+		TemplateRef:      templateRefFromV2(in.TemplateRef),
+	}
+	for _, r := range in.Regions {
+		trust, untrust, zones := zonesFromV2(r.Zones)
+		out.Regions = append(out.Regions, RegionSpec{
+			RegionName:   r.Name,
+			TrustIf:      trust,
+			UntrustIfs:   untrust,
+			Baseline:     baselineFromV2(r.Baseline),
+			DriftPolicy:  DriftPolicy(r.DriftPolicy),
+			TemplateRef:  templateRefFromV2(r.TemplateRef),
+			Zones:        zones,
+			ZonePolicies: zonePoliciesFromV2(r.ZonePolicies),
+			Rules:        rulesFromV2(r.Rules),
+		})
+	}
+	return out
+}
+
+func fwMasterStatusToV2(in *FwMasterStatus) v2.FwMasterStatus {
+	out := v2.FwMasterStatus{
+		Conditions:         in.Conditions,
+		ObservedGeneration: in.ObservedGeneration,
+	}
+	for _, r := range in.Regions {
+		out.Regions = append(out.Regions, v2.RegionStatus{
+			Name:                r.RegionName,
+			Zones:               zonesToV2(r.TrustIf, untrustInterfaces(r.UntrustIfs, r.UntrustIf), nil),
+			ManagementAddresses: r.MgmtAddressRange,
+			Created:             r.Created,
+			Ready:               r.Ready,
+			LastAppliedTime:     r.LastAppliedTime,
+			RulesetHash:         r.RulesetHash,
+		})
+	}
+	return out
+}
+
+func fwMasterStatusFromV2(in *v2.FwMasterStatus) FwMasterStatus {
+	out := FwMasterStatus{
+		Conditions:         in.Conditions,
+		ObservedGeneration: in.ObservedGeneration,
+	}
+	for _, r := range in.Regions {
+		trust, untrust, _ := zonesFromV2(r.Zones)
+		out.Regions = append(out.Regions, RegionStatus{
+			RegionName:       r.Name,
+			TrustIf:          trust,
+			UntrustIf:        firstOf(untrust),
+			UntrustIfs:       untrust,
+			MgmtAddressRange: r.ManagementAddresses,
+			Created:          r.Created,
+			Ready:            r.Ready,
+			LastAppliedTime:  r.LastAppliedTime,
+			RulesetHash:      r.RulesetHash,
+		})
+	}
+	return out
+}
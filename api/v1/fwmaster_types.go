@@ -23,27 +23,72 @@ import (
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+const (
+	// RegionDeletionPolicyAnnotation on a FwMaster controls what happens
+	// to the FwLet of a region removed from spec. The FwLet is deleted
+	// unless the value is RegionDeletionPolicyOrphan, in which case it is
+	// released from the FwMaster and left running.
+	RegionDeletionPolicyAnnotation = "samplecontroller.yossy.vsix.wide.ad.jp/region-deletion-policy"
+	RegionDeletionPolicyDelete     = "delete"
+	RegionDeletionPolicyOrphan     = "orphan"
+)
+
 // FwMasterSpec defines the desired state of FwMaster
 type FwMasterSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 	Regions          []RegionSpec `json:"regions"`
 	MgmtAddressRange []string     `json:"mgmtaddressrange"`
+	// TemplateRef is passed on to every region's FwLet that does not set
+	// its own.
+	// +optional
+	TemplateRef *TemplateRef `json:"templateref,omitempty"`
 }
 
 // TODO Interfaceをenumで実装する
 type RegionSpec struct {
 	RegionName string   `json:"regionname"`
 	TrustIf    []string `json:"trustif"`
-	UntrustIf  string   `json:"untrustif"`
+	// Deprecated: use UntrustIfs.
+	// +optional
+	UntrustIf string `json:"untrustif,omitempty"`
+	// +optional
+	UntrustIfs []string `json:"untrustifs,omitempty"`
+	// Baseline is passed on to the region's FwLet.
+	// +optional
+	Baseline *BaselineSpec `json:"baseline,omitempty"`
+	// DriftPolicy is passed on to the region's FwLet.
+	// +optional
+	DriftPolicy DriftPolicy `json:"driftpolicy,omitempty"`
+	// TemplateRef overrides the FwMaster's TemplateRef for this region.
+	// +optional
+	TemplateRef *TemplateRef `json:"templateref,omitempty"`
+	// Zones, ZonePolicies and Rules are passed on to the region's FwLet.
+	// +optional
+	Zones []ZoneSpec `json:"zones,omitempty"`
+	// +optional
+	ZonePolicies []ZonePolicy `json:"zonepolicies,omitempty"`
+	// +optional
+	Rules []PolicyRule `json:"rules,omitempty"`
+}
+
+// UntrustInterfaces returns UntrustIfs, or UntrustIf if only the
+// deprecated single value is set.
+func (s *RegionSpec) UntrustInterfaces() []string {
+	return untrustInterfaces(s.UntrustIfs, s.UntrustIf)
 }
 
 type RegionStatus struct {
 	RegionName       string   `json:"regionname"`
 	TrustIf          []string `json:"trustif"`
 	UntrustIf        string   `json:"untrustif"`
+	UntrustIfs       []string `json:"untrustifs,omitempty"`
 	MgmtAddressRange []string `json:"mgmtaddressrange"`
 	Created          bool     `json:"created"`
+	// Ready mirrors the Ready condition of the region's FwLet.
+	Ready           bool         `json:"ready,omitempty"`
+	LastAppliedTime *metav1.Time `json:"lastappliedtime,omitempty"`
+	RulesetHash     string       `json:"rulesethash,omitempty"`
 }
 
 // FwMasterStatus defines the observed state of FwMaster
@@ -51,6 +96,11 @@ type FwMasterStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 	Regions []RegionStatus `json:"regions"`
+
+	// +listType=map
+	// +listMapKey=type
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
 }
 
 // type RegionStatus struct {
@@ -61,6 +111,10 @@ type FwMasterStatus struct {
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Degraded",type=string,JSONPath=`.status.conditions[?(@.type=="Degraded")].status`
+//+kubebuilder:printcolumn:name="Drift",type=string,JSONPath=`.status.conditions[?(@.type=="DriftDetected")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // FwMaster is the Schema for the fwmasters API
 type FwMaster struct {
@@ -0,0 +1,209 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	v2 "github.com/Yosshi72/fw-controller/api/v2"
+)
+
+// These tests run the webhooks against a real API server and
+// need the envtest binaries: run them with `make test`.
+
+var cfg *rest.Config
+var k8sClient client.Client
+var testEnv *envtest.Environment
+var cancel context.CancelFunc
+
+func TestWebhooks(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Webhook Suite")
+}
+
+var _ = BeforeSuite(func() {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		Skip("KUBEBUILDER_ASSETS is not set")
+	}
+	logf.SetLogger(zap.New(zap.WriteTo(GinkgoWriter), zap.UseDevMode(true)))
+
+	By("bootstrapping test environment")
+	Expect(AddToScheme(scheme.Scheme)).To(Succeed())
+	Expect(v2.AddToScheme(scheme.Scheme)).To(Succeed())
+	testEnv = &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("..", "..", "config", "crd", "bases")},
+		ErrorIfCRDPathMissing: true,
+		CRDInstallOptions:     envtest.CRDInstallOptions{Scheme: scheme.Scheme},
+		WebhookInstallOptions: envtest.WebhookInstallOptions{
+			Paths: []string{filepath.Join("..", "..", "config", "webhook")},
+		},
+	}
+
+	var err error
+	cfg, err = testEnv.Start()
+	Expect(err).NotTo(HaveOccurred())
+	Expect(cfg).NotTo(BeNil())
+
+	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme.Scheme})
+	Expect(err).NotTo(HaveOccurred())
+
+	// start webhook server using Manager
+	webhookInstallOptions := &testEnv.WebhookInstallOptions
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:             scheme.Scheme,
+		MetricsBindAddress: "0",
+		LeaderElection:     false,
+		WebhookServer: webhook.NewServer(webhook.Options{
+			Host:    webhookInstallOptions.LocalServingHost,
+			Port:    webhookInstallOptions.LocalServingPort,
+			CertDir: webhookInstallOptions.LocalServingCertDir,
+		}),
+	})
+	Expect(err).NotTo(HaveOccurred())
+	Expect((&v2.FwLet{}).SetupWebhookWithManager(mgr)).To(Succeed())
+	Expect((&v2.FwMaster{}).SetupWebhookWithManager(mgr)).To(Succeed())
+	Expect((&v2.AddressGroup{}).SetupWebhookWithManager(mgr)).To(Succeed())
+	Expect((&v2.ServiceGroup{}).SetupWebhookWithManager(mgr)).To(Succeed())
+
+	var ctx context.Context
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(ctx)).To(Succeed())
+	}()
+
+	// wait for the webhook server to get ready
+	dialer := &net.Dialer{Timeout: time.Second}
+	addrPort := fmt.Sprintf("%s:%d", webhookInstallOptions.LocalServingHost, webhookInstallOptions.LocalServingPort)
+	Eventually(func() error {
+		conn, err := tls.DialWithDialer(dialer, "tcp", addrPort, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}).Should(Succeed())
+})
+
+var _ = AfterSuite(func() {
+	if testEnv == nil {
+		return
+	}
+	cancel()
+	By("tearing down the test environment")
+	Expect(testEnv.Stop()).To(Succeed())
+})
+
+var _ = Describe("Conversion webhook", func() {
+	ctx := context.Background()
+
+	It("keeps a FwLet written as v1 unchanged", func() {
+		in := &FwLet{
+			ObjectMeta: metav1.ObjectMeta{Name: "kote", Namespace: "default"},
+			Spec: FwLetSpec{
+				TrustIf:          []string{"eth-a"},
+				UntrustIf:        "vsix-bb",
+				MgmtAddressRange: []string{"2001:db8:10:10::/64"},
+				Zones:            []ZoneSpec{{Name: "dmz", Interfaces: []string{"eth-d"}}},
+				Rules:            []PolicyRule{{Name: "web", From: ZoneUntrust, To: "dmz", Protocol: "tcp", Ports: []PortRange{"80"}, Action: RuleActionAccept}},
+			},
+		}
+		Expect(k8sClient.Create(ctx, in.DeepCopy())).To(Succeed())
+		key := client.ObjectKeyFromObject(in)
+
+		hub := &v2.FwLet{}
+		Expect(k8sClient.Get(ctx, key, hub)).To(Succeed())
+		Expect(hub.Spec.Interfaces(v2.ZoneUntrust)).To(Equal([]string{"vsix-bb"}))
+		Expect(hub.Spec.Zones).To(HaveLen(3))
+
+		out := &FwLet{}
+		Expect(k8sClient.Get(ctx, key, out)).To(Succeed())
+		Expect(equality.Semantic.DeepEqual(out.Spec, in.Spec)).To(BeTrue(), "got %+v", out.Spec)
+		Expect(out.GetAnnotations()).NotTo(HaveKey(v2.ConversionDataAnnotation))
+	})
+
+	It("keeps a FwMaster written as v2 unchanged through a v1 update", func() {
+		in := &v2.FwMaster{
+			ObjectMeta: metav1.ObjectMeta{Name: "master", Namespace: "default"},
+			Spec: v2.FwMasterSpec{
+				Regions: []v2.RegionSpec{{
+					Name:        "kote",
+					DriftPolicy: v2.DriftPolicyReapply,
+					Zones: []v2.Zone{
+						{Name: "dmz", Interfaces: []string{"eth-d"}},
+						{Name: v2.ZoneTrust, Interfaces: []string{"eth-a"}},
+					},
+				}},
+				ManagementAddresses: []string{"2001:db8:10:10::/64"},
+			},
+		}
+		Expect(k8sClient.Create(ctx, in.DeepCopy())).To(Succeed())
+		key := client.ObjectKeyFromObject(in)
+
+		spoke := &FwMaster{}
+		Expect(k8sClient.Get(ctx, key, spoke)).To(Succeed())
+		Expect(spoke.Spec.Regions[0].RegionName).To(Equal("kote"))
+		Expect(spoke.Spec.Regions[0].TrustIf).To(Equal([]string{"eth-a"}))
+		spoke.SetLabels(map[string]string{"site": "kote"})
+		Expect(k8sClient.Update(ctx, spoke)).To(Succeed())
+
+		out := &v2.FwMaster{}
+		Expect(k8sClient.Get(ctx, key, out)).To(Succeed())
+		Expect(equality.Semantic.DeepEqual(out.Spec, in.Spec)).To(BeTrue(), "got %+v", out.Spec)
+		Expect(out.GetLabels()).To(HaveKeyWithValue("site", "kote"))
+		Expect(out.GetAnnotations()).NotTo(HaveKey(v2.ConversionDataAnnotation))
+	})
+})
+
+var _ = Describe("Validating webhook", func() {
+	ctx := context.Background()
+
+	It("rejects a FwLet written as v1 with an interface in two zones", func() {
+		in := &FwLet{
+			ObjectMeta: metav1.ObjectMeta{Name: "invalid", Namespace: "default"},
+			Spec: FwLetSpec{
+				TrustIf:          []string{"eth-a"},
+				UntrustIfs:       []string{"eth-a"},
+				MgmtAddressRange: []string{"2001:db8:10:10::/64"},
+			},
+		}
+		err := k8sClient.Create(ctx, in)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue(), "got %v", err)
+	})
+})
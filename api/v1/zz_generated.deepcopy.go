@@ -22,9 +22,25 @@ limitations under the License.
 package v1
 
 import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BaselineSpec) DeepCopyInto(out *BaselineSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BaselineSpec.
+func (in *BaselineSpec) DeepCopy() *BaselineSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(BaselineSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *FwLet) DeepCopyInto(out *FwLet) {
 	*out = *in
@@ -92,11 +108,45 @@ func (in *FwLetSpec) DeepCopyInto(out *FwLetSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.UntrustIfs != nil {
+		in, out := &in.UntrustIfs, &out.UntrustIfs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.MgmtAddressRange != nil {
 		in, out := &in.MgmtAddressRange, &out.MgmtAddressRange
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Baseline != nil {
+		in, out := &in.Baseline, &out.Baseline
+		*out = new(BaselineSpec)
+		**out = **in
+	}
+	if in.TemplateRef != nil {
+		in, out := &in.TemplateRef, &out.TemplateRef
+		*out = new(TemplateRef)
+		**out = **in
+	}
+	if in.Zones != nil {
+		in, out := &in.Zones, &out.Zones
+		*out = make([]ZoneSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ZonePolicies != nil {
+		in, out := &in.ZonePolicies, &out.ZonePolicies
+		*out = make([]ZonePolicy, len(*in))
+		copy(*out, *in)
+	}
+	if in.Rules != nil {
+		in, out := &in.Rules, &out.Rules
+		*out = make([]PolicyRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FwLetSpec.
@@ -117,11 +167,27 @@ func (in *FwLetStatus) DeepCopyInto(out *FwLetStatus) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.UntrustIfs != nil {
+		in, out := &in.UntrustIfs, &out.UntrustIfs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.MgmtAddressRange != nil {
 		in, out := &in.MgmtAddressRange, &out.MgmtAddressRange
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.LastAppliedTime != nil {
+		in, out := &in.LastAppliedTime, &out.LastAppliedTime
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FwLetStatus.
@@ -208,6 +274,11 @@ func (in *FwMasterSpec) DeepCopyInto(out *FwMasterSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.TemplateRef != nil {
+		in, out := &in.TemplateRef, &out.TemplateRef
+		*out = new(TemplateRef)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FwMasterSpec.
@@ -230,6 +301,13 @@ func (in *FwMasterStatus) DeepCopyInto(out *FwMasterStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FwMasterStatus.
@@ -242,6 +320,41 @@ func (in *FwMasterStatus) DeepCopy() *FwMasterStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PolicyRule) DeepCopyInto(out *PolicyRule) {
+	*out = *in
+	if in.Source != nil {
+		in, out := &in.Source, &out.Source
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Destination != nil {
+		in, out := &in.Destination, &out.Destination
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]PortRange, len(*in))
+		copy(*out, *in)
+	}
+	if in.ICMPTypes != nil {
+		in, out := &in.ICMPTypes, &out.ICMPTypes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PolicyRule.
+func (in *PolicyRule) DeepCopy() *PolicyRule {
+	if in == nil {
+		return nil
+	}
+	out := new(PolicyRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RegionSpec) DeepCopyInto(out *RegionSpec) {
 	*out = *in
@@ -250,6 +363,40 @@ func (in *RegionSpec) DeepCopyInto(out *RegionSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.UntrustIfs != nil {
+		in, out := &in.UntrustIfs, &out.UntrustIfs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Baseline != nil {
+		in, out := &in.Baseline, &out.Baseline
+		*out = new(BaselineSpec)
+		**out = **in
+	}
+	if in.TemplateRef != nil {
+		in, out := &in.TemplateRef, &out.TemplateRef
+		*out = new(TemplateRef)
+		**out = **in
+	}
+	if in.Zones != nil {
+		in, out := &in.Zones, &out.Zones
+		*out = make([]ZoneSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ZonePolicies != nil {
+		in, out := &in.ZonePolicies, &out.ZonePolicies
+		*out = make([]ZonePolicy, len(*in))
+		copy(*out, *in)
+	}
+	if in.Rules != nil {
+		in, out := &in.Rules, &out.Rules
+		*out = make([]PolicyRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegionSpec.
@@ -270,11 +417,20 @@ func (in *RegionStatus) DeepCopyInto(out *RegionStatus) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.UntrustIfs != nil {
+		in, out := &in.UntrustIfs, &out.UntrustIfs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.MgmtAddressRange != nil {
 		in, out := &in.MgmtAddressRange, &out.MgmtAddressRange
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.LastAppliedTime != nil {
+		in, out := &in.LastAppliedTime, &out.LastAppliedTime
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegionStatus.
@@ -286,3 +442,53 @@ func (in *RegionStatus) DeepCopy() *RegionStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TemplateRef) DeepCopyInto(out *TemplateRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TemplateRef.
+func (in *TemplateRef) DeepCopy() *TemplateRef {
+	if in == nil {
+		return nil
+	}
+	out := new(TemplateRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ZonePolicy) DeepCopyInto(out *ZonePolicy) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZonePolicy.
+func (in *ZonePolicy) DeepCopy() *ZonePolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(ZonePolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ZoneSpec) DeepCopyInto(out *ZoneSpec) {
+	*out = *in
+	if in.Interfaces != nil {
+		in, out := &in.Interfaces, &out.Interfaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZoneSpec.
+func (in *ZoneSpec) DeepCopy() *ZoneSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ZoneSpec)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AddressGroupSpec defines the desired state of AddressGroup
+type AddressGroupSpec struct {
+	// Addresses are IPv4 or IPv6 prefixes.
+	// +optional
+	Addresses []string `json:"addresses,omitempty"`
+	// Groups are the names of AddressGroups in the same namespace whose
+	// addresses belong to this group as well. They must not include this
+	// group, directly or through their own groups.
+	// +optional
+	Groups []string `json:"groups,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:printcolumn:name="Addresses",type=string,JSONPath=`.spec.addresses`
+//+kubebuilder:printcolumn:name="Groups",type=string,JSONPath=`.spec.groups`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// AddressGroup is a named list of prefixes that FwMasters of the same
+// namespace reference instead of repeating the prefixes inline.
+type AddressGroup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec AddressGroupSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// AddressGroupList contains a list of AddressGroup
+type AddressGroupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AddressGroup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&AddressGroup{}, &AddressGroupList{})
+}
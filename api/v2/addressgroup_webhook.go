@@ -0,0 +1,73 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// SetupWebhookWithManager registers the webhooks of AddressGroup with the
+// manager.
+func (r *AddressGroup) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-samplecontroller-yossy-vsix-wide-ad-jp-v2-addressgroup,mutating=false,failurePolicy=fail,sideEffects=None,groups=samplecontroller.yossy.vsix.wide.ad.jp,resources=addressgroups,verbs=create;update,versions=v2,name=vaddressgroup.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &AddressGroup{}
+
+// ValidateCreate implements webhook.Validator.
+func (r *AddressGroup) ValidateCreate() (admission.Warnings, error) {
+	return nil, r.validate()
+}
+
+// ValidateUpdate implements webhook.Validator.
+func (r *AddressGroup) ValidateUpdate(old runtime.Object) (admission.Warnings, error) {
+	return nil, r.validate()
+}
+
+// ValidateDelete implements webhook.Validator. Deleting a group still
+// referenced makes the FwMasters using it report an error rather than
+// lose the addresses.
+func (r *AddressGroup) ValidateDelete() (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validate checks the group on its own. Cycles through other groups are
+// only found when a FwMaster resolves the group.
+func (r *AddressGroup) validate() error {
+	path := field.NewPath("spec")
+	_, allErrs := validatePrefixes(r.Spec.Addresses, path.Child("addresses"))
+	gpath := path.Child("groups")
+	allErrs = append(allErrs, validateGroupNames(r.Spec.Groups, gpath)...)
+	for i, name := range r.Spec.Groups {
+		if name == r.Name {
+			allErrs = append(allErrs, field.Invalid(gpath.Index(i), name, "must not include the group itself"))
+		}
+	}
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("AddressGroup").GroupKind(), r.Name, allErrs)
+}
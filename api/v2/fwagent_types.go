@@ -0,0 +1,128 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DefaultHeartbeatPeriod is the heartbeat period of agents that do not
+	// report one.
+	DefaultHeartbeatPeriod = 30 * time.Second
+	// AgentTimeoutPeriods is how many heartbeat periods an agent may miss
+	// before it is considered dead.
+	AgentTimeoutPeriods = 3
+)
+
+// FwAgentSpec defines the desired state of FwAgent
+type FwAgentSpec struct {
+	// NodeName is the node the agent runs on.
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+}
+
+// InterfaceStatus is a network interface seen in a network namespace.
+type InterfaceStatus struct {
+	Name string `json:"name"`
+	// Up tells whether the interface is administratively up.
+	Up bool `json:"up"`
+	// Addresses are the IPv4 and IPv6 prefixes assigned to the interface.
+	// +optional
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// FwAgentStatus defines the observed state of FwAgent
+type FwAgentStatus struct {
+	// Version is the version of the agent binary.
+	// +optional
+	Version string `json:"version,omitempty"`
+	// Backend is the applier backend: nft, setns or fake.
+	// +optional
+	Backend string `json:"backend,omitempty"`
+	// Netns is the agent's default network namespace.
+	// +optional
+	Netns string `json:"netns,omitempty"`
+	// NftVersion is the version of nftables the backend drives.
+	// +optional
+	NftVersion string `json:"nftVersion,omitempty"`
+	// Interfaces are the interfaces of Netns.
+	// +optional
+	Interfaces []InterfaceStatus `json:"interfaces,omitempty"`
+	// FwLets are the FwLets, as namespace/name, enforced by the agent.
+	// +optional
+	FwLets []string `json:"fwLets,omitempty"`
+	// HeartbeatPeriod is how often the agent renews LastHeartbeatTime.
+	// +optional
+	HeartbeatPeriod metav1.Duration `json:"heartbeatPeriod,omitempty"`
+	// +optional
+	LastHeartbeatTime *metav1.Time `json:"lastHeartbeatTime,omitempty"`
+}
+
+// Expiry returns when the agent is considered dead unless it heartbeats
+// again. An agent that never heartbeat expired at the zero time.
+func (s *FwAgentStatus) Expiry() time.Time {
+	if s.LastHeartbeatTime == nil {
+		return time.Time{}
+	}
+	period := s.HeartbeatPeriod.Duration
+	if period <= 0 {
+		period = DefaultHeartbeatPeriod
+	}
+	return s.LastHeartbeatTime.Add(AgentTimeoutPeriods * period)
+}
+
+// Alive tells whether the agent heartbeat recently enough at now.
+func (s *FwAgentStatus) Alive(now time.Time) bool {
+	return now.Before(s.Expiry())
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Node",type=string,JSONPath=`.spec.nodeName`
+//+kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.status.version`
+//+kubebuilder:printcolumn:name="Backend",type=string,JSONPath=`.status.backend`
+//+kubebuilder:printcolumn:name="Nft",type=string,JSONPath=`.status.nftVersion`,priority=1
+//+kubebuilder:printcolumn:name="FwLets",type=string,JSONPath=`.status.fwLets`
+//+kubebuilder:printcolumn:name="Last Heartbeat",type=date,JSONPath=`.status.lastHeartbeatTime`
+
+// FwAgent is a fw-let agent, registered and renewed by the agent itself so
+// that FwMasters can tell whether their regions are enforced by a live
+// agent.
+type FwAgent struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FwAgentSpec   `json:"spec,omitempty"`
+	Status FwAgentStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// FwAgentList contains a list of FwAgent
+type FwAgentList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []FwAgent `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&FwAgent{}, &FwAgentList{})
+}
@@ -0,0 +1,341 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConversionDataAnnotation holds, in JSON, the spec and status of an object
+// as another API version saw them. Converting the object back to that
+// version restores them, as long as they were not changed in between, so
+// that fields one version cannot represent survive a round trip.
+const ConversionDataAnnotation = "samplecontroller.yossy.vsix.wide.ad.jp/conversion-data"
+
+// NodeLabel binds a FwLet to the agents started with the node name in its
+// value.
+const NodeLabel = "samplecontroller.yossy.vsix.wide.ad.jp/node"
+
+// FwLetSpec defines the desired state of FwLet
+type FwLetSpec struct {
+	// Zones are the interfaces of the firewall grouped by policy. The zones
+	// named trust and untrust are the downstream and upstream interfaces.
+	// +optional
+	Zones []Zone `json:"zones,omitempty"`
+	// ZonePolicies form the zone pair matrix.
+	// +optional
+	ZonePolicies []ZonePolicy `json:"zonePolicies,omitempty"`
+	// Rules are rendered in order into the PAIR_<from>_to_<to> chains.
+	// +optional
+	Rules []PolicyRule `json:"rules,omitempty"`
+	// ManagementAddresses are the prefixes allowed to reach the node itself.
+	// +optional
+	ManagementAddresses []string `json:"managementAddresses,omitempty"`
+	// Baseline is the ruleset left on the node when this FwLet is deleted.
+	// Defaults to the agent's --default-baseline.
+	// +optional
+	Baseline *BaselineSpec `json:"baseline,omitempty"`
+	// DriftPolicy decides what happens when the live ruleset is changed
+	// behind the controller's back. Defaults to Reapply.
+	// +optional
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+	// ICMPRateLimit is the rate at which the node accepts ICMP addressed
+	// to itself. Defaults to 10/second.
+	// +optional
+	ICMPRateLimit RateLimit `json:"icmpRateLimit,omitempty"`
+	// TemplateRef is the ConfigMap holding the ruleset template. Defaults
+	// to the template file baked into the agent image.
+	// +optional
+	TemplateRef *TemplateRef `json:"templateRef,omitempty"`
+	// Netns is the network namespace the ruleset is enforced in. Defaults
+	// to the agent's --netns.
+	// +optional
+	Netns *NetworkNamespace `json:"netns,omitempty"`
+	// NodeName is the node whose agent enforces the FwLet. It is copied
+	// into the NodeLabel label, on which agents select the FwLets they
+	// watch.
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+}
+
+// NetworkNamespace selects a network namespace on the agent's node.
+// Exactly one of the fields is set.
+type NetworkNamespace struct {
+	// Name is a namespace under /var/run/netns, as created by
+	// `ip netns add`.
+	// +optional
+	Name string `json:"name,omitempty"`
+	// Path is a namespace file, /proc/<pid>/ns/net or /var/run/netns/<name>.
+	// The agent refuses its own namespace and the host's.
+	// +optional
+	Path string `json:"path,omitempty"`
+	// Container is the name or ID of a running container, whose namespace
+	// the agent looks up through its container runtime socket.
+	// +optional
+	Container string `json:"container,omitempty"`
+}
+
+// Interfaces returns the interfaces of the named zone.
+func (s *FwLetSpec) Interfaces(zone ZoneName) []string {
+	return zoneInterfaces(s.Zones, zone)
+}
+
+func zoneInterfaces(zones []Zone, name ZoneName) []string {
+	for _, z := range zones {
+		if z.Name == name {
+			return z.Interfaces
+		}
+	}
+	return nil
+}
+
+// DriftPolicy is the reaction to a live ruleset that drifted.
+// +kubebuilder:validation:Enum=Reapply;Report
+type DriftPolicy string
+
+const (
+	// DriftPolicyReapply restores the applied ruleset.
+	DriftPolicyReapply DriftPolicy = "Reapply"
+	// DriftPolicyReport only sets the DriftDetected condition.
+	DriftPolicyReport DriftPolicy = "Report"
+)
+
+// BaselineMode selects the ruleset restored when a FwLet is deleted.
+// +kubebuilder:validation:Enum=FailOpen;FailClosed;Template
+type BaselineMode string
+
+const (
+	// BaselineFailOpen flushes the ruleset, letting all traffic through.
+	BaselineFailOpen BaselineMode = "FailOpen"
+	// BaselineFailClosed drops all new inbound and forwarded traffic.
+	BaselineFailClosed BaselineMode = "FailClosed"
+	// BaselineTemplate loads a named ruleset from the agent's baseline directory.
+	BaselineTemplate BaselineMode = "Template"
+)
+
+type BaselineSpec struct {
+	Mode BaselineMode `json:"mode"`
+	// Template is the name of the ruleset file used with Mode Template.
+	// +optional
+	Template string `json:"template,omitempty"`
+}
+
+// ZoneName is the name of a zone.
+// +kubebuilder:validation:Pattern=`^[a-z][a-z0-9_]*$`
+// +kubebuilder:validation:MaxLength=24
+type ZoneName string
+
+const (
+	ZoneTrust   ZoneName = "trust"
+	ZoneUntrust ZoneName = "untrust"
+)
+
+// Zone is a named set of interfaces. Traffic leaving through them is
+// dispatched by the ZONE_<NAME> chain.
+type Zone struct {
+	Name       ZoneName `json:"name"`
+	Interfaces []string `json:"interfaces"`
+}
+
+// Verdict is the default verdict of a zone pair.
+// +kubebuilder:validation:Enum=accept;drop;reject
+type Verdict string
+
+// ZonePolicy sets the verdict for traffic from one zone to another that no
+// rule matched. Pairs without a policy drop, except trust to untrust which
+// accepts.
+type ZonePolicy struct {
+	From    ZoneName `json:"from"`
+	To      ZoneName `json:"to"`
+	Default Verdict  `json:"default"`
+}
+
+// RuleAction is what a policy rule does with matching traffic. Log logs
+// the packet and goes on with the next rule.
+// +kubebuilder:validation:Enum=accept;drop;reject;log
+type RuleAction string
+
+const (
+	RuleActionAccept RuleAction = "accept"
+	RuleActionDrop   RuleAction = "drop"
+	RuleActionReject RuleAction = "reject"
+	RuleActionLog    RuleAction = "log"
+)
+
+// PortRange is a port number or a range such as "8000-8080".
+// +kubebuilder:validation:Pattern=`^[0-9]{1,5}(-[0-9]{1,5})?$`
+type PortRange string
+
+// RateLimit is an nft rate such as "10/second".
+// +kubebuilder:validation:Pattern=`^[1-9][0-9]{0,8}/(second|minute|hour|day|week)$`
+type RateLimit string
+
+// PolicyRule matches traffic from one zone to another.
+type PolicyRule struct {
+	// Name is used as the comment and log prefix of the rule.
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9._-]*$`
+	// +kubebuilder:validation:MaxLength=125
+	// +optional
+	Name string   `json:"name,omitempty"`
+	From ZoneName `json:"from"`
+	To   ZoneName `json:"to"`
+	// Source and Destination are IPv4 or IPv6 prefixes. Empty matches any.
+	// +optional
+	Source []string `json:"source,omitempty"`
+	// +optional
+	Destination []string `json:"destination,omitempty"`
+	// SourceGroups and DestinationGroups name AddressGroups whose
+	// addresses are added to Source and Destination. Only rules of a
+	// FwMaster region may use them: the FwMaster passes the addresses on
+	// to the region's FwLet.
+	// +optional
+	SourceGroups []string `json:"sourceGroups,omitempty"`
+	// +optional
+	DestinationGroups []string `json:"destinationGroups,omitempty"`
+	// +kubebuilder:validation:Enum=tcp;udp;sctp;icmp;icmpv6
+	// +optional
+	Protocol string `json:"protocol,omitempty"`
+	// Ports are destination ports, for tcp, udp and sctp.
+	// +optional
+	Ports []PortRange `json:"ports,omitempty"`
+	// ICMPTypes are type names such as echo-request, for icmp and icmpv6.
+	// +optional
+	ICMPTypes []string `json:"icmpTypes,omitempty"`
+	// ServiceGroups name ServiceGroups whose services the rule matches
+	// besides Protocol, Ports and ICMPTypes, if set.
+	// +optional
+	ServiceGroups []string   `json:"serviceGroups,omitempty"`
+	Action        RuleAction `json:"action"`
+}
+
+// DefaultTemplateKey is the ConfigMap key read when TemplateRef.Key is empty.
+const DefaultTemplateKey = "fw-template.rule"
+
+// TemplateRef selects a key of a ConfigMap in the namespace of the object.
+type TemplateRef struct {
+	Name string `json:"name"`
+	// Key defaults to DefaultTemplateKey.
+	// +optional
+	Key string `json:"key,omitempty"`
+}
+
+// FwLetStatus defines the observed state of FwLet
+type FwLetStatus struct {
+	// Zones are the trust and untrust interfaces read back from the
+	// enforced ruleset.
+	// +optional
+	Zones []Zone `json:"zones,omitempty"`
+	// ManagementAddresses are read back from the enforced ruleset.
+	// +optional
+	ManagementAddresses []string `json:"managementAddresses,omitempty"`
+	// Netns is the network namespace the ruleset is enforced in, as a
+	// name under /var/run/netns or a path.
+	// +optional
+	Netns string `json:"netns,omitempty"`
+	// Agent is the FwAgent enforcing the FwLet.
+	// +optional
+	Agent string `json:"agent,omitempty"`
+	// Links are the network interfaces of Netns.
+	// +optional
+	Links []InterfaceStatus `json:"links,omitempty"`
+	// MissingInterfaces are the zone interfaces that are not among Links.
+	// Rules on them never match until the interfaces are created.
+	// +optional
+	MissingInterfaces []string `json:"missingInterfaces,omitempty"`
+
+	// +listType=map
+	// +listMapKey=type
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	// LastAppliedTime is when the current ruleset was applied.
+	LastAppliedTime *metav1.Time `json:"lastAppliedTime,omitempty"`
+	// RulesetHash is the sha256 of the ruleset file being enforced.
+	RulesetHash string `json:"rulesetHash,omitempty"`
+	// LiveRulesetHash is the sha256 of the kernel's ruleset dump taken
+	// right after the ruleset was applied. Drift is a dump that no longer
+	// hashes to it.
+	LiveRulesetHash string `json:"liveRulesetHash,omitempty"`
+	// LastAppliedRevision is the history revision of the ruleset that was
+	// last applied and confirmed. Rollbacks restore this revision.
+	LastAppliedRevision int64 `json:"lastAppliedRevision,omitempty"`
+	// FailedRulesetHash is the sha256 of the rendered ruleset that was
+	// rolled back. It is not applied again until the spec or the template
+	// renders another ruleset.
+	// +optional
+	FailedRulesetHash string `json:"failedRulesetHash,omitempty"`
+	// TemplateRevision identifies the template of the applied ruleset:
+	// "<configmap>/<key>@<resourceVersion>" or the path of the agent's
+	// template file.
+	TemplateRevision string `json:"templateRevision,omitempty"`
+	// TemplateHash is the sha256 of that template. A template whose hash
+	// differs is re-rendered.
+	TemplateHash string `json:"templateHash,omitempty"`
+	// ServiceGroups are the ServiceGroups referenced by the rules of the
+	// applied ruleset, with the services they resolved to.
+	// +optional
+	ServiceGroups []ResolvedServiceGroup `json:"serviceGroups,omitempty"`
+}
+
+// ResolvedServiceGroup is a ServiceGroup as it was rendered into the
+// ruleset.
+type ResolvedServiceGroup struct {
+	Name string `json:"name"`
+	// +optional
+	Services []Service `json:"services,omitempty"`
+}
+
+// Interfaces returns the observed interfaces of the named zone.
+func (s *FwLetStatus) Interfaces(zone ZoneName) []string {
+	return zoneInterfaces(s.Zones, zone)
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+//+kubebuilder:printcolumn:name="Node",type=string,JSONPath=`.spec.nodeName`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Applied",type=string,JSONPath=`.status.conditions[?(@.type=="Applied")].reason`
+//+kubebuilder:printcolumn:name="Untrust",type=string,JSONPath=`.status.zones[?(@.name=="untrust")].interfaces`
+//+kubebuilder:printcolumn:name="Revision",type=integer,JSONPath=`.status.lastAppliedRevision`
+//+kubebuilder:printcolumn:name="Hash",type=string,JSONPath=`.status.rulesetHash`,priority=1
+//+kubebuilder:printcolumn:name="Template",type=string,JSONPath=`.status.templateRevision`,priority=1
+//+kubebuilder:printcolumn:name="Netns",type=string,JSONPath=`.status.netns`,priority=1
+//+kubebuilder:printcolumn:name="Missing",type=string,JSONPath=`.status.missingInterfaces`,priority=1
+//+kubebuilder:printcolumn:name="Last Applied",type=date,JSONPath=`.status.lastAppliedTime`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// FwLet is the Schema for the fwlets API
+type FwLet struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FwLetSpec   `json:"spec,omitempty"`
+	Status FwLetStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// FwLetList contains a list of FwLet
+type FwLetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []FwLet `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&FwLet{}, &FwLetList{})
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// SetupWebhookWithManager registers the webhooks of FwLet, including the
+// conversion webhook serving every version of the kind, with the manager.
+func (r *FwLet) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-samplecontroller-yossy-vsix-wide-ad-jp-v2-fwlet,mutating=true,failurePolicy=fail,sideEffects=None,groups=samplecontroller.yossy.vsix.wide.ad.jp,resources=fwlets,verbs=create;update,versions=v2,name=mfwlet.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Defaulter = &FwLet{}
+
+// Default implements webhook.Defaulter. It labels a FwLet with its
+// spec.nodeName, so that the agent of the node can select it.
+func (r *FwLet) Default() {
+	r.setNodeLabel()
+}
+
+// setNodeLabel copies spec.nodeName into NodeLabel. Without nodeName the
+// label is left alone, so FwLets can be bound by label only.
+func (r *FwLet) setNodeLabel() {
+	if r.Spec.NodeName == "" {
+		return
+	}
+	labels := r.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[NodeLabel] = r.Spec.NodeName
+	r.SetLabels(labels)
+}
+
+//+kubebuilder:webhook:path=/validate-samplecontroller-yossy-vsix-wide-ad-jp-v2-fwlet,mutating=false,failurePolicy=fail,sideEffects=None,groups=samplecontroller.yossy.vsix.wide.ad.jp,resources=fwlets,verbs=create;update,versions=v2,name=vfwlet.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &FwLet{}
+
+// ValidateCreate implements webhook.Validator.
+func (r *FwLet) ValidateCreate() (admission.Warnings, error) {
+	return nil, r.validate()
+}
+
+// ValidateUpdate implements webhook.Validator. An object being deleted is
+// not validated so that its finalizers can still be removed.
+func (r *FwLet) ValidateUpdate(old runtime.Object) (admission.Warnings, error) {
+	if !r.DeletionTimestamp.IsZero() {
+		return nil, nil
+	}
+	return nil, r.validate()
+}
+
+// ValidateDelete implements webhook.Validator.
+func (r *FwLet) ValidateDelete() (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (r *FwLet) validate() error {
+	allErrs := r.Spec.validate(field.NewPath("spec"))
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("FwLet").GroupKind(), r.Name, allErrs)
+}
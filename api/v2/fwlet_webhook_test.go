@@ -0,0 +1,25 @@
+package v2
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFwLetDefault(t *testing.T) {
+	fwl := &FwLet{
+		ObjectMeta: metav1.ObjectMeta{Name: "kote", Labels: map[string]string{NodeLabel: "node1", "app": "fw"}},
+		Spec:       FwLetSpec{NodeName: "node2"},
+	}
+	fwl.Default()
+	if got := fwl.Labels[NodeLabel]; got != "node2" || fwl.Labels["app"] != "fw" {
+		t.Errorf("labels = %v, want %s=node2", fwl.Labels, NodeLabel)
+	}
+
+	// A FwLet without nodeName keeps a node label set by hand.
+	fwl = &FwLet{ObjectMeta: metav1.ObjectMeta{Name: "note", Labels: map[string]string{NodeLabel: "node1"}}}
+	fwl.Default()
+	if got := fwl.Labels[NodeLabel]; got != "node1" {
+		t.Errorf("label %s = %q, want node1", NodeLabel, got)
+	}
+}
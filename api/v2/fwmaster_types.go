@@ -0,0 +1,192 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// RegionDeletionPolicyAnnotation on a FwMaster controls what happens
+	// to the FwLet of a region removed from spec. The FwLet is deleted
+	// unless the value is RegionDeletionPolicyOrphan, in which case it is
+	// released from the FwMaster and left running.
+	RegionDeletionPolicyAnnotation = "samplecontroller.yossy.vsix.wide.ad.jp/region-deletion-policy"
+	RegionDeletionPolicyDelete     = "delete"
+	RegionDeletionPolicyOrphan     = "orphan"
+)
+
+// FwMasterSpec defines the desired state of FwMaster
+type FwMasterSpec struct {
+	Regions []RegionSpec `json:"regions"`
+	// ManagementAddresses are passed on to every region's FwLet that does
+	// not set its own.
+	// +optional
+	ManagementAddresses []string `json:"managementAddresses,omitempty"`
+	// ManagementAddressGroups name AddressGroups whose addresses are added
+	// to ManagementAddresses.
+	// +optional
+	ManagementAddressGroups []string `json:"managementAddressGroups,omitempty"`
+	// Defaults fill the fields a region leaves empty. They are copied into
+	// the regions when the FwMaster is written, so changing them does not
+	// affect regions that were already defaulted, and applied again to the
+	// regions' FwLets in case the FwMaster was written without webhook.
+	// +optional
+	Defaults *RegionDefaults `json:"defaults,omitempty"`
+	// TemplateRef is passed on to every region's FwLet that does not set
+	// its own.
+	// +optional
+	TemplateRef *TemplateRef `json:"templateRef,omitempty"`
+}
+
+// RegionDefaults are the settings a region inherits from its FwMaster.
+type RegionDefaults struct {
+	// ZonePolicies are added to a region that has both zones of the pair
+	// and no policy of its own for it.
+	// +optional
+	ZonePolicies []ZonePolicy `json:"zonePolicies,omitempty"`
+	// +optional
+	Baseline *BaselineSpec `json:"baseline,omitempty"`
+	// DriftPolicy defaults to Reapply.
+	// +optional
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+	// +optional
+	ICMPRateLimit RateLimit `json:"icmpRateLimit,omitempty"`
+}
+
+// RegionSpec is the firewall of a region, rendered by the FwLet of the
+// same name.
+type RegionSpec struct {
+	Name string `json:"name"`
+	// ManagementAddresses replace the FwMaster's ManagementAddresses for
+	// this region.
+	// +optional
+	ManagementAddresses []string `json:"managementAddresses,omitempty"`
+	// AdditionalManagementAddresses are appended to the region's
+	// ManagementAddresses, or to the FwMaster's if it sets none.
+	// +optional
+	AdditionalManagementAddresses []string `json:"additionalManagementAddresses,omitempty"`
+	// ManagementAddressGroups name AddressGroups whose addresses are added
+	// to the region's AdditionalManagementAddresses.
+	// +optional
+	ManagementAddressGroups []string `json:"managementAddressGroups,omitempty"`
+	// Zones, ZonePolicies and Rules are passed on to the region's FwLet.
+	// +optional
+	Zones []Zone `json:"zones,omitempty"`
+	// +optional
+	ZonePolicies []ZonePolicy `json:"zonePolicies,omitempty"`
+	// +optional
+	Rules []PolicyRule `json:"rules,omitempty"`
+	// Baseline is passed on to the region's FwLet.
+	// +optional
+	Baseline *BaselineSpec `json:"baseline,omitempty"`
+	// DriftPolicy and ICMPRateLimit are passed on to the region's FwLet.
+	// +optional
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+	// +optional
+	ICMPRateLimit RateLimit `json:"icmpRateLimit,omitempty"`
+	// TemplateRef overrides the FwMaster's TemplateRef for this region.
+	// +optional
+	TemplateRef *TemplateRef `json:"templateRef,omitempty"`
+	// Netns is passed on to the region's FwLet.
+	// +optional
+	Netns *NetworkNamespace `json:"netns,omitempty"`
+	// NodeName places the region's FwLet on the agent of that node.
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+}
+
+// Interfaces returns the interfaces of the named zone.
+func (s *RegionSpec) Interfaces(zone ZoneName) []string {
+	return zoneInterfaces(s.Zones, zone)
+}
+
+// RegionManagementAddresses returns the management addresses of region r.
+func (s *FwMasterSpec) RegionManagementAddresses(r *RegionSpec) []string {
+	base := s.ManagementAddresses
+	if len(r.ManagementAddresses) > 0 {
+		base = r.ManagementAddresses
+	}
+	if len(r.AdditionalManagementAddresses) == 0 {
+		return base
+	}
+	addrs := make([]string, 0, len(base)+len(r.AdditionalManagementAddresses))
+	addrs = append(addrs, base...)
+	return append(addrs, r.AdditionalManagementAddresses...)
+}
+
+type RegionStatus struct {
+	Name string `json:"name"`
+	// Zones and ManagementAddresses are the ones the region's FwLet was
+	// created with.
+	// +optional
+	Zones []Zone `json:"zones,omitempty"`
+	// +optional
+	ManagementAddresses []string `json:"managementAddresses,omitempty"`
+	Created             bool     `json:"created"`
+	// Ready mirrors the Ready condition of the region's FwLet.
+	Ready           bool         `json:"ready,omitempty"`
+	LastAppliedTime *metav1.Time `json:"lastAppliedTime,omitempty"`
+	RulesetHash     string       `json:"rulesetHash,omitempty"`
+	// Agent is the FwAgent enforcing the region's FwLet.
+	// +optional
+	Agent string `json:"agent,omitempty"`
+	// AgentAlive tells whether Agent heartbeats.
+	AgentAlive bool `json:"agentAlive,omitempty"`
+}
+
+// FwMasterStatus defines the observed state of FwMaster
+type FwMasterStatus struct {
+	// +optional
+	Regions []RegionStatus `json:"regions,omitempty"`
+
+	// +listType=map
+	// +listMapKey=type
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Degraded",type=string,JSONPath=`.status.conditions[?(@.type=="Degraded")].status`
+//+kubebuilder:printcolumn:name="Drift",type=string,JSONPath=`.status.conditions[?(@.type=="DriftDetected")].status`
+//+kubebuilder:printcolumn:name="Agents",type=string,JSONPath=`.status.conditions[?(@.type=="AgentsAlive")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// FwMaster is the Schema for the fwmasters API
+type FwMaster struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FwMasterSpec   `json:"spec,omitempty"`
+	Status FwMasterStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// FwMasterList contains a list of FwMaster
+type FwMasterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []FwMaster `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&FwMaster{}, &FwMasterList{})
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// SetupWebhookWithManager registers the webhooks of FwMaster, including the
+// conversion webhook serving every version of the kind, with the manager.
+func (r *FwMaster) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-samplecontroller-yossy-vsix-wide-ad-jp-v2-fwmaster,mutating=true,failurePolicy=fail,sideEffects=None,groups=samplecontroller.yossy.vsix.wide.ad.jp,resources=fwmasters,verbs=create;update,versions=v2,name=mfwmaster.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Defaulter = &FwMaster{}
+
+// Default implements webhook.Defaulter. It fills the regions from
+// Spec.Defaults so that every FwLet is created fully specified.
+func (r *FwMaster) Default() {
+	for i := range r.Spec.Regions {
+		r.Spec.Regions[i].SetDefaults(r.Spec.Defaults)
+	}
+}
+
+// SetDefaults fills the fields of the region left empty from d, which may
+// be nil.
+func (s *RegionSpec) SetDefaults(d *RegionDefaults) {
+	if d != nil {
+		if s.Baseline == nil && d.Baseline != nil {
+			s.Baseline = d.Baseline.DeepCopy()
+		}
+		if s.DriftPolicy == "" {
+			s.DriftPolicy = d.DriftPolicy
+		}
+		if s.ICMPRateLimit == "" {
+			s.ICMPRateLimit = d.ICMPRateLimit
+		}
+		for _, p := range d.ZonePolicies {
+			if s.hasZone(p.From) && s.hasZone(p.To) && !s.hasZonePolicy(p.From, p.To) {
+				s.ZonePolicies = append(s.ZonePolicies, p)
+			}
+		}
+	}
+	if s.DriftPolicy == "" {
+		s.DriftPolicy = DriftPolicyReapply
+	}
+}
+
+func (s *RegionSpec) hasZone(name ZoneName) bool {
+	for _, z := range s.Zones {
+		if z.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *RegionSpec) hasZonePolicy(from, to ZoneName) bool {
+	for _, p := range s.ZonePolicies {
+		if p.From == from && p.To == to {
+			return true
+		}
+	}
+	return false
+}
+
+//+kubebuilder:webhook:path=/validate-samplecontroller-yossy-vsix-wide-ad-jp-v2-fwmaster,mutating=false,failurePolicy=fail,sideEffects=None,groups=samplecontroller.yossy.vsix.wide.ad.jp,resources=fwmasters,verbs=create;update,versions=v2,name=vfwmaster.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &FwMaster{}
+
+// ValidateCreate implements webhook.Validator.
+func (r *FwMaster) ValidateCreate() (admission.Warnings, error) {
+	return nil, r.validate()
+}
+
+// ValidateUpdate implements webhook.Validator. An object being deleted is
+// not validated so that its finalizers can still be removed.
+func (r *FwMaster) ValidateUpdate(old runtime.Object) (admission.Warnings, error) {
+	if !r.DeletionTimestamp.IsZero() {
+		return nil, nil
+	}
+	return nil, r.validate()
+}
+
+// ValidateDelete implements webhook.Validator.
+func (r *FwMaster) ValidateDelete() (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (r *FwMaster) validate() error {
+	allErrs := r.Spec.validate(field.NewPath("spec"))
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("FwMaster").GroupKind(), r.Name, allErrs)
+}
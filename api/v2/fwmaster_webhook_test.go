@@ -0,0 +1,60 @@
+package v2
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFwMasterDefault(t *testing.T) {
+	fwm := &FwMaster{
+		Spec: FwMasterSpec{
+			Regions: []RegionSpec{
+				{Name: "kote", Zones: []Zone{
+					{Name: ZoneTrust, Interfaces: []string{"eth-a"}},
+					{Name: ZoneUntrust, Interfaces: []string{"vsix-bb"}},
+					{Name: "dmz", Interfaces: []string{"eth-d"}},
+				}, ZonePolicies: []ZonePolicy{{From: "dmz", To: ZoneUntrust, Default: "drop"}}},
+				{Name: "note", Zones: []Zone{
+					{Name: ZoneTrust, Interfaces: []string{"eth-a"}},
+					{Name: ZoneUntrust, Interfaces: []string{"vsix-bb"}},
+				}, DriftPolicy: DriftPolicyReport, Baseline: &BaselineSpec{Mode: BaselineFailClosed}, ICMPRateLimit: "1/second"},
+			},
+			Defaults: &RegionDefaults{
+				ZonePolicies: []ZonePolicy{
+					{From: "dmz", To: ZoneUntrust, Default: "accept"},
+					{From: ZoneTrust, To: "dmz", Default: "accept"},
+				},
+				Baseline:      &BaselineSpec{Mode: BaselineFailOpen},
+				ICMPRateLimit: "5/second",
+			},
+		},
+	}
+	fwm.Default()
+
+	kote := fwm.Spec.Regions[0]
+	wantPolicies := []ZonePolicy{
+		{From: "dmz", To: ZoneUntrust, Default: "drop"},
+		{From: ZoneTrust, To: "dmz", Default: "accept"},
+	}
+	if !reflect.DeepEqual(kote.ZonePolicies, wantPolicies) {
+		t.Errorf("kote ZonePolicies = %+v, want %+v", kote.ZonePolicies, wantPolicies)
+	}
+	if kote.Baseline == nil || kote.Baseline.Mode != BaselineFailOpen || kote.DriftPolicy != DriftPolicyReapply || kote.ICMPRateLimit != "5/second" {
+		t.Errorf("kote not defaulted: %+v", kote)
+	}
+
+	note := fwm.Spec.Regions[1]
+	if len(note.ZonePolicies) != 0 {
+		t.Errorf("note got policies for zones it does not have: %+v", note.ZonePolicies)
+	}
+	if note.Baseline.Mode != BaselineFailClosed || note.DriftPolicy != DriftPolicyReport || note.ICMPRateLimit != "1/second" {
+		t.Errorf("note settings overwritten: %+v", note)
+	}
+
+	// Defaulting twice changes nothing.
+	again := fwm.DeepCopy()
+	again.Default()
+	if !reflect.DeepEqual(again, fwm) {
+		t.Errorf("Default() is not idempotent")
+	}
+}
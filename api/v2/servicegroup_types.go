@@ -0,0 +1,65 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Service is a protocol with destination ports or ICMP types. A service
+// without ports or types matches the whole protocol.
+type Service struct {
+	// +kubebuilder:validation:Enum=tcp;udp;sctp;icmp;icmpv6
+	Protocol string `json:"protocol"`
+	// Ports are destination ports, for tcp, udp and sctp.
+	// +optional
+	Ports []PortRange `json:"ports,omitempty"`
+	// ICMPTypes are type names such as echo-request, for icmp and icmpv6.
+	// +optional
+	ICMPTypes []string `json:"icmpTypes,omitempty"`
+}
+
+// ServiceGroupSpec defines the desired state of ServiceGroup
+type ServiceGroupSpec struct {
+	// +kubebuilder:validation:MinItems=1
+	Services []Service `json:"services"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ServiceGroup is a named set of protocols and ports, such as ssh or bgp,
+// that rules of the same namespace reference instead of repeating them.
+type ServiceGroup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ServiceGroupSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ServiceGroupList contains a list of ServiceGroup
+type ServiceGroupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ServiceGroup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ServiceGroup{}, &ServiceGroupList{})
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// SetupWebhookWithManager registers the webhooks of ServiceGroup with the
+// manager.
+func (r *ServiceGroup) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-samplecontroller-yossy-vsix-wide-ad-jp-v2-servicegroup,mutating=false,failurePolicy=fail,sideEffects=None,groups=samplecontroller.yossy.vsix.wide.ad.jp,resources=servicegroups,verbs=create;update,versions=v2,name=vservicegroup.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &ServiceGroup{}
+
+// ValidateCreate implements webhook.Validator.
+func (r *ServiceGroup) ValidateCreate() (admission.Warnings, error) {
+	return nil, r.validate()
+}
+
+// ValidateUpdate implements webhook.Validator.
+func (r *ServiceGroup) ValidateUpdate(old runtime.Object) (admission.Warnings, error) {
+	return nil, r.validate()
+}
+
+// ValidateDelete implements webhook.Validator. Deleting a group still
+// referenced makes the FwLets using it fail to render and keep their
+// current ruleset.
+func (r *ServiceGroup) ValidateDelete() (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (r *ServiceGroup) validate() error {
+	path := field.NewPath("spec", "services")
+	var allErrs field.ErrorList
+	if len(r.Spec.Services) == 0 {
+		allErrs = append(allErrs, field.Required(path, "a service group needs at least one service"))
+	}
+	for i, s := range r.Spec.Services {
+		if s.Protocol == "" {
+			allErrs = append(allErrs, field.Required(path.Index(i).Child("protocol"), ""))
+		}
+		allErrs = append(allErrs, validateService(s.Protocol, s.Ports, s.ICMPTypes, path.Index(i))...)
+	}
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("ServiceGroup").GroupKind(), r.Name, allErrs)
+}
@@ -0,0 +1,424 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"fmt"
+	"net/netip"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/Yosshi72/fw-controller/pkg/fwconfig"
+)
+
+// maxInterfaceNameLength is IFNAMSIZ without the terminating NUL.
+const maxInterfaceNameLength = 15
+
+// validateInterfaceName applies the rules of the kernel's dev_valid_name.
+func validateInterfaceName(name string, path *field.Path) field.ErrorList {
+	switch {
+	case name == "":
+		return field.ErrorList{field.Required(path, "")}
+	case len(name) > maxInterfaceNameLength:
+		return field.ErrorList{field.TooLong(path, name, maxInterfaceNameLength)}
+	case name == "." || name == "..":
+		return field.ErrorList{field.Invalid(path, name, "must not be . or ..")}
+	case strings.ContainsAny(name, "/:") || strings.IndexFunc(name, unicode.IsSpace) >= 0:
+		return field.ErrorList{field.Invalid(path, name, "must not contain '/', ':' or whitespace")}
+	// The kernel takes these, but nft strings cannot hold them.
+	case strings.ContainsAny(name, `"\`) || strings.IndexFunc(name, unicode.IsControl) >= 0:
+		return field.ErrorList{field.Invalid(path, name, `must not contain '"', '\' or control characters`)}
+	}
+	return nil
+}
+
+// parsePrefix parses an IPv4 or IPv6 prefix. An address is taken as a host
+// prefix. Bits beyond the prefix length must be zero.
+func parsePrefix(s string, path *field.Path) (netip.Prefix, *field.Error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil || addr.Zone() != "" {
+			return netip.Prefix{}, field.Invalid(path, s, "must be an IPv4 or IPv6 prefix")
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, field.Invalid(path, s, "must be an IPv4 or IPv6 prefix")
+	}
+	if p != p.Masked() {
+		return netip.Prefix{}, field.Invalid(path, s, fmt.Sprintf("has host bits set, did you mean %s", p.Masked()))
+	}
+	return p, nil
+}
+
+// validatePrefixes parses every prefix of list and rejects duplicates and
+// prefixes overlapping each other or one of base. It returns the prefixes
+// of list that parsed.
+func validatePrefixes(list []string, path *field.Path, base ...netip.Prefix) ([]netip.Prefix, field.ErrorList) {
+	var allErrs field.ErrorList
+	prefixes := base[:len(base):len(base)]
+	for i, s := range list {
+		p, err := parsePrefix(s, path.Index(i))
+		if err != nil {
+			allErrs = append(allErrs, err)
+			continue
+		}
+		for _, q := range prefixes {
+			if p == q {
+				allErrs = append(allErrs, field.Duplicate(path.Index(i), s))
+				break
+			}
+			if p.Overlaps(q) {
+				allErrs = append(allErrs, field.Invalid(path.Index(i), s, fmt.Sprintf("overlaps %s", q)))
+				break
+			}
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes[len(base):], allErrs
+}
+
+// validatePort checks a port number or a range such as "8000-8080".
+func validatePort(port PortRange, path *field.Path) *field.Error {
+	lo, hi, isRange := strings.Cut(string(port), "-")
+	if !isRange {
+		hi = lo
+	}
+	first, err1 := strconv.Atoi(lo)
+	last, err2 := strconv.Atoi(hi)
+	switch {
+	case err1 != nil || err2 != nil:
+		return field.Invalid(path, port, "must be a port or a range of ports")
+	case first < 1 || last > 65535:
+		return field.Invalid(path, port, "ports must be between 1 and 65535")
+	case first > last:
+		return field.Invalid(path, port, "range must not be empty")
+	}
+	return nil
+}
+
+// validateZones checks that zone names are unique and that every interface
+// belongs to one zone. It returns the names of the zones.
+func validateZones(zones []Zone, path *field.Path) (map[ZoneName]bool, field.ErrorList) {
+	var allErrs field.ErrorList
+	names := map[ZoneName]bool{}
+	owner := map[string]ZoneName{}
+	for i, z := range zones {
+		zpath := path.Index(i)
+		if names[z.Name] {
+			allErrs = append(allErrs, field.Duplicate(zpath.Child("name"), z.Name))
+		}
+		names[z.Name] = true
+		if len(z.Interfaces) == 0 {
+			allErrs = append(allErrs, field.Required(zpath.Child("interfaces"), "a zone needs at least one interface"))
+		}
+		for j, ifname := range z.Interfaces {
+			ipath := zpath.Child("interfaces").Index(j)
+			if errs := validateInterfaceName(ifname, ipath); len(errs) > 0 {
+				allErrs = append(allErrs, errs...)
+				continue
+			}
+			if other, ok := owner[ifname]; ok {
+				if other == z.Name {
+					allErrs = append(allErrs, field.Duplicate(ipath, ifname))
+				} else {
+					allErrs = append(allErrs, field.Invalid(ipath, ifname, fmt.Sprintf("is already in zone %s", other)))
+				}
+				continue
+			}
+			owner[ifname] = z.Name
+		}
+	}
+	return names, allErrs
+}
+
+// validateZonePair checks that a zone pair references two distinct zones.
+// A nil zones accepts any zone.
+func validateZonePair(from, to ZoneName, zones map[ZoneName]bool, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if zones != nil && !zones[from] {
+		allErrs = append(allErrs, field.NotFound(path.Child("from"), from))
+	}
+	if zones != nil && !zones[to] {
+		allErrs = append(allErrs, field.NotFound(path.Child("to"), to))
+	}
+	if from == to {
+		allErrs = append(allErrs, field.Invalid(path.Child("to"), to, "must differ from from"))
+	}
+	return allErrs
+}
+
+func validateZonePolicies(policies []ZonePolicy, zones map[ZoneName]bool, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	seen := map[[2]ZoneName]bool{}
+	for i, p := range policies {
+		allErrs = append(allErrs, validateZonePair(p.From, p.To, zones, path.Index(i))...)
+		pair := [2]ZoneName{p.From, p.To}
+		if seen[pair] {
+			allErrs = append(allErrs, field.Duplicate(path.Index(i), fmt.Sprintf("%s to %s", p.From, p.To)))
+		}
+		seen[pair] = true
+	}
+	return allErrs
+}
+
+// validateGroupNames checks references to AddressGroups.
+func validateGroupNames(names []string, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	seen := map[string]bool{}
+	for i, name := range names {
+		for _, msg := range validation.IsDNS1123Subdomain(name) {
+			allErrs = append(allErrs, field.Invalid(path.Index(i), name, msg))
+		}
+		if seen[name] {
+			allErrs = append(allErrs, field.Duplicate(path.Index(i), name))
+		}
+		seen[name] = true
+	}
+	return allErrs
+}
+
+// validateRules checks rules. AddressGroups are only resolved for the rules
+// of FwMaster regions, so allowGroups is false for the rules of a FwLet.
+// ServiceGroups are resolved by the FwLet and allowed in both.
+func validateRules(rules []PolicyRule, zones map[ZoneName]bool, allowGroups bool, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for i, r := range rules {
+		rpath := path.Index(i)
+		switch {
+		case len(r.Name) > fwconfig.MaxRuleNameLength:
+			allErrs = append(allErrs, field.TooLong(rpath.Child("name"), r.Name, fwconfig.MaxRuleNameLength))
+		case fwconfig.CheckRuleName(r.Name) != nil:
+			allErrs = append(allErrs, field.Invalid(rpath.Child("name"), r.Name, "may only hold letters, digits, '-', '_' and '.'"))
+		}
+		allErrs = append(allErrs, validateZonePair(r.From, r.To, zones, rpath)...)
+		for _, g := range []struct {
+			name  string
+			names []string
+		}{{"sourceGroups", r.SourceGroups}, {"destinationGroups", r.DestinationGroups}} {
+			switch {
+			case allowGroups:
+				allErrs = append(allErrs, validateGroupNames(g.names, rpath.Child(g.name))...)
+			case len(g.names) > 0:
+				allErrs = append(allErrs, field.Forbidden(rpath.Child(g.name), "only allowed in the rules of a FwMaster region"))
+			}
+		}
+		src, errs := validatePrefixes(r.Source, rpath.Child("source"))
+		allErrs = append(allErrs, errs...)
+		dst, errs := validatePrefixes(r.Destination, rpath.Child("destination"))
+		allErrs = append(allErrs, errs...)
+		if len(errs) == 0 && len(src) > 0 && len(dst) > 0 && !sharesFamily(src, dst) {
+			allErrs = append(allErrs, field.Invalid(rpath.Child("destination"), r.Destination,
+				"needs a prefix of the same address family as source"))
+		}
+
+		allErrs = append(allErrs, validateService(r.Protocol, r.Ports, r.ICMPTypes, rpath)...)
+		allErrs = append(allErrs, validateGroupNames(r.ServiceGroups, rpath.Child("serviceGroups"))...)
+	}
+	return allErrs
+}
+
+// validateService checks that ports and icmp types fit the protocol, which
+// may be empty for any protocol.
+func validateService(protocol string, ports []PortRange, icmpTypes []string, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	switch protocol {
+	case "tcp", "udp", "sctp":
+		if len(icmpTypes) > 0 {
+			allErrs = append(allErrs, field.Forbidden(path.Child("icmpTypes"), "only allowed with protocol icmp or icmpv6"))
+		}
+	case "icmp", "icmpv6":
+		if len(ports) > 0 {
+			allErrs = append(allErrs, field.Forbidden(path.Child("ports"), "only allowed with protocol tcp, udp or sctp"))
+		}
+		for j, t := range icmpTypes {
+			if fwconfig.CheckICMPTypes(protocol, []string{t}) != nil {
+				allErrs = append(allErrs, field.NotSupported(path.Child("icmpTypes").Index(j), t, fwconfig.ICMPTypes(protocol)))
+			}
+		}
+	default:
+		if len(ports) > 0 {
+			allErrs = append(allErrs, field.Forbidden(path.Child("ports"), "only allowed with protocol tcp, udp or sctp"))
+		}
+		if len(icmpTypes) > 0 {
+			allErrs = append(allErrs, field.Forbidden(path.Child("icmpTypes"), "only allowed with protocol icmp or icmpv6"))
+		}
+	}
+	for j, port := range ports {
+		if err := validatePort(port, path.Child("ports").Index(j)); err != nil {
+			allErrs = append(allErrs, err)
+		}
+	}
+	return allErrs
+}
+
+// sharesFamily tells whether a and b have a prefix of the same family.
+func sharesFamily(a, b []netip.Prefix) bool {
+	for _, p := range a {
+		for _, q := range b {
+			if p.Addr().Is4() == q.Addr().Is4() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func validateBaseline(b *BaselineSpec, path *field.Path) field.ErrorList {
+	if b == nil {
+		return nil
+	}
+	var allErrs field.ErrorList
+	tpath := path.Child("template")
+	switch {
+	case b.Mode != BaselineTemplate && b.Template != "":
+		allErrs = append(allErrs, field.Forbidden(tpath, "only allowed with mode Template"))
+	case b.Mode == BaselineTemplate && b.Template == "":
+		allErrs = append(allErrs, field.Required(tpath, "mode Template needs a ruleset file"))
+	case b.Mode == BaselineTemplate && (filepath.Base(b.Template) != b.Template || b.Template == "." || b.Template == ".."):
+		allErrs = append(allErrs, field.Invalid(tpath, b.Template, "must be a file name in the agent's baseline directory"))
+	}
+	return allErrs
+}
+
+func validateTemplateRef(ref *TemplateRef, path *field.Path) field.ErrorList {
+	if ref == nil {
+		return nil
+	}
+	var allErrs field.ErrorList
+	for _, msg := range validation.IsDNS1123Subdomain(ref.Name) {
+		allErrs = append(allErrs, field.Invalid(path.Child("name"), ref.Name, msg))
+	}
+	if ref.Key != "" {
+		for _, msg := range validation.IsConfigMapKey(ref.Key) {
+			allErrs = append(allErrs, field.Invalid(path.Child("key"), ref.Key, msg))
+		}
+	}
+	return allErrs
+}
+
+// netnsPath matches the paths of network namespaces spec.netns may select:
+// those of processes and those created by `ip netns add`.
+var netnsPath = regexp.MustCompile(`^(/proc/[1-9][0-9]*/ns/net|(/var)?/run/netns/[^/]+)$`)
+
+func validateNetns(ns *NetworkNamespace, path *field.Path) field.ErrorList {
+	if ns == nil {
+		return nil
+	}
+	var allErrs field.ErrorList
+	set := 0
+	for _, v := range []string{ns.Name, ns.Path, ns.Container} {
+		if v != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		allErrs = append(allErrs, field.Invalid(path, ns, "exactly one of name, path and container must be set"))
+	}
+	switch {
+	case ns.Name != "" && (ns.Name == "." || ns.Name == ".." || strings.ContainsRune(ns.Name, '/')):
+		allErrs = append(allErrs, field.Invalid(path.Child("name"), ns.Name, "must be a file name under /var/run/netns"))
+	case ns.Path != "" && !netnsPath.MatchString(ns.Path):
+		allErrs = append(allErrs, field.Invalid(path.Child("path"), ns.Path, "must be /proc/<pid>/ns/net or /var/run/netns/<name>"))
+	case ns.Container != "" && strings.IndexFunc(ns.Container, unicode.IsSpace) >= 0:
+		allErrs = append(allErrs, field.Invalid(path.Child("container"), ns.Container, "must not contain whitespace"))
+	}
+	return allErrs
+}
+
+// validateNodeName checks that name is a node name that fits the value of
+// NodeLabel.
+func validateNodeName(name string, path *field.Path) field.ErrorList {
+	if name == "" {
+		return nil
+	}
+	var allErrs field.ErrorList
+	for _, msg := range validation.IsDNS1123Subdomain(name) {
+		allErrs = append(allErrs, field.Invalid(path, name, msg))
+	}
+	for _, msg := range validation.IsValidLabelValue(name) {
+		allErrs = append(allErrs, field.Invalid(path, name, msg))
+	}
+	return allErrs
+}
+
+// validateFirewall checks the zones, zone policies and rules shared by
+// FwLetSpec and RegionSpec.
+func validateFirewall(zones []Zone, policies []ZonePolicy, rules []PolicyRule, allowGroups bool, path *field.Path) field.ErrorList {
+	names, allErrs := validateZones(zones, path.Child("zones"))
+	allErrs = append(allErrs, validateZonePolicies(policies, names, path.Child("zonePolicies"))...)
+	allErrs = append(allErrs, validateRules(rules, names, allowGroups, path.Child("rules"))...)
+	return allErrs
+}
+
+func (s *FwLetSpec) validate(path *field.Path) field.ErrorList {
+	allErrs := validateFirewall(s.Zones, s.ZonePolicies, s.Rules, false, path)
+	_, errs := validatePrefixes(s.ManagementAddresses, path.Child("managementAddresses"))
+	allErrs = append(allErrs, errs...)
+	allErrs = append(allErrs, validateBaseline(s.Baseline, path.Child("baseline"))...)
+	allErrs = append(allErrs, validateTemplateRef(s.TemplateRef, path.Child("templateRef"))...)
+	allErrs = append(allErrs, validateNetns(s.Netns, path.Child("netns"))...)
+	allErrs = append(allErrs, validateNodeName(s.NodeName, path.Child("nodeName"))...)
+	return allErrs
+}
+
+func (s *FwMasterSpec) validate(path *field.Path) field.ErrorList {
+	mgmtAddrs, allErrs := validatePrefixes(s.ManagementAddresses, path.Child("managementAddresses"))
+	allErrs = append(allErrs, validateGroupNames(s.ManagementAddressGroups, path.Child("managementAddressGroups"))...)
+	names := map[string]bool{}
+	for i := range s.Regions {
+		r := &s.Regions[i]
+		rpath := path.Child("regions").Index(i)
+		// The region name is the name of its FwLet.
+		for _, msg := range validation.IsDNS1123Subdomain(r.Name) {
+			allErrs = append(allErrs, field.Invalid(rpath.Child("name"), r.Name, msg))
+		}
+		if names[r.Name] {
+			allErrs = append(allErrs, field.Duplicate(rpath.Child("name"), r.Name))
+		}
+		names[r.Name] = true
+		allErrs = append(allErrs, validateFirewall(r.Zones, r.ZonePolicies, r.Rules, true, rpath)...)
+		allErrs = append(allErrs, validateGroupNames(r.ManagementAddressGroups, rpath.Child("managementAddressGroups"))...)
+		allErrs = append(allErrs, validateBaseline(r.Baseline, rpath.Child("baseline"))...)
+		allErrs = append(allErrs, validateTemplateRef(r.TemplateRef, rpath.Child("templateRef"))...)
+		allErrs = append(allErrs, validateNetns(r.Netns, rpath.Child("netns"))...)
+		allErrs = append(allErrs, validateNodeName(r.NodeName, rpath.Child("nodeName"))...)
+
+		addrs := mgmtAddrs
+		if len(r.ManagementAddresses) > 0 {
+			var errs field.ErrorList
+			addrs, errs = validatePrefixes(r.ManagementAddresses, rpath.Child("managementAddresses"))
+			allErrs = append(allErrs, errs...)
+		}
+		_, errs := validatePrefixes(r.AdditionalManagementAddresses, rpath.Child("additionalManagementAddresses"), addrs...)
+		allErrs = append(allErrs, errs...)
+	}
+	if d := s.Defaults; d != nil {
+		dpath := path.Child("defaults")
+		allErrs = append(allErrs, validateZonePolicies(d.ZonePolicies, nil, dpath.Child("zonePolicies"))...)
+		allErrs = append(allErrs, validateBaseline(d.Baseline, dpath.Child("baseline"))...)
+	}
+	allErrs = append(allErrs, validateTemplateRef(s.TemplateRef, path.Child("templateRef"))...)
+	return allErrs
+}
@@ -0,0 +1,299 @@
+package v2
+
+import (
+	"strings"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func validFwLetSpec() FwLetSpec {
+	return FwLetSpec{
+		Zones: []Zone{
+			{Name: ZoneTrust, Interfaces: []string{"eth-a", "eth-b"}},
+			{Name: ZoneUntrust, Interfaces: []string{"vsix-bb"}},
+			{Name: "dmz", Interfaces: []string{"eth-d"}},
+		},
+		ZonePolicies:        []ZonePolicy{{From: "dmz", To: ZoneUntrust, Default: "accept"}},
+		Rules:               []PolicyRule{{Name: "web", From: ZoneUntrust, To: "dmz", Protocol: "tcp", Ports: []PortRange{"80", "8000-8080"}, Action: RuleActionAccept}},
+		ManagementAddresses: []string{"2001:db8:10:10::/64", "192.0.2.0/24", "2001:db8:10:20::1"},
+		TemplateRef:         &TemplateRef{Name: "fw-template"},
+	}
+}
+
+func TestFwLetValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*FwLetSpec)
+		// field is the path of the expected error, empty if valid.
+		field string
+	}{
+		{"valid", func(s *FwLetSpec) {}, ""},
+		{"bad prefix", func(s *FwLetSpec) {
+			s.ManagementAddresses[0] = "2001:db8:10:10::/129"
+		}, "spec.managementAddresses[0]"},
+		{"host bits", func(s *FwLetSpec) {
+			s.ManagementAddresses[1] = "192.0.2.1/24"
+		}, "spec.managementAddresses[1]"},
+		{"overlapping prefixes", func(s *FwLetSpec) {
+			s.ManagementAddresses = append(s.ManagementAddresses, "2001:db8:10::/48")
+		}, "spec.managementAddresses[3]"},
+		{"duplicate prefix", func(s *FwLetSpec) {
+			s.ManagementAddresses = append(s.ManagementAddresses, "192.0.2.0/24")
+		}, "spec.managementAddresses[3]"},
+		{"interface with space", func(s *FwLetSpec) {
+			s.Zones[0].Interfaces[1] = "eth b"
+		}, "spec.zones[0].interfaces[1]"},
+		{"interface too long", func(s *FwLetSpec) {
+			s.Zones[1].Interfaces[0] = "vsix-backbone-01"
+		}, "spec.zones[1].interfaces[0]"},
+		{"interface alias", func(s *FwLetSpec) {
+			s.Zones[2].Interfaces[0] = "eth-d:1"
+		}, "spec.zones[2].interfaces[0]"},
+		{"interface with quote", func(s *FwLetSpec) {
+			s.Zones[2].Interfaces[0] = `eth";flush`
+		}, "spec.zones[2].interfaces[0]"},
+		{"interface with backslash", func(s *FwLetSpec) {
+			s.Zones[2].Interfaces[0] = `eth\d`
+		}, "spec.zones[2].interfaces[0]"},
+		{"rule name with quote", func(s *FwLetSpec) {
+			s.Rules[0].Name = `web" ; flush ruleset ; #`
+		}, "spec.rules[0].name"},
+		{"rule name too long", func(s *FwLetSpec) {
+			s.Rules[0].Name = strings.Repeat("w", 126)
+		}, "spec.rules[0].name"},
+		{"unknown icmp type", func(s *FwLetSpec) {
+			s.Rules[0].Protocol, s.Rules[0].Ports, s.Rules[0].ICMPTypes = "icmp", nil, []string{"echo-request", "echo-request; flush ruleset"}
+		}, "spec.rules[0].icmpTypes[1]"},
+		{"interface in trust and untrust", func(s *FwLetSpec) {
+			s.Zones[1].Interfaces = append(s.Zones[1].Interfaces, "eth-a")
+		}, "spec.zones[1].interfaces[1]"},
+		{"duplicate zone", func(s *FwLetSpec) {
+			s.Zones = append(s.Zones, Zone{Name: "dmz", Interfaces: []string{"eth-e"}})
+		}, "spec.zones[3].name"},
+		{"empty zone", func(s *FwLetSpec) {
+			s.Zones[2].Interfaces = nil
+		}, "spec.zones[2].interfaces"},
+		{"policy to unknown zone", func(s *FwLetSpec) {
+			s.ZonePolicies[0].To = "lab"
+		}, "spec.zonePolicies[0].to"},
+		{"duplicate policy", func(s *FwLetSpec) {
+			s.ZonePolicies = append(s.ZonePolicies, s.ZonePolicies[0])
+		}, "spec.zonePolicies[1]"},
+		{"rule within a zone", func(s *FwLetSpec) {
+			s.Rules[0].From = "dmz"
+		}, "spec.rules[0].to"},
+		{"bad port range", func(s *FwLetSpec) {
+			s.Rules[0].Ports[1] = "8080-8000"
+		}, "spec.rules[0].ports[1]"},
+		{"ports without protocol", func(s *FwLetSpec) {
+			s.Rules[0].Protocol = ""
+		}, "spec.rules[0].ports"},
+		{"mixed families", func(s *FwLetSpec) {
+			s.Rules[0].Source = []string{"192.0.2.0/24"}
+			s.Rules[0].Destination = []string{"2001:db8:20::/48"}
+		}, "spec.rules[0].destination"},
+		{"template without mode", func(s *FwLetSpec) {
+			s.Baseline = &BaselineSpec{Mode: BaselineFailOpen, Template: "maintenance.rule"}
+		}, "spec.baseline.template"},
+		{"template path", func(s *FwLetSpec) {
+			s.Baseline = &BaselineSpec{Mode: BaselineTemplate, Template: "../maintenance.rule"}
+		}, "spec.baseline.template"},
+		{"bad template key", func(s *FwLetSpec) {
+			s.TemplateRef.Key = "fw/rule"
+		}, "spec.templateRef.key"},
+		{"source groups", func(s *FwLetSpec) {
+			s.Rules[0].SourceGroups = []string{"noc"}
+		}, "spec.rules[0].sourceGroups"},
+		{"service groups", func(s *FwLetSpec) {
+			s.Rules[0].ServiceGroups = []string{"ssh", "bgp"}
+		}, ""},
+		{"bad service group name", func(s *FwLetSpec) {
+			s.Rules[0].ServiceGroups = []string{"SSH"}
+		}, "spec.rules[0].serviceGroups[0]"},
+		{"netns container", func(s *FwLetSpec) {
+			s.Netns = &NetworkNamespace{Container: "fw1"}
+		}, ""},
+		{"netns name and path", func(s *FwLetSpec) {
+			s.Netns = &NetworkNamespace{Name: "vSIX", Path: "/var/run/netns/vSIX"}
+		}, "spec.netns"},
+		{"relative netns path", func(s *FwLetSpec) {
+			s.Netns = &NetworkNamespace{Path: "netns/vSIX"}
+		}, "spec.netns.path"},
+		{"process netns path", func(s *FwLetSpec) {
+			s.Netns = &NetworkNamespace{Path: "/proc/4242/ns/net"}
+		}, ""},
+		{"named netns path", func(s *FwLetSpec) {
+			s.Netns = &NetworkNamespace{Path: "/var/run/netns/vSIX"}
+		}, ""},
+		{"netns path of a file", func(s *FwLetSpec) {
+			s.Netns = &NetworkNamespace{Path: "/etc/passwd"}
+		}, "spec.netns.path"},
+		{"netns path of self", func(s *FwLetSpec) {
+			s.Netns = &NetworkNamespace{Path: "/proc/self/ns/net"}
+		}, "spec.netns.path"},
+		{"netns path escaping", func(s *FwLetSpec) {
+			s.Netns = &NetworkNamespace{Path: "/var/run/netns/../../etc/passwd"}
+		}, "spec.netns.path"},
+		{"netns name with slash", func(s *FwLetSpec) {
+			s.Netns = &NetworkNamespace{Name: "../vSIX"}
+		}, "spec.netns.name"},
+		{"node name", func(s *FwLetSpec) {
+			s.NodeName = "node1.example.org"
+		}, ""},
+		{"node name too long for a label", func(s *FwLetSpec) {
+			s.NodeName = strings.Repeat("n", 64)
+		}, "spec.nodeName"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fwl := &FwLet{ObjectMeta: metav1.ObjectMeta{Name: "kote"}, Spec: validFwLetSpec()}
+			tt.modify(&fwl.Spec)
+			_, err := fwl.ValidateCreate()
+			checkInvalid(t, err, tt.field)
+		})
+	}
+}
+
+func TestFwMasterValidate(t *testing.T) {
+	region := func(name string) RegionSpec {
+		s := validFwLetSpec()
+		return RegionSpec{Name: name, Zones: s.Zones, ZonePolicies: s.ZonePolicies, Rules: s.Rules}
+	}
+	tests := []struct {
+		name   string
+		modify func(*FwMasterSpec)
+		field  string
+	}{
+		{"valid", func(s *FwMasterSpec) {}, ""},
+		{"duplicate region", func(s *FwMasterSpec) {
+			s.Regions = append(s.Regions, region("kote"))
+		}, "spec.regions[2].name"},
+		{"bad region name", func(s *FwMasterSpec) {
+			s.Regions[1].Name = "Note"
+		}, "spec.regions[1].name"},
+		{"interface in two zones", func(s *FwMasterSpec) {
+			s.Regions[1].Zones[2].Interfaces = []string{"vsix-bb"}
+		}, "spec.regions[1].zones[2].interfaces[0]"},
+		{"overlapping prefixes", func(s *FwMasterSpec) {
+			s.ManagementAddresses = []string{"2001:db8::/32", "2001:db8:10:10::/64"}
+		}, "spec.managementAddresses[1]"},
+		{"region override", func(s *FwMasterSpec) {
+			s.Regions[0].ManagementAddresses = []string{"2001:db8:10::/48"}
+			s.Regions[0].AdditionalManagementAddresses = []string{"2001:db8:20::/48"}
+		}, ""},
+		{"additional overlaps master", func(s *FwMasterSpec) {
+			s.Regions[1].AdditionalManagementAddresses = []string{"2001:db8:10::/48"}
+		}, "spec.regions[1].additionalManagementAddresses[0]"},
+		{"additional overlaps override", func(s *FwMasterSpec) {
+			s.Regions[0].ManagementAddresses = []string{"2001:db8:20::/48"}
+			s.Regions[0].AdditionalManagementAddresses = []string{"2001:db8:20:10::/64"}
+		}, "spec.regions[0].additionalManagementAddresses[0]"},
+		{"default policy within a zone", func(s *FwMasterSpec) {
+			s.Defaults = &RegionDefaults{ZonePolicies: []ZonePolicy{{From: "lab", To: "lab", Default: "accept"}}}
+		}, "spec.defaults.zonePolicies[0].to"},
+		{"address groups", func(s *FwMasterSpec) {
+			s.ManagementAddressGroups = []string{"noc"}
+			s.Regions[0].ManagementAddressGroups = []string{"kote-noc"}
+			s.Regions[0].Rules[0].SourceGroups = []string{"wide"}
+		}, ""},
+		{"bad group name", func(s *FwMasterSpec) {
+			s.ManagementAddressGroups = []string{"NOC"}
+		}, "spec.managementAddressGroups[0]"},
+		{"duplicate rule group", func(s *FwMasterSpec) {
+			s.Regions[1].Rules[0].DestinationGroups = []string{"wide", "wide"}
+		}, "spec.regions[1].rules[0].destinationGroups[1]"},
+		{"region netns", func(s *FwMasterSpec) {
+			s.Regions[1].Netns = &NetworkNamespace{}
+		}, "spec.regions[1].netns"},
+		{"region node name", func(s *FwMasterSpec) {
+			s.Regions[0].NodeName = "Node_1"
+		}, "spec.regions[0].nodeName"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fwm := &FwMaster{
+				ObjectMeta: metav1.ObjectMeta{Name: "master"},
+				Spec: FwMasterSpec{
+					Regions:             []RegionSpec{region("kote"), region("note")},
+					ManagementAddresses: []string{"2001:db8:10:10::/64"},
+				},
+			}
+			tt.modify(&fwm.Spec)
+			_, err := fwm.ValidateCreate()
+			checkInvalid(t, err, tt.field)
+		})
+	}
+}
+
+func TestAddressGroupValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		spec  AddressGroupSpec
+		field string
+	}{
+		{"valid", AddressGroupSpec{Addresses: []string{"203.178.128.0/17", "2001:db8:10::/48"}, Groups: []string{"wide"}}, ""},
+		{"overlapping prefixes", AddressGroupSpec{Addresses: []string{"2001:db8:10::/48", "2001:db8:10:10::/64"}}, "spec.addresses[1]"},
+		{"includes itself", AddressGroupSpec{Groups: []string{"wide", "noc"}}, "spec.groups[1]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ag := &AddressGroup{ObjectMeta: metav1.ObjectMeta{Name: "noc"}, Spec: tt.spec}
+			_, err := ag.ValidateCreate()
+			checkInvalid(t, err, tt.field)
+		})
+	}
+}
+
+func TestServiceGroupValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		spec  ServiceGroupSpec
+		field string
+	}{
+		{"valid", ServiceGroupSpec{Services: []Service{{Protocol: "tcp", Ports: []PortRange{"161", "8000-8080"}}, {Protocol: "icmpv6", ICMPTypes: []string{"echo-request"}}}}, ""},
+		{"no services", ServiceGroupSpec{}, "spec.services"},
+		{"no protocol", ServiceGroupSpec{Services: []Service{{Ports: []PortRange{"22"}}}}, "spec.services[0].protocol"},
+		{"ports for icmp", ServiceGroupSpec{Services: []Service{{Protocol: "tcp"}, {Protocol: "icmp", Ports: []PortRange{"22"}}}}, "spec.services[1].ports"},
+		{"bad port", ServiceGroupSpec{Services: []Service{{Protocol: "udp", Ports: []PortRange{"65536"}}}}, "spec.services[0].ports[0]"},
+		{"icmp type of icmpv6", ServiceGroupSpec{Services: []Service{{Protocol: "icmp", ICMPTypes: []string{"nd-router-advert"}}}}, "spec.services[0].icmpTypes[0]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sg := &ServiceGroup{ObjectMeta: metav1.ObjectMeta{Name: "snmp"}, Spec: tt.spec}
+			_, err := sg.ValidateCreate()
+			checkInvalid(t, err, tt.field)
+		})
+	}
+}
+
+func TestValidateUpdateDeleting(t *testing.T) {
+	now := metav1.Now()
+	fwl := &FwLet{ObjectMeta: metav1.ObjectMeta{Name: "kote", DeletionTimestamp: &now}, Spec: validFwLetSpec()}
+	fwl.Spec.Zones[0].Interfaces[0] = "eth a"
+	if _, err := fwl.ValidateUpdate(fwl.DeepCopy()); err != nil {
+		t.Errorf("ValidateUpdate() of a FwLet being deleted = %v", err)
+	}
+}
+
+// checkInvalid checks that err is an Invalid error on field, or nil if field
+// is empty.
+func checkInvalid(t *testing.T, err error, field string) {
+	t.Helper()
+	if field == "" {
+		if err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+		return
+	}
+	if !apierrors.IsInvalid(err) {
+		t.Fatalf("error = %v, want Invalid", err)
+	}
+	for _, cause := range err.(apierrors.APIStatus).Status().Details.Causes {
+		if cause.Field == field {
+			return
+		}
+	}
+	t.Errorf("error %v is not about %s", err, field)
+}
@@ -19,20 +19,32 @@ package main
 import (
 	"flag"
 	"os"
+	"runtime/debug"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	samplecontrollerv1 "github.com/Yosshi72/fw-controller/api/v1"
+	samplecontrollerv2 "github.com/Yosshi72/fw-controller/api/v2"
 	"github.com/Yosshi72/fw-controller/internal/controller"
+	"github.com/Yosshi72/fw-controller/pkg/executer"
+	"github.com/Yosshi72/fw-controller/pkg/fwconfig"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -45,6 +57,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(samplecontrollerv1.AddToScheme(scheme))
+	utilruntime.Must(samplecontrollerv2.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -52,11 +65,87 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var fwBackend string
+	var netns string
+	var containerRuntimeSocket string
+	var allowHostNetns bool
+	var historyDir string
+	var confirmProbe string
+	var confirmWindow time.Duration
+	var defaultBaseline string
+	var baselineDir string
+	var resyncPeriod time.Duration
+	var templatePath string
+	var templateMode string
+	var templateNamespaces string
+	var instances string
+	var nodeName string
+	var fwLetSelector string
+	var stateDir string
+	var maxConcurrentReconciles int
+	var controllers string
+	var agentName string
+	var heartbeatPeriod time.Duration
+	var checkInterfaces bool
+	var watchLinks bool
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&fwBackend, "fw-backend", executer.BackendNft,
+		"Backend used to apply rulesets: nft, setns or fake.")
+	flag.StringVar(&netns, "netns", "vSIX",
+		"The network namespace the firewall rules are applied in, unless a FwLet sets spec.netns.")
+	flag.StringVar(&containerRuntimeSocket, "container-runtime-socket", "/var/run/docker.sock",
+		"Socket of a Docker compatible engine API looking up the namespace of FwLets with spec.netns.container. "+
+			"Empty rejects such FwLets.")
+	flag.BoolVar(&allowHostNetns, "allow-host-netns", false,
+		"Let spec.netns of FwLets select the network namespace of the agent or of the host.")
+	flag.StringVar(&historyDir, "history-dir", "/etc/nftables/history",
+		"Directory keeping previously applied rulesets for rollback.")
+	flag.StringVar(&confirmProbe, "confirm-probe-address", "",
+		"host:port that must be reachable from the namespace after a ruleset change, "+
+			"otherwise the last known good ruleset is restored. Empty disables the probe.")
+	flag.DurationVar(&confirmWindow, "confirm-window", 30*time.Second,
+		"How long the confirm probe may take to succeed after a ruleset change.")
+	flag.StringVar(&defaultBaseline, "default-baseline", string(samplecontrollerv2.BaselineFailOpen),
+		"Baseline applied when a FwLet without spec.baseline is deleted: FailOpen, FailClosed or Template.")
+	flag.StringVar(&baselineDir, "baseline-dir", "/etc/nftables/baseline",
+		"Directory holding the rulesets referenced by Template baselines.")
+	flag.DurationVar(&resyncPeriod, "resync-period", time.Minute,
+		"How often the live ruleset is checked for drift. 0 disables the check between watch events.")
+	flag.StringVar(&templatePath, "template", "/etc/nftables/fw-template.rule",
+		"Ruleset template rendered for each FwLet.")
+	flag.StringVar(&templateMode, "template-mode", fwconfig.TemplateAuto,
+		"How the template is interpreted: go (text/template), marker (legacy #..._PLACE markers) "+
+			"or auto (marker if the template contains a marker, go otherwise).")
+	flag.StringVar(&templateNamespaces, "template-namespaces", os.Getenv("POD_NAMESPACE"),
+		"Namespaces, comma separated, whose FwLets may take their template from a ConfigMap. Only the ConfigMaps of "+
+			"these namespaces are cached. Empty allows every namespace, which needs to read ConfigMaps cluster-wide.")
+	flag.StringVar(&instances, "fwlets", os.Getenv("REGION"),
+		"Comma separated FwLets enforced by this agent, as namespace/name or a name in the default namespace. Defaults to $REGION.")
+	flag.StringVar(&nodeName, "node-name", os.Getenv("NODE_NAME"),
+		"Enforce the FwLets labeled "+samplecontrollerv2.NodeLabel+"=<node-name>. Defaults to $NODE_NAME.")
+	flag.StringVar(&fwLetSelector, "fwlet-selector", "",
+		"Label selector of the FwLets enforced by this agent, combined with --node-name.")
+	flag.StringVar(&stateDir, "state-dir", "",
+		"Directory holding the rule file and history of each FwLet in <namespace>/<name>. "+
+			"Required to enforce more than one FwLet; otherwise /etc/nftables/fw.rule and --history-dir are used.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 4,
+		"How many FwLets are applied at once when --state-dir is set.")
+	flag.StringVar(&controllers, "controllers", "fwlet,fwmaster",
+		"Comma separated controllers to run: fwlet enforces FwLets on this node, fwmaster manages FwLets of FwMasters. "+
+			"An agent running only fwlet with --node-name or --fwlet-selector watches the FwLets it enforces only. "+
+			"The webhooks are served with fwmaster only.")
+	flag.StringVar(&agentName, "agent-name", "",
+		"Name of the FwAgent this agent registers. Defaults to --node-name, or the host name.")
+	flag.DurationVar(&heartbeatPeriod, "heartbeat-period", samplecontrollerv2.DefaultHeartbeatPeriod,
+		"How often the agent renews its FwAgent.")
+	flag.BoolVar(&checkInterfaces, "check-interfaces", true,
+		"Report the links of the namespace of each FwLet and mark it Degraded when zone interfaces do not exist.")
+	flag.BoolVar(&watchLinks, "watch-links", true,
+		"Reconcile FwLets as soon as links of their namespace are added or removed. Needs --check-interfaces.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,6 +154,62 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	enabled := map[string]bool{}
+	for _, name := range strings.Split(controllers, ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "fwlet", "fwmaster":
+			enabled[name] = true
+		case "":
+		default:
+			setupLog.Error(nil, "unknown controller", "controller", name)
+			os.Exit(1)
+		}
+	}
+
+	var names []types.NamespacedName
+	for _, name := range strings.Split(instances, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		key := types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: name}
+		if namespace, n, ok := strings.Cut(name, "/"); ok {
+			key = types.NamespacedName{Namespace: namespace, Name: n}
+		}
+		names = append(names, key)
+	}
+	var selector labels.Selector
+	if fwLetSelector != "" || nodeName != "" {
+		var err error
+		selector, err = labels.Parse(fwLetSelector)
+		if err != nil {
+			setupLog.Error(err, "invalid FwLet selector", "selector", fwLetSelector)
+			os.Exit(1)
+		}
+		if nodeName != "" {
+			req, err := labels.NewRequirement(samplecontrollerv2.NodeLabel, selection.Equals, []string{nodeName})
+			if err != nil {
+				setupLog.Error(err, "invalid node name", "node", nodeName)
+				os.Exit(1)
+			}
+			selector = selector.Add(*req)
+		}
+	}
+	if enabled["fwlet"] && stateDir == "" && (selector != nil || len(names) > 1) {
+		setupLog.Error(nil, "--state-dir is required to enforce more than one FwLet")
+		os.Exit(1)
+	}
+
+	// An agent bound by selector only caches the FwLets it enforces. The
+	// FwMaster controller needs to see all of them. A FwLet relabeled for
+	// another node leaves the cache, and is torn down through the record
+	// in its state dir.
+	var cacheOpts cache.Options
+	if enabled["fwlet"] && !enabled["fwmaster"] && selector != nil && len(names) == 0 {
+		cacheOpts.ByObject = map[client.Object]cache.ByObject{
+			&samplecontrollerv2.FwLet{}: {Label: selector},
+		}
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
@@ -72,6 +217,7 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "ea0df6cf.yossy.vsix.wide.ad.jp",
+		Cache:                  cacheOpts,
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -89,19 +235,136 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = (&controller.FwLetReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "FwLet")
-		os.Exit(1)
+	if enabled["fwlet"] {
+		applier, err := executer.New(fwBackend)
+		if err != nil {
+			setupLog.Error(err, "unable to create applier", "backend", fwBackend)
+			os.Exit(1)
+		}
+
+		var prober executer.Prober
+		if confirmProbe != "" {
+			prober = &executer.DialProber{Address: confirmProbe}
+		}
+
+		var containerRuntime executer.ContainerRuntime
+		if containerRuntimeSocket != "" {
+			containerRuntime = &executer.DockerRuntime{Socket: containerRuntimeSocket}
+		}
+
+		if agentName == "" {
+			agentName = nodeName
+		}
+		if agentName == "" {
+			if agentName, err = os.Hostname(); err != nil {
+				setupLog.Error(err, "unable to get host name")
+				os.Exit(1)
+			}
+		}
+
+		// Only the ConfigMaps of the template namespaces are cached, so
+		// that the agent needs no access to those of the whole cluster.
+		var namespaces []string
+		var templateCache cache.Cache
+		if templateNamespaces != "" {
+			namespaces = strings.Split(templateNamespaces, ",")
+			templateCache, err = cache.New(mgr.GetConfig(), cache.Options{
+				HTTPClient: mgr.GetHTTPClient(),
+				Scheme:     mgr.GetScheme(),
+				Mapper:     mgr.GetRESTMapper(),
+				Namespaces: namespaces,
+			})
+			if err != nil {
+				setupLog.Error(err, "unable to create template cache")
+				os.Exit(1)
+			}
+			if err = mgr.Add(templateCache); err != nil {
+				setupLog.Error(err, "unable to add template cache")
+				os.Exit(1)
+			}
+		}
+
+		var linkMonitor executer.LinkMonitor
+		if checkInterfaces {
+			linkMonitor = executer.NetlinkMonitor{}
+		}
+
+		fwLetReconciler := &controller.FwLetReconciler{
+			Client:                  mgr.GetClient(),
+			Scheme:                  mgr.GetScheme(),
+			Applier:                 applier,
+			Netns:                   netns,
+			ContainerRuntime:        containerRuntime,
+			APIReader:               mgr.GetAPIReader(),
+			AllowHostNetns:          allowHostNetns,
+			LinkMonitor:             linkMonitor,
+			WatchLinks:              watchLinks,
+			Instances:               names,
+			Selector:                selector,
+			TemplatePath:            templatePath,
+			TemplateMode:            templateMode,
+			TemplateNamespaces:      namespaces,
+			TemplateCache:           templateCache,
+			StateDir:                stateDir,
+			HistoryDir:              historyDir,
+			MaxConcurrentReconciles: maxConcurrentReconciles,
+			Prober:                  prober,
+			ConfirmWindow:           confirmWindow,
+			DefaultBaseline:         samplecontrollerv2.BaselineMode(defaultBaseline),
+			BaselineDir:             baselineDir,
+			Recorder:                mgr.GetEventRecorderFor("fwlet-controller"),
+			ResyncPeriod:            resyncPeriod,
+			AgentName:               agentName,
+		}
+		if err = fwLetReconciler.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "FwLet")
+			os.Exit(1)
+		}
+		if err = mgr.Add(&controller.AgentHeartbeat{
+			Client:   mgr.GetClient(),
+			Name:     agentName,
+			NodeName: nodeName,
+			Version:  version(),
+			Backend:  fwBackend,
+			Netns:    netns,
+			Applier:  applier,
+			Bound:    fwLetReconciler.Bound,
+			Period:   heartbeatPeriod,
+		}); err != nil {
+			setupLog.Error(err, "unable to add heartbeat", "agent", agentName)
+			os.Exit(1)
+		}
 	}
-	if err = (&controller.FwMasterReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "FwMaster")
-		os.Exit(1)
+	if enabled["fwmaster"] {
+		if err = (&controller.FwMasterReconciler{
+			Client: mgr.GetClient(),
+			Scheme: mgr.GetScheme(),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "FwMaster")
+			os.Exit(1)
+		}
+	}
+	// The conversion webhook serves v1 clients of the v2 storage version.
+	// The webhooks run with the fwmaster controller, next to the webhook
+	// service and its certificate; node agents running fwlet only serve
+	// none.
+	if enabled["fwmaster"] && os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&samplecontrollerv2.FwLet{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "FwLet")
+			os.Exit(1)
+		}
+		if err = (&samplecontrollerv2.FwMaster{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "FwMaster")
+			os.Exit(1)
+		}
+		if err = (&samplecontrollerv2.AddressGroup{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "AddressGroup")
+			os.Exit(1)
+		}
+		if err = (&samplecontrollerv2.ServiceGroup{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ServiceGroup")
+			os.Exit(1)
+		}
 	}
 	//+kubebuilder:scaffold:builder
 
@@ -120,3 +383,19 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// version returns the module version and VCS revision the binary was built
+// from.
+func version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	v := info.Main.Version
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			v += "+" + s.Value
+		}
+	}
+	return v
+}
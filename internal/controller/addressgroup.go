@@ -0,0 +1,232 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	samplecontrollerv2 "github.com/Yosshi72/fw-controller/api/v2"
+	"github.com/Yosshi72/fw-controller/pkg/util"
+)
+
+// addressGroupIndex indexes FwMasters by the AddressGroups they reference.
+const addressGroupIndex = ".spec.addressGroups"
+
+func addressGroupNames(obj client.Object) []string {
+	fwm := obj.(*samplecontrollerv2.FwMaster)
+	names := append([]string{}, fwm.Spec.ManagementAddressGroups...)
+	for _, region := range fwm.Spec.Regions {
+		names = append(names, region.ManagementAddressGroups...)
+		for _, rule := range region.Rules {
+			names = append(names, rule.SourceGroups...)
+			names = append(names, rule.DestinationGroups...)
+		}
+	}
+	return names
+}
+
+// fwMastersForAddressGroup re-reconciles the FwMasters referencing ag,
+// directly or through the groups including it.
+func (r *FwMasterReconciler) fwMastersForAddressGroup(ctx context.Context, ag client.Object) []reconcile.Request {
+	log := log.FromContext(ctx)
+	ags := samplecontrollerv2.AddressGroupList{}
+	if err := r.List(ctx, &ags, client.InNamespace(ag.GetNamespace())); err != nil {
+		log.Error(err, "msg", "line", util.LINE())
+		return nil
+	}
+	includedBy := map[string][]string{}
+	for _, g := range ags.Items {
+		for _, sub := range g.Spec.Groups {
+			includedBy[sub] = append(includedBy[sub], g.GetName())
+		}
+	}
+	// The groups including ag. visited also stops at cycles.
+	visited := map[string]bool{ag.GetName(): true}
+	queue := []string{ag.GetName()}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, parent := range includedBy[name] {
+			if !visited[parent] {
+				visited[parent] = true
+				queue = append(queue, parent)
+			}
+		}
+	}
+
+	seen := map[types.NamespacedName]bool{}
+	var reqs []reconcile.Request
+	for name := range visited {
+		fwms := samplecontrollerv2.FwMasterList{}
+		if err := r.List(ctx, &fwms, client.InNamespace(ag.GetNamespace()), client.MatchingFields{addressGroupIndex: name}); err != nil {
+			log.Error(err, "msg", "line", util.LINE())
+			return nil
+		}
+		for _, fwm := range fwms.Items {
+			key := client.ObjectKeyFromObject(&fwm)
+			if !seen[key] {
+				seen[key] = true
+				reqs = append(reqs, reconcile.Request{NamespacedName: key})
+			}
+		}
+	}
+	return reqs
+}
+
+// expandAddressGroups returns a copy of the spec of fwm in which the
+// addresses of the AddressGroups it references are added to the inline
+// prefixes and the references are removed. A missing group or a cycle of
+// groups is returned as an applyError.
+func (r *FwMasterReconciler) expandAddressGroups(ctx context.Context, fwm *samplecontrollerv2.FwMaster) (*samplecontrollerv2.FwMasterSpec, error) {
+	spec := fwm.Spec.DeepCopy()
+	g := &addressGroupResolver{reader: r.Client, namespace: fwm.GetNamespace(), resolved: map[string][]string{}}
+
+	// A rule whose groups are empty would match any address, so they
+	// must resolve to at least one prefix.
+	expand := func(addrs, groups *[]string, required bool) error {
+		if len(*groups) == 0 {
+			return nil
+		}
+		resolved, err := g.resolve(ctx, *groups)
+		if err != nil {
+			return err
+		}
+		if required && len(resolved) == 0 {
+			return &applyError{ReasonAddressGroupInvalid, fmt.Errorf("AddressGroups %s have no addresses", strings.Join(*groups, ", "))}
+		}
+		*addrs = mergePrefixes(*addrs, resolved)
+		*groups = nil
+		return nil
+	}
+
+	if err := expand(&spec.ManagementAddresses, &spec.ManagementAddressGroups, false); err != nil {
+		return nil, err
+	}
+	for i := range spec.Regions {
+		region := &spec.Regions[i]
+		if err := expand(&region.AdditionalManagementAddresses, &region.ManagementAddressGroups, false); err != nil {
+			return nil, err
+		}
+		for j := range region.Rules {
+			rule := &region.Rules[j]
+			if err := expand(&rule.Source, &rule.SourceGroups, true); err != nil {
+				return nil, err
+			}
+			if err := expand(&rule.Destination, &rule.DestinationGroups, true); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return spec, nil
+}
+
+// addressGroupResolver collects the addresses of the AddressGroups of a
+// namespace, following nested groups.
+type addressGroupResolver struct {
+	reader    client.Reader
+	namespace string
+	// resolved caches the addresses of the groups already visited.
+	resolved map[string][]string
+}
+
+func (g *addressGroupResolver) resolve(ctx context.Context, names []string) ([]string, error) {
+	var addrs []string
+	for _, name := range names {
+		a, err := g.group(ctx, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, a...)
+	}
+	return mergePrefixes(addrs), nil
+}
+
+// group returns the addresses of the named group. path is the chain of
+// groups that led to it.
+func (g *addressGroupResolver) group(ctx context.Context, name string, path []string) ([]string, error) {
+	for i, n := range path {
+		if n == name {
+			cycle := append(append([]string{}, path[i:]...), name)
+			return nil, &applyError{ReasonAddressGroupInvalid, fmt.Errorf("AddressGroup cycle: %s", strings.Join(cycle, " -> "))}
+		}
+	}
+	if addrs, ok := g.resolved[name]; ok {
+		return addrs, nil
+	}
+
+	ag := samplecontrollerv2.AddressGroup{}
+	if err := g.reader.Get(ctx, types.NamespacedName{Namespace: g.namespace, Name: name}, &ag); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, &applyError{ReasonAddressGroupInvalid, fmt.Errorf("AddressGroup %s not found", name)}
+		}
+		return nil, fmt.Errorf("Failed to get AddressGroup %s: %v", name, err)
+	}
+	addrs := append([]string{}, ag.Spec.Addresses...)
+	path = append(path, name)
+	for _, sub := range ag.Spec.Groups {
+		a, err := g.group(ctx, sub, path)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, a...)
+	}
+	g.resolved[name] = addrs
+	return addrs, nil
+}
+
+// mergePrefixes concatenates lists, dropping the prefixes equal to or
+// contained in another one so that the result passes FwLet validation.
+// The order of the remaining prefixes is kept.
+func mergePrefixes(lists ...[]string) []string {
+	var all []string
+	for _, l := range lists {
+		all = append(all, l...)
+	}
+	var out []string
+	for i, s := range all {
+		p, ok := parsePrefix(s)
+		covered := false
+		for j, t := range all {
+			if i == j {
+				continue
+			}
+			q, qok := parsePrefix(t)
+			if !ok || !qok {
+				// Left to validation, only dropping repeats.
+				covered = s == t && j < i
+			} else {
+				covered = q.Bits() < p.Bits() && q.Contains(p.Addr()) || q == p && j < i
+			}
+			if covered {
+				break
+			}
+		}
+		if !covered {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// parsePrefix parses a prefix or an address taken as a host prefix.
+func parsePrefix(s string) (netip.Prefix, bool) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, false
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()), true
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	return p.Masked(), true
+}
@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	samplecontrollerv2 "github.com/Yosshi72/fw-controller/api/v2"
+)
+
+// Condition reasons.
+const (
+	ReasonApplied        = "Applied"
+	ReasonRenderFailed   = "RenderFailed"
+	ReasonCheckFailed    = "CheckFailed"
+	ReasonApplyFailed    = "ApplyFailed"
+	ReasonRolledBack     = "RolledBack"
+	ReasonRollbackFailed = "RollbackFailed"
+	ReasonNoDrift        = "NoDrift"
+	ReasonDriftReported  = "DriftReported"
+	ReasonDriftCorrected = "DriftCorrected"
+	ReasonReapplyFailed  = "ReapplyFailed"
+	ReasonNotChecked     = "NotChecked"
+	ReasonAsExpected     = "AsExpected"
+	ReasonNotReady       = "NotReady"
+	ReasonPending        = "Pending"
+	// ReasonAgentsAlive and ReasonAgentLost report the liveness of the
+	// agents of FwMaster regions.
+	ReasonAgentsAlive = "AgentsAlive"
+	ReasonAgentLost   = "AgentLost"
+	// ReasonNetnsUnavailable reports a FwLet whose network namespace
+	// cannot be resolved or entered.
+	ReasonNetnsUnavailable = "NetnsUnavailable"
+	// ReasonNetnsConflict reports a FwLet whose network namespace is
+	// enforced by another FwLet of the agent.
+	ReasonNetnsConflict = "NetnsConflict"
+	// ReasonInterfacesMissing reports a FwLet whose zone interfaces do not
+	// exist in its network namespace.
+	ReasonInterfacesMissing = "InterfacesMissing"
+	// ReasonAddressGroupInvalid reports a FwMaster referencing a missing
+	// AddressGroup or a cycle of groups.
+	ReasonAddressGroupInvalid = "AddressGroupInvalid"
+)
+
+// applyError is a failed ruleset change, or a spec that cannot be turned
+// into one, together with the reason reported in the Applied condition.
+type applyError struct {
+	Reason string
+	Err    error
+}
+
+func (e *applyError) Error() string { return e.Err.Error() }
+
+func setCondition(conds *[]metav1.Condition, generation int64, condType string, status metav1.ConditionStatus, reason, msg string) {
+	meta.SetStatusCondition(conds, metav1.Condition{
+		Type:               condType,
+		Status:             status,
+		ObservedGeneration: generation,
+		Reason:             reason,
+		Message:            msg,
+	})
+}
+
+// setReady derives the Ready condition from Applied, Degraded,
+// DriftDetected and, for FwMasters, AgentsAlive.
+func setReady(conds *[]metav1.Condition, generation int64) {
+	switch {
+	case !meta.IsStatusConditionTrue(*conds, samplecontrollerv2.ConditionApplied):
+		setCondition(conds, generation, samplecontrollerv2.ConditionReady, metav1.ConditionFalse, ReasonNotReady, "ruleset for the current spec is not applied")
+	case meta.IsStatusConditionTrue(*conds, samplecontrollerv2.ConditionDegraded):
+		setCondition(conds, generation, samplecontrollerv2.ConditionReady, metav1.ConditionFalse, ReasonNotReady, "firewall is degraded")
+	case meta.IsStatusConditionTrue(*conds, samplecontrollerv2.ConditionDriftDetected):
+		setCondition(conds, generation, samplecontrollerv2.ConditionReady, metav1.ConditionFalse, ReasonNotReady, "live ruleset drifted")
+	case meta.IsStatusConditionFalse(*conds, samplecontrollerv2.ConditionAgentsAlive):
+		setCondition(conds, generation, samplecontrollerv2.ConditionReady, metav1.ConditionFalse, ReasonNotReady, "agents are not alive")
+	default:
+		setCondition(conds, generation, samplecontrollerv2.ConditionReady, metav1.ConditionTrue, ReasonAsExpected, "")
+	}
+}
@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	samplecontrollerv2 "github.com/Yosshi72/fw-controller/api/v2"
+	"github.com/Yosshi72/fw-controller/pkg/executer"
+	"github.com/Yosshi72/fw-controller/pkg/util"
+)
+
+//+kubebuilder:rbac:groups=samplecontroller.yossy.vsix.wide.ad.jp,resources=fwagents,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups=samplecontroller.yossy.vsix.wide.ad.jp,resources=fwagents/status,verbs=get;update;patch
+
+// AgentHeartbeat registers the agent as the FwAgent named Name and renews
+// its status every Period. It runs on every agent, leader or not.
+type AgentHeartbeat struct {
+	client.Client
+	Name     string
+	NodeName string
+	Version  string
+	Backend  string
+	// Netns is the agent's default namespace, whose interfaces are
+	// reported.
+	Netns   string
+	Applier executer.Applier
+	// Bound selects the FwLets reported as enforced by the agent.
+	Bound  func(client.Object) bool
+	Period time.Duration
+}
+
+// Start implements manager.Runnable.
+func (h *AgentHeartbeat) Start(ctx context.Context) error {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := h.beat(ctx); err != nil {
+			log.FromContext(ctx).Error(err, "msg", "line", util.LINE())
+		}
+	}, h.period())
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (h *AgentHeartbeat) NeedLeaderElection() bool {
+	return false
+}
+
+func (h *AgentHeartbeat) period() time.Duration {
+	if h.Period <= 0 {
+		return samplecontrollerv2.DefaultHeartbeatPeriod
+	}
+	return h.Period
+}
+
+// beat creates or renews the FwAgent.
+func (h *AgentHeartbeat) beat(ctx context.Context) error {
+	agent := samplecontrollerv2.FwAgent{}
+	agent.SetName(h.Name)
+	if _, err := ctrl.CreateOrUpdate(ctx, h.Client, &agent, func() error {
+		agent.Spec.NodeName = h.NodeName
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	status := samplecontrollerv2.FwAgentStatus{
+		Version:         h.Version,
+		Backend:         h.Backend,
+		Netns:           h.Netns,
+		HeartbeatPeriod: metav1.Duration{Duration: h.period()},
+	}
+	// Inventory that cannot be taken is left out rather than failing the
+	// heartbeat.
+	if v, ok := h.Applier.(executer.Versioner); ok {
+		if version, err := v.Version(ctx); err == nil {
+			status.NftVersion = version
+		} else {
+			log.FromContext(ctx).Error(err, "msg", "line", util.LINE())
+		}
+	}
+	if ifaces, err := executer.Interfaces(h.Netns); err == nil {
+		status.Interfaces = interfaceStatuses(ifaces)
+	} else {
+		log.FromContext(ctx).Error(err, "msg", "line", util.LINE())
+	}
+	fwls := samplecontrollerv2.FwLetList{}
+	if err := h.List(ctx, &fwls); err != nil {
+		return err
+	}
+	for i := range fwls.Items {
+		if h.Bound(&fwls.Items[i]) {
+			status.FwLets = append(status.FwLets, client.ObjectKeyFromObject(&fwls.Items[i]).String())
+		}
+	}
+	sort.Strings(status.FwLets)
+	now := metav1.Now()
+	status.LastHeartbeatTime = &now
+
+	agent.Status = status
+	return h.Status().Update(ctx, &agent)
+}
+
+func interfaceStatuses(ifaces []executer.Interface) []samplecontrollerv2.InterfaceStatus {
+	var list []samplecontrollerv2.InterfaceStatus
+	for _, i := range ifaces {
+		list = append(list, samplecontrollerv2.InterfaceStatus{Name: i.Name, Up: i.Up, Addresses: i.Addresses})
+	}
+	return list
+}
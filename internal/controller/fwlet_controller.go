@@ -18,139 +18,330 @@ package controller
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
-	samplecontrollerv1 "github.com/Yosshi72/fw-controller/api/v1"
+	samplecontrollerv2 "github.com/Yosshi72/fw-controller/api/v2"
 	"github.com/Yosshi72/fw-controller/pkg/executer"
 	"github.com/Yosshi72/fw-controller/pkg/fwconfig"
 	"github.com/Yosshi72/fw-controller/pkg/util"
 	// "github.com/k0kubun/pp"
 )
 
+const (
+	defaultTemplatePath = "/etc/nftables/fw-template.rule"
+	defaultRulePath     = "/etc/nftables/fw.rule"
+	defaultHistoryDir   = "/etc/nftables/history"
+	defaultHistoryLimit = 10
+	defaultBaselineDir  = "/etc/nftables/baseline"
+
+	fwLetFinalizer = "samplecontroller.yossy.vsix.wide.ad.jp/baseline"
+
+	// netnsRecord is the file in the state dir of a FwLet naming the
+	// namespace its rulesets were applied to.
+	netnsRecord = "netns"
+)
+
 // FwLetReconciler reconciles a FwLet object
 type FwLetReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// Applier loads rendered rulesets into the kernel.
+	Applier executer.Applier
+	// Netns is the network namespace the firewall is enforced in, unless
+	// the FwLet sets spec.netns.
+	Netns        string
+	TemplatePath string
+	// TemplateNamespaces are the namespaces whose FwLets may take their
+	// template from a ConfigMap. Empty allows every namespace.
+	TemplateNamespaces []string
+	// TemplateCache caches the ConfigMaps of TemplateNamespaces only. Nil
+	// reads and watches them through the manager's cache.
+	TemplateCache cache.Cache
+	// ContainerRuntime resolves namespaces given by container. Nil
+	// rejects them.
+	ContainerRuntime executer.ContainerRuntime
+	// AllowHostNetns lets spec.netns select the agent's own namespace or
+	// the host's. Rulesets flush the namespace they are applied to, so this
+	// is left to the agent's --netns by default.
+	AllowHostNetns bool
+	// LinkMonitor lists the links of the namespace, to report zone
+	// interfaces that do not exist. Nil skips the check.
+	LinkMonitor executer.LinkMonitor
+	// WatchLinks re-reconciles FwLets when the links of their namespace
+	// change, through LinkMonitor.
+	WatchLinks bool
+	links      *linkWatcher
+	// AgentName is the FwAgent of this agent, reported in the FwLet
+	// status.
+	AgentName string
+	// Instances are the FwLets enforced by this agent, in addition to
+	// those whose labels match Selector.
+	Instances []types.NamespacedName
+	Selector  labels.Selector
+	// APIReader reads FwLets that left a cache filtered by Selector, to
+	// tear them down with their own baseline. Nil falls back to
+	// DefaultBaseline.
+	APIReader client.Reader
+	// claims is the FwLet enforced in each namespace. Every ruleset
+	// flushes the whole namespace, so it cannot be shared.
+	claimsMu sync.Mutex
+	claims   map[string]types.NamespacedName
+	// TemplateMode is one of the fwconfig.Template* modes; empty means
+	// fwconfig.TemplateAuto.
+	TemplateMode string
+	// StateDir, if set, holds the rule file and history of every FwLet in
+	// a directory of its own, <StateDir>/<namespace>/<name>. Otherwise
+	// RulePath and HistoryDir are used, which only fits a single FwLet.
+	StateDir string
+	RulePath string
+	// HistoryDir keeps every ruleset that passed the check, so that the
+	// last known good one can be restored.
+	HistoryDir   string
+	HistoryLimit int
+	// MaxConcurrentReconciles is how many FwLets are applied at once. It
+	// is 1 without StateDir.
+	MaxConcurrentReconciles int
+	// Prober, if set, must succeed within ConfirmWindow after an apply,
+	// otherwise the previous ruleset is restored.
+	Prober        executer.Prober
+	ConfirmWindow time.Duration
+	// DefaultBaseline is applied on deletion of FwLets that do not set
+	// spec.baseline. Template baselines are read from BaselineDir.
+	DefaultBaseline samplecontrollerv2.BaselineMode
+	BaselineDir     string
+	Recorder        record.EventRecorder
+	// ResyncPeriod is how often an FwLet is reconciled without a watch
+	// event, to detect drift of the live ruleset. 0 disables resyncs.
+	ResyncPeriod time.Duration
 }
 
 //+kubebuilder:rbac:groups=samplecontroller.yossy.vsix.wide.ad.jp,resources=fwlets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=samplecontroller.yossy.vsix.wide.ad.jp,resources=fwlets/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=samplecontroller.yossy.vsix.wide.ad.jp,resources=fwlets/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",namespace=system,resources=configmaps,verbs=get;list;watch
+//+kubebuilder:rbac:groups=samplecontroller.yossy.vsix.wide.ad.jp,resources=servicegroups,verbs=get;list;watch
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the FwLet object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+// Reconcile enforces a FwLet bound to this agent in its network namespace.
+// It renders the ruleset of the FwLet and applies it when it differs from
+// the one on file, holding back a ruleset that was rolled back until the
+// FwLet changes. It then compares the live ruleset with the applied one,
+// checks the zone interfaces and reports all of it in status. A FwLet that
+// is deleted, or no longer bound to this agent, is torn down to its
+// baseline.
 //
-// For more details, check Reconcile and its Result here:
-// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.15.0/pkg/reconcile
+// Reconcile requeues after ResyncPeriod to notice drift that no event
+// reports.
 func (r *FwLetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
 	res := util.NewResult()
-	fwl := samplecontrollerv1.FwLet{}
-	region := os.Getenv("REGION")
+	fwl := samplecontrollerv2.FwLet{}
 
 	if err := r.Get(ctx, req.NamespacedName, &fwl); err != nil {
 		if errors.IsNotFound(err) {
+			// A FwLet relabeled for another agent leaves a cache
+			// filtered by label as if it was deleted.
+			if err := r.unbind(ctx, req.NamespacedName, nil); err != nil {
+				log.Error(err, "msg", "line", util.LINE())
+				return ctrl.Result{}, err
+			}
 			return ctrl.Result{}, nil
 		}
 		log.Error(err, "msg", "line", util.LINE())
 		return ctrl.Result{}, err
 	}
-	// Ignore reconcile request unrelated to me
-	if fwl.GetName() != region {
+	// Ignore reconcile request unrelated to me, once whatever this agent
+	// applied for it is torn down
+	if !r.Bound(&fwl) {
+		if err := r.unbind(ctx, req.NamespacedName, &fwl); err != nil {
+			log.Error(err, "msg", "line", util.LINE())
+			return ctrl.Result{}, err
+		}
 		return ctrl.Result{}, nil
 	}
-	containerName := convContainerName(fwl.GetName())
 	// Finalizer
-	// finalizerName := "bg-switcherlet-" + fwl.Name
-	// if fwl.ObjectMeta.DeletionTimestamp.IsZero() {
-	// 	if !controllerutil.ContainsFinalizer(&fwl, finalizerName) {
-	// 		controllerutil.AddFinalizer(&fwl, finalizerName)
-	// 		res.SpecUpdated = true
-	// 	}
-	// } else {
-	// 	if controllerutil.ContainsFinalizer(&fwl, finalizerName) {
-	//		TODO:nsのnftablesのrulesを削除する. fw-letが消された場合．
-	// 		TODO:fw-masterが消されたらfw-letを消す
-	// 		controllerutil.RemoveFinalizer(&fwl, finalizerName)
-	// 		if err := r.Update(ctx, &fwl); err != nil {
-	// 			log.Error(err, "msg", "line", util.LINE())
-	// 			return ctrl.Result{}, err
-	// 		}
-	// 	}
-	// 	return ctrl.Result{}, nil
-	// }
-
-	trustIf, untrustIf, mgmtAddr, err := getConfig(containerName)
+	if fwl.ObjectMeta.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(&fwl, fwLetFinalizer) {
+			controllerutil.AddFinalizer(&fwl, fwLetFinalizer)
+			if err := r.Update(ctx, &fwl); err != nil {
+				log.Error(err, "msg", "line", util.LINE())
+				return ctrl.Result{}, err
+			}
+		}
+	} else {
+		if controllerutil.ContainsFinalizer(&fwl, fwLetFinalizer) {
+			if err := r.teardown(ctx, &fwl); err != nil {
+				log.Error(err, "msg", "line", util.LINE())
+				return ctrl.Result{}, err
+			}
+			r.forgetLinks(req.NamespacedName)
+			r.releaseNetns(req.NamespacedName)
+			controllerutil.RemoveFinalizer(&fwl, fwLetFinalizer)
+			if err := r.Update(ctx, &fwl); err != nil {
+				log.Error(err, "msg", "line", util.LINE())
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	origStatus := fwl.Status.DeepCopy()
+	gen := fwl.GetGeneration()
+	fwl.Status.Agent = r.AgentName
+
+	netns, err := r.netns(ctx, &fwl)
+	var netnsErr error
 	if err != nil {
+		log.Error(err, "msg", "line", util.LINE())
+		netnsErr = &applyError{ReasonNetnsUnavailable, err}
+	} else if owner, err := r.claimNetns(ctx, &fwl, netns); err != nil {
 		log.Error(err, "msg", "line", util.LINE())
 		return ctrl.Result{}, err
+	} else if owner != nil {
+		netnsErr = &applyError{ReasonNetnsConflict, fmt.Errorf("Netns %s is enforced by FwLet %s", netns, owner)}
 	}
-	if trustIf == nil && untrustIf == "" && mgmtAddr == nil {
-		fwl.Status.TrustIf = fwl.Spec.TrustIf
-		fwl.Status.UntrustIf = fwl.Spec.UntrustIf
-		fwl.Status.MgmtAddressRange = fwl.Spec.MgmtAddressRange
-		setConfig(containerName, fwl.Status.UntrustIf, fwl.Status.TrustIf, fwl.Status.MgmtAddressRange)
+	if netnsErr != nil {
+		applyErr := netnsErr
+		r.setConditions(&fwl, applyErr)
+		fwl.Status.ObservedGeneration = gen
+		if !equality.Semantic.DeepEqual(origStatus, &fwl.Status) {
+			if err := r.Status().Update(ctx, &fwl); err != nil {
+				log.Error(err, "msg", "line", util.LINE())
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, applyErr
 	}
+	// The ruleset on file was applied to another namespace.
+	moved := fwl.Status.Netns != "" && fwl.Status.Netns != netns
 
-	trustIf, untrustIf, mgmtAddr, err = getConfig(containerName)
-
-	// Interfaceの更新
-	changedTrustIF := false
-	if !fwconfig.MatchElements(trustIf, fwl.Spec.TrustIf) {
-		newTrustIF := fwl.Spec.TrustIf
-		setConfig(containerName, untrustIf, newTrustIF, mgmtAddr)
-		changedTrustIF = true
+	// Render the ruleset and apply it when it differs from the one on the
+	// node
+	var applyErr error
+	touched := false
+	held := false
+	var rendered string
+	var tmpl *rulesetTemplate
+	groups, err := r.serviceGroups(ctx, &fwl)
+	if err == nil {
+		rendered, tmpl, err = r.render(ctx, &fwl, groups)
 	}
-	if changedTrustIF {
-		trustIf, untrustIf, mgmtAddr, err = getConfig(containerName)
+	if err != nil {
+		log.Error(err, "msg", "line", util.LINE())
+		applyErr = &applyError{ReasonRenderFailed, err}
+	} else {
+		current, err := fwconfig.HashFile(r.rulePath(&fwl))
 		if err != nil {
 			log.Error(err, "msg", "line", util.LINE())
 			return ctrl.Result{}, err
 		}
-		fwl.Status.TrustIf = trustIf
-		res.StatusUpdated = true
+		hash := fwconfig.Hash([]byte(rendered))
+		if !moved && hash == fwl.Status.FailedRulesetHash {
+			// Applying it again would lock the node out again, on every
+			// resync. Wait for another ruleset.
+			applyErr = heldError(&fwl)
+			held = true
+		} else if moved || current != hash {
+			rev, err := r.setConfig(ctx, &fwl, netns, rendered, moved)
+			if err != nil {
+				log.Error(err, "msg", "line", util.LINE())
+				applyErr = err
+				touched = nodeTouched(err)
+				if touched {
+					fwl.Status.FailedRulesetHash = hash
+				}
+			} else {
+				now := metav1.Now()
+				fwl.Status.LastAppliedRevision = rev
+				fwl.Status.LastAppliedTime = &now
+				touched = true
+				if moved {
+					r.leaveNetns(ctx, &fwl, fwl.Status.Netns)
+					r.releaseNetns(req.NamespacedName, fwl.Status.Netns)
+				}
+			}
+		}
+		if applyErr == nil {
+			fwl.Status.FailedRulesetHash = ""
+			fwl.Status.Netns = netns
+			fwl.Status.TemplateRevision = tmpl.Revision
+			fwl.Status.TemplateHash = tmpl.Hash
+			fwl.Status.ServiceGroups = resolvedServiceGroups(groups)
+		}
+	}
+	trustIf, untrustIf, mgmtAddr, err := r.getConfig(&fwl)
+	if err != nil {
+		log.Error(err, "msg", "line", util.LINE())
+		return ctrl.Result{}, err
 	}
 
-	changedUntrustIF := false
-	if untrustIf != fwl.Spec.UntrustIf {
-		newUntrustIF := fwl.Spec.UntrustIf
-		setConfig(containerName, newUntrustIF, trustIf, mgmtAddr)
-		changedUntrustIF = true
+	// Compare the kernel's ruleset with the one we applied
+	if touched {
+		err = r.recordLiveRuleset(ctx, &fwl, netns)
+	} else {
+		err = r.checkDrift(ctx, &fwl, netns)
 	}
-	if changedUntrustIF {
-		trustIf, untrustIf, mgmtAddr, err = getConfig(containerName)
-		if err != nil {
-			log.Error(err, "msg", "line", util.LINE())
-			return ctrl.Result{}, err
-		}
-		fwl.Status.UntrustIf = untrustIf
-		res.StatusUpdated = true
+	if err != nil {
+		log.Error(err, "msg", "line", util.LINE())
+		return ctrl.Result{}, err
 	}
 
-	changedMgmtAddr := false
-	if !fwconfig.MatchElements(mgmtAddr, fwl.Spec.MgmtAddressRange) {
-		newMgmtAddr := fwl.Spec.MgmtAddressRange
-		setConfig(containerName, untrustIf, trustIf, newMgmtAddr)
-		changedMgmtAddr = true
+	// Check that the zone interfaces exist in the namespace
+	if err := r.checkLinks(&fwl, netns); err != nil {
+		log.Error(err, "msg", "line", util.LINE())
+		return ctrl.Result{}, err
 	}
-	if changedMgmtAddr {
-		trustIf, untrustIf, mgmtAddr, err = getConfig(containerName)
-		if err != nil {
-			log.Error(err, "msg", "line", util.LINE())
-			return ctrl.Result{}, err
-		}
-		fwl.Status.MgmtAddressRange = mgmtAddr
+	if r.links != nil {
+		r.links.watch(req.NamespacedName, netns)
+	}
+
+	fwl.Status.Zones = nil
+	if len(trustIf) > 0 {
+		fwl.Status.Zones = append(fwl.Status.Zones, samplecontrollerv2.Zone{Name: samplecontrollerv2.ZoneTrust, Interfaces: trustIf})
+	}
+	if len(untrustIf) > 0 {
+		fwl.Status.Zones = append(fwl.Status.Zones, samplecontrollerv2.Zone{Name: samplecontrollerv2.ZoneUntrust, Interfaces: untrustIf})
+	}
+	fwl.Status.ManagementAddresses = mgmtAddr
+	fwl.Status.RulesetHash, err = fwconfig.HashFile(r.rulePath(&fwl))
+	if err != nil {
+		log.Error(err, "msg", "line", util.LINE())
+		return ctrl.Result{}, err
+	}
+	r.setConditions(&fwl, applyErr)
+	fwl.Status.ObservedGeneration = gen
+	if !equality.Semantic.DeepEqual(origStatus, &fwl.Status) {
 		res.StatusUpdated = true
 	}
 
@@ -167,50 +358,913 @@ func (r *FwLetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 			return ctrl.Result{}, err
 		}
 	}
+	if applyErr != nil && !held {
+		return ctrl.Result{}, applyErr
+	}
 
-	return ctrl.Result{}, nil
+	return ctrl.Result{RequeueAfter: r.ResyncPeriod}, nil
 }
 
-func getConfig(containerName string) ([]string, string, []string, error) {
-	// TODO: config.jsonのパスを入れる
-	trustIn, untrustIn, mgmtAddr, err := fwconfig.RulesReader("/etc/nftables/fw.rule")
+// heldError keeps the outcome of the failed apply of the ruleset fwl renders
+// to, which is not retried.
+func heldError(fwl *samplecontrollerv2.FwLet) error {
+	cond := meta.FindStatusCondition(fwl.Status.Conditions, samplecontrollerv2.ConditionApplied)
+	if cond == nil || cond.Status == metav1.ConditionTrue {
+		return &applyError{ReasonApplyFailed, fmt.Errorf("Ruleset %s was rolled back", fwl.Status.FailedRulesetHash)}
+	}
+	return &applyError{cond.Reason, fmt.Errorf("%s", cond.Message)}
+}
 
+// nodeTouched tells whether a failed ruleset change reached the kernel.
+func nodeTouched(err error) bool {
+	if e, ok := err.(*applyError); ok {
+		return e.Reason != ReasonRenderFailed && e.Reason != ReasonCheckFailed
+	}
+	return true
+}
+
+// recordLiveRuleset remembers the hash of the live ruleset after it was
+// changed by the controller.
+func (r *FwLetReconciler) recordLiveRuleset(ctx context.Context, fwl *samplecontrollerv2.FwLet, netns string) error {
+	live, err := r.Applier.Dump(ctx, netns)
+	if err != nil {
+		return fmt.Errorf("Failed to dump ruleset: %v", err)
+	}
+	fwl.Status.LiveRulesetHash = fwconfig.Hash([]byte(live))
+	r.setDrift(fwl, false, ReasonNoDrift, "")
+	return nil
+}
+
+// checkDrift dumps the live ruleset and compares it with the dump taken
+// after the last apply. Depending on the drift policy the applied ruleset
+// is loaded again or the drift is only reported. Without a dump to compare
+// with, the live ruleset is unknown and treated as drifted rather than
+// trusted.
+func (r *FwLetReconciler) checkDrift(ctx context.Context, fwl *samplecontrollerv2.FwLet, netns string) error {
+	if fwl.Status.LiveRulesetHash == "" && fwl.Status.LastAppliedRevision == 0 {
+		// Nothing was applied yet, the ruleset on the node is not ours.
+		return nil
+	}
+	live, err := r.Applier.Dump(ctx, netns)
 	if err != nil {
-		return nil, "", nil, err
+		return fmt.Errorf("Failed to dump ruleset: %v", err)
+	}
+	hash := fwconfig.Hash([]byte(live))
+	if hash == fwl.Status.LiveRulesetHash {
+		r.setDrift(fwl, false, ReasonNoDrift, "")
+		return nil
+	}
+
+	msg := "live ruleset differs from the applied one"
+	if fwl.Status.LiveRulesetHash == "" {
+		msg = "live ruleset was not dumped after the last apply"
+	}
+	driftTotal.WithLabelValues(fwl.GetNamespace(), fwl.GetName()).Inc()
+	if fwl.Spec.DriftPolicy == samplecontrollerv2.DriftPolicyReport {
+		if !meta.IsStatusConditionTrue(fwl.Status.Conditions, samplecontrollerv2.ConditionDriftDetected) {
+			r.event(fwl, corev1.EventTypeWarning, ReasonDriftReported, "Revision %d: %s", fwl.Status.LastAppliedRevision, msg)
+		}
+		r.setDrift(fwl, true, ReasonDriftReported, msg)
+		return nil
+	}
+
+	if _, err := os.Stat(r.rulePath(fwl)); err != nil {
+		r.setDrift(fwl, true, ReasonReapplyFailed, "no applied ruleset to restore")
+		return nil
+	}
+	if err := r.Applier.Apply(ctx, netns, r.rulePath(fwl)); err != nil {
+		r.setDrift(fwl, true, ReasonReapplyFailed, err.Error())
+		return fmt.Errorf("Failed to reapply drifted ruleset: %v", err)
+	}
+	r.event(fwl, corev1.EventTypeNormal, ReasonDriftCorrected, "Reapplied revision %d over a drifted ruleset", fwl.Status.LastAppliedRevision)
+	if err := r.recordLiveRuleset(ctx, fwl, netns); err != nil {
+		return err
+	}
+	r.setDrift(fwl, false, ReasonDriftCorrected, fmt.Sprintf("reapplied revision %d", fwl.Status.LastAppliedRevision))
+	return nil
+}
+
+func (r *FwLetReconciler) setDrift(fwl *samplecontrollerv2.FwLet, drifted bool, reason, msg string) {
+	status := metav1.ConditionFalse
+	gauge := 0.0
+	if drifted {
+		status = metav1.ConditionTrue
+		gauge = 1
+	}
+	driftDetected.WithLabelValues(fwl.GetNamespace(), fwl.GetName()).Set(gauge)
+	setCondition(&fwl.Status.Conditions, fwl.GetGeneration(), samplecontrollerv2.ConditionDriftDetected, status, reason, msg)
+}
+
+// setConditions records the outcome of the reconcile in the FwLet
+// conditions. applyErr is the error of the ruleset change, if one was made.
+func (r *FwLetReconciler) setConditions(fwl *samplecontrollerv2.FwLet, applyErr error) {
+	conds := &fwl.Status.Conditions
+	gen := fwl.GetGeneration()
+	if applyErr == nil {
+		setCondition(conds, gen, samplecontrollerv2.ConditionApplied, metav1.ConditionTrue, ReasonApplied,
+			fmt.Sprintf("revision %d is enforced", fwl.Status.LastAppliedRevision))
+		setLinksDegraded(fwl)
+	} else {
+		reason := ReasonApplyFailed
+		if e, ok := applyErr.(*applyError); ok {
+			reason = e.Reason
+		}
+		setCondition(conds, gen, samplecontrollerv2.ConditionApplied, metav1.ConditionFalse, reason, applyErr.Error())
+		switch reason {
+		case ReasonRenderFailed, ReasonCheckFailed:
+			// The node was not touched and still runs the previous ruleset.
+			setLinksDegraded(fwl)
+		default:
+			setCondition(conds, gen, samplecontrollerv2.ConditionDegraded, metav1.ConditionTrue, reason, applyErr.Error())
+		}
+	}
+	if meta.FindStatusCondition(*conds, samplecontrollerv2.ConditionDriftDetected) == nil {
+		setCondition(conds, gen, samplecontrollerv2.ConditionDriftDetected, metav1.ConditionUnknown, ReasonNotChecked, "")
+	}
+	setReady(conds, gen)
+}
+
+// setLinksDegraded sets Degraded when zone interfaces are missing, and
+// clears it otherwise.
+func setLinksDegraded(fwl *samplecontrollerv2.FwLet) {
+	if missing := fwl.Status.MissingInterfaces; len(missing) > 0 {
+		setCondition(&fwl.Status.Conditions, fwl.GetGeneration(), samplecontrollerv2.ConditionDegraded, metav1.ConditionTrue, ReasonInterfacesMissing,
+			"zone interfaces do not exist: "+strings.Join(missing, ", "))
+		return
+	}
+	setCondition(&fwl.Status.Conditions, fwl.GetGeneration(), samplecontrollerv2.ConditionDegraded, metav1.ConditionFalse, ReasonAsExpected, "")
+}
+
+// checkLinks records the links of netns and the zone interfaces missing
+// among them. nftables takes any name in oifname, so a misspelt interface
+// would otherwise give rules that silently never match.
+func (r *FwLetReconciler) checkLinks(fwl *samplecontrollerv2.FwLet, netns string) error {
+	if r.LinkMonitor == nil {
+		return nil
+	}
+	links, err := r.LinkMonitor.Links(netns)
+	if err != nil {
+		return fmt.Errorf("Failed to list links: %v", err)
+	}
+	exists := map[string]bool{}
+	for _, link := range links {
+		exists[link.Name] = true
+	}
+	var missing []string
+	for _, z := range fwl.Spec.Zones {
+		for _, ifname := range z.Interfaces {
+			if !exists[ifname] {
+				missing = append(missing, ifname)
+			}
+		}
+	}
+	sort.Strings(missing)
+	if len(missing) > 0 && !reflect.DeepEqual(missing, fwl.Status.MissingInterfaces) {
+		r.event(fwl, corev1.EventTypeWarning, ReasonInterfacesMissing, "Zone interfaces do not exist in netns %s: %s", netns, strings.Join(missing, ", "))
+	}
+	fwl.Status.Links = interfaceStatuses(links)
+	fwl.Status.MissingInterfaces = missing
+	return nil
+}
+
+// forgetLinks stops watching links for the FwLet key.
+func (r *FwLetReconciler) forgetLinks(key types.NamespacedName) {
+	if r.links != nil {
+		r.links.forget(key)
+	}
+}
+
+func (r *FwLetReconciler) getConfig(fwl *samplecontrollerv2.FwLet) ([]string, []string, []string, error) {
+	if _, err := os.Stat(r.rulePath(fwl)); os.IsNotExist(err) {
+		return nil, nil, nil, nil
+	}
+	trustIn, untrustIn, mgmtAddr, err := fwconfig.RulesReader(r.rulePath(fwl))
+
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
 	return trustIn, untrustIn, mgmtAddr, nil
 }
 
-func setConfig(containerName, untrustif_name string, trustif_name, mgmtaddress []string) error {
-	// update fwconfig.json
-	err := fwconfig.RuleUpdate(
-		containerName,
-		"/etc/nftables/fw-template.rule",
-		"/etc/nftables/fw.rule",
-		untrustif_name,
-		trustif_name,
-		mgmtaddress,
-	)
+// setConfig renders the ruleset next to the live rule file and lets nft
+// validate it before it replaces the rule file and is applied, so that a
+// broken spec never reaches the `flush ruleset` of the running firewall.
+// If the apply fails or the node does not pass the probe within the
+// confirm window, the last applied revision is restored. It returns the
+// history revision of the applied ruleset. moved loads the whole ruleset
+// into a namespace the rule file was not applied to.
+func (r *FwLetReconciler) setConfig(ctx context.Context, fwl *samplecontrollerv2.FwLet, netns, rendered string, moved bool) (int64, error) {
+	lastGood := fwl.Status.LastAppliedRevision
+	rulePath := r.rulePath(fwl)
+	if err := os.MkdirAll(filepath.Dir(rulePath), 0755); err != nil {
+		return 0, &applyError{ReasonRenderFailed, fmt.Errorf("Failed to create state dir: %v", err)}
+	}
+	staged, err := os.CreateTemp(filepath.Dir(rulePath), "."+filepath.Base(rulePath)+"-*")
+	if err != nil {
+		return 0, &applyError{ReasonRenderFailed, fmt.Errorf("Failed to create staging file: %v", err)}
+	}
+	stagedPath := staged.Name()
+	defer os.Remove(stagedPath)
+	_, err = staged.WriteString(rendered)
+	if cerr := staged.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, &applyError{ReasonRenderFailed, fmt.Errorf("Failed to write staging file: %v", err)}
+	}
+	next, err := fwconfig.ParseFile(stagedPath)
+	if err != nil {
+		return 0, &applyError{ReasonRenderFailed, err}
+	}
+	if err := r.Applier.Check(ctx, netns, stagedPath); err != nil {
+		return 0, &applyError{ReasonCheckFailed, fmt.Errorf("Rejected rendered ruleset: %v", err)}
+	}
+	prev, err := fwconfig.ParseFile(rulePath)
+	if err == nil && !moved {
+		var changes []string
+		for _, c := range fwconfig.Diff(prev, next) {
+			changes = append(changes, c.String())
+		}
+		log.FromContext(ctx).Info("Ruleset changes", "changes", changes)
+	} else {
+		prev = nil
+	}
+
+	history := r.history(fwl)
+	rev, err := history.Save(stagedPath, lastGood)
+	if err != nil {
+		return 0, &applyError{ReasonRenderFailed, err}
+	}
+	if err := os.Rename(stagedPath, rulePath); err != nil {
+		return 0, &applyError{ReasonRenderFailed, fmt.Errorf("Failed to replace %s: %v", rulePath, err)}
+	}
+	if err := r.recordNetns(fwl, netns); err != nil {
+		return 0, &applyError{ReasonRenderFailed, err}
+	}
+	if err := r.load(ctx, fwl, netns, prev, next); err != nil {
+		return 0, r.rollback(ctx, fwl, netns, lastGood, fmt.Errorf("Failed to apply revision %d: %v", rev, err))
+	}
+	if err := r.confirm(ctx, netns); err != nil {
+		return 0, r.rollback(ctx, fwl, netns, lastGood, fmt.Errorf("Revision %d not confirmed: %v", rev, err))
+	}
+	return rev, nil
+}
+
+// load brings the kernel from the ruleset prev to the rule file next.
+// When only the elements of named sets changed, the elements are added and
+// deleted in place instead of reloading the whole ruleset.
+func (r *FwLetReconciler) load(ctx context.Context, fwl *samplecontrollerv2.FwLet, netns string, prev, next *fwconfig.Ruleset) error {
+	if prev != nil {
+		if script, ok := fwconfig.SetUpdates(prev, next); ok {
+			if script == "" {
+				return nil
+			}
+			log.FromContext(ctx).Info("Updating set elements", "script", script)
+			return r.applyScript(ctx, fwl, netns, script)
+		}
+	}
+	return r.Applier.Apply(ctx, netns, r.rulePath(fwl))
+}
+
+// teardown leaves the baseline ruleset of a deleted FwLet on the node and
+// removes its rule file or state dir, so a recreated FwLet renders from
+// scratch. A namespace that is gone, such as the one of a removed
+// container, has no firewall left to tear down.
+func (r *FwLetReconciler) teardown(ctx context.Context, fwl *samplecontrollerv2.FwLet) error {
+	// The namespace applied to is torn down, whatever spec says now.
+	netns, err := r.recordedNetns(client.ObjectKeyFromObject(fwl))
+	if err == nil && netns != "" {
+		err = executer.CheckNetns(netns)
+	} else if err == nil {
+		netns, err = r.netns(ctx, fwl)
+	}
+	var owner *types.NamespacedName
+	if err == nil {
+		if owner, err = r.claimNetns(ctx, fwl, netns); err != nil {
+			return err
+		}
+	}
+	switch {
+	case err != nil:
+		r.event(fwl, corev1.EventTypeWarning, "BaselineSkipped", "Skipped baseline: %v", err)
+	case owner != nil:
+		// The namespace is firewalled by the FwLet that holds it.
+		r.event(fwl, corev1.EventTypeNormal, "BaselineSkipped", "Skipped baseline: netns %s is enforced by FwLet %s", netns, owner)
+	default:
+		if err := r.applyBaseline(ctx, fwl, netns); err != nil {
+			return err
+		}
+	}
+	if r.StateDir != "" {
+		return os.RemoveAll(r.stateDir(fwl))
+	}
+	if err := os.Remove(r.rulePath(fwl)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// unbind tears down the FwLet key if this agent applied it, as when its
+// region moved to another node, and stops watching it. fwl is nil when the
+// FwLet left the cache.
+func (r *FwLetReconciler) unbind(ctx context.Context, key types.NamespacedName, fwl *samplecontrollerv2.FwLet) error {
+	netns, err := r.recordedNetns(key)
+	if err != nil {
+		return err
+	}
+	if netns != "" {
+		if fwl == nil {
+			fwl = &samplecontrollerv2.FwLet{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}}
+			if r.APIReader != nil {
+				if err := r.APIReader.Get(ctx, key, fwl); err != nil && !errors.IsNotFound(err) {
+					return err
+				}
+			}
+		}
+		r.event(fwl, corev1.EventTypeNormal, "Unbound", "No longer enforced by agent %s", r.AgentName)
+		if err := r.teardown(ctx, fwl); err != nil {
+			return err
+		}
+	}
+	r.forgetLinks(key)
+	r.releaseNetns(key)
+	return nil
+}
+
+// recordNetns records in the state dir of fwl the namespace its rulesets
+// are applied to, so that it is torn down even once fwl is gone.
+func (r *FwLetReconciler) recordNetns(fwl *samplecontrollerv2.FwLet, netns string) error {
+	if r.StateDir == "" {
+		return nil
+	}
+	if err := os.WriteFile(filepath.Join(r.stateDir(fwl), netnsRecord), []byte(netns+"\n"), 0644); err != nil {
+		return fmt.Errorf("Failed to record netns: %v", err)
+	}
+	return nil
+}
+
+// recordedNetns is the namespace this agent applied the FwLet key to, or
+// "" if it applied none.
+func (r *FwLetReconciler) recordedNetns(key types.NamespacedName) (string, error) {
+	if r.StateDir == "" {
+		return "", nil
+	}
+	b, err := os.ReadFile(filepath.Join(r.StateDir, key.Namespace, key.Name, netnsRecord))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// recordedFwLets enqueues every FwLet this agent applied, so that those
+// that stopped being bound while it was down are torn down.
+func (r *FwLetReconciler) recordedFwLets(ctx context.Context, _ handler.EventHandler, q workqueue.RateLimitingInterface, _ ...predicate.Predicate) error {
+	records, err := filepath.Glob(filepath.Join(r.StateDir, "*", "*", netnsRecord))
 	if err != nil {
 		return err
 	}
-	err= executer.ExecCommand(
-		containerName,
-	)
+	for _, record := range records {
+		dir := filepath.Dir(record)
+		q.Add(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: filepath.Base(filepath.Dir(dir)), Name: filepath.Base(dir)}})
+	}
+	return nil
+}
+
+// leaveNetns applies the baseline to the namespace fwl was enforced in
+// before it moved to another one. Failing to do so is only reported.
+func (r *FwLetReconciler) leaveNetns(ctx context.Context, fwl *samplecontrollerv2.FwLet, netns string) {
+	if err := executer.CheckNetns(netns); err != nil {
+		return
+	}
+	if err := r.applyBaseline(ctx, fwl, netns); err != nil {
+		log.FromContext(ctx).Error(err, "msg", "line", util.LINE())
+	}
+}
+
+// applyBaseline applies the baseline ruleset of fwl to netns.
+func (r *FwLetReconciler) applyBaseline(ctx context.Context, fwl *samplecontrollerv2.FwLet, netns string) error {
+	baseline := samplecontrollerv2.BaselineSpec{Mode: r.DefaultBaseline}
+	if fwl.Spec.Baseline != nil {
+		baseline = *fwl.Spec.Baseline
+	}
+	if baseline.Mode == "" {
+		baseline.Mode = samplecontrollerv2.BaselineFailOpen
+	}
+
+	script, err := fwconfig.BaselineRuleset(string(baseline.Mode), baseline.Template, r.baselineDir())
+	if err == nil {
+		err = r.applyScript(ctx, fwl, netns, script)
+	}
+	if err != nil {
+		r.event(fwl, corev1.EventTypeWarning, "BaselineFailed", "Failed to apply %s baseline to netns %s: %v", baseline.Mode, netns, err)
+		return err
+	}
+	name := string(baseline.Mode)
+	if baseline.Mode == samplecontrollerv2.BaselineTemplate {
+		name += " " + baseline.Template
+	}
+	r.event(fwl, corev1.EventTypeNormal, "BaselineApplied", "Applied %s baseline to netns %s", name, netns)
+	return nil
+}
+
+// claimNetns claims netns for fwl. If another bound FwLet holds it, or was
+// last enforced in it, that FwLet keeps it and is returned.
+func (r *FwLetReconciler) claimNetns(ctx context.Context, fwl *samplecontrollerv2.FwLet, netns string) (*types.NamespacedName, error) {
+	key := client.ObjectKeyFromObject(fwl)
+	r.claimsMu.Lock()
+	defer r.claimsMu.Unlock()
+	if r.claims == nil {
+		r.claims = map[string]types.NamespacedName{}
+	}
+	owner, ok := r.claims[netns]
+	if !ok {
+		// The claims are lost on restart, the status is not.
+		fwls := samplecontrollerv2.FwLetList{}
+		if err := r.List(ctx, &fwls); err != nil {
+			return nil, err
+		}
+		owner = key
+		for i := range fwls.Items {
+			other := &fwls.Items[i]
+			if other.Status.Netns == netns && client.ObjectKeyFromObject(other) != key && r.Bound(other) && other.DeletionTimestamp.IsZero() {
+				owner = client.ObjectKeyFromObject(other)
+				break
+			}
+		}
+		r.claims[netns] = owner
+	}
+	if owner != key {
+		return &owner, nil
+	}
+	return nil, nil
+}
+
+// releaseNetns releases the namespaces claimed by the FwLet key, or only
+// those given.
+func (r *FwLetReconciler) releaseNetns(key types.NamespacedName, netns ...string) {
+	r.claimsMu.Lock()
+	defer r.claimsMu.Unlock()
+	for ns, owner := range r.claims {
+		if owner != key {
+			continue
+		}
+		for _, n := range netns {
+			if n == ns {
+				delete(r.claims, ns)
+			}
+		}
+		if len(netns) == 0 {
+			delete(r.claims, ns)
+		}
+	}
+}
+
+// netns resolves the network namespace fwl is enforced in to the argument
+// of the Applier: a name under /var/run/netns or a path. A namespace set in
+// spec must exist.
+func (r *FwLetReconciler) netns(ctx context.Context, fwl *samplecontrollerv2.FwLet) (string, error) {
+	ns := fwl.Spec.Netns
+	if ns == nil {
+		return r.Netns, nil
+	}
+	var target string
+	switch {
+	case ns.Name != "":
+		target = ns.Name
+	case ns.Path != "":
+		target = ns.Path
+	case ns.Container != "":
+		if r.ContainerRuntime == nil {
+			return "", fmt.Errorf("No container runtime to look up container %s", ns.Container)
+		}
+		path, err := r.ContainerRuntime.NetnsPath(ctx, ns.Container)
+		if err != nil {
+			return "", err
+		}
+		target = path
+	default:
+		return "", fmt.Errorf("Netns sets neither name, path nor container")
+	}
+	if err := executer.CheckNetns(target); err != nil {
+		return "", err
+	}
+	if !r.AllowHostNetns {
+		host, err := executer.HostNetns(target)
+		if err != nil {
+			return "", err
+		}
+		if host {
+			return "", fmt.Errorf("Netns %s is the namespace of the agent or the host", target)
+		}
+	}
+	return target, nil
+}
+
+func (r *FwLetReconciler) event(fwl *samplecontrollerv2.FwLet, eventtype, reason, format string, args ...interface{}) {
+	if r.Recorder != nil {
+		r.Recorder.Eventf(fwl, eventtype, reason, format, args...)
+	}
+}
+
+// applyScript checks and applies a ruleset script that is not tracked in
+// the rule file or history.
+func (r *FwLetReconciler) applyScript(ctx context.Context, fwl *samplecontrollerv2.FwLet, netns, script string) error {
+	dir := filepath.Dir(r.rulePath(fwl))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Failed to create state dir: %v", err)
+	}
+	f, err := os.CreateTemp(dir, ".script-*")
+	if err != nil {
+		return fmt.Errorf("Failed to create script file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(script); err != nil {
+		f.Close()
+		return fmt.Errorf("Failed to write script file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Failed to write script file: %v", err)
+	}
+	if err := r.Applier.Check(ctx, netns, f.Name()); err != nil {
+		return fmt.Errorf("Rejected script: %v", err)
+	}
+	return r.Applier.Apply(ctx, netns, f.Name())
+}
+
+// confirm polls the prober until it succeeds or the confirm window ends.
+func (r *FwLetReconciler) confirm(ctx context.Context, netns string) error {
+	if r.Prober == nil {
+		return nil
+	}
+	var lastErr error
+	err := wait.PollUntilContextTimeout(ctx, time.Second, r.ConfirmWindow, true, func(ctx context.Context) (bool, error) {
+		lastErr = r.Prober.Probe(ctx, netns)
+		return lastErr == nil, nil
+	})
+	if err != nil && lastErr != nil {
+		return lastErr
+	}
 	return err
 }
 
-func convContainerName(rawName string) string {
-	runes := []rune(rawName)
-	runes[0] = []rune(strings.ToUpper(string(runes[0])))[0]
-	runes[len(runes)-1] = []rune(strings.ToUpper(string(runes[len(runes)-1])))[0]
-	return string(runes)
+// rollback restores revision rev into the live rule file and applies it.
+// Without a known good revision the baseline of fwl is applied instead, and
+// failing that the ruleset is flushed, so that the unconfirmed ruleset does
+// not stay live. The returned error always wraps cause.
+func (r *FwLetReconciler) rollback(ctx context.Context, fwl *samplecontrollerv2.FwLet, netns string, rev int64, cause error) error {
+	if rev == 0 {
+		// Drop the rule file so the next reconcile does not mistake it for
+		// an enforced ruleset.
+		os.Remove(r.rulePath(fwl))
+		if err := r.applyBaseline(ctx, fwl, netns); err == nil {
+			return &applyError{ReasonRolledBack, fmt.Errorf("%v; no known good revision, applied the baseline", cause)}
+		}
+		if err := r.applyScript(ctx, fwl, netns, "flush ruleset\n"); err != nil {
+			return &applyError{ReasonRollbackFailed, fmt.Errorf("%v; no known good revision and flushing the ruleset failed: %v", cause, err)}
+		}
+		return &applyError{ReasonRolledBack, fmt.Errorf("%v; no known good revision, flushed the ruleset", cause)}
+	}
+	if err := r.history(fwl).Restore(rev, r.rulePath(fwl)); err != nil {
+		return &applyError{ReasonRollbackFailed, fmt.Errorf("%v; rollback failed: %v", cause, err)}
+	}
+	if err := r.Applier.Apply(ctx, netns, r.rulePath(fwl)); err != nil {
+		return &applyError{ReasonRollbackFailed, fmt.Errorf("%v; rollback to revision %d failed: %v", cause, rev, err)}
+	}
+	return &applyError{ReasonRolledBack, fmt.Errorf("%v; rolled back to revision %d", cause, rev)}
+}
+
+func (r *FwLetReconciler) history(fwl *samplecontrollerv2.FwLet) *fwconfig.History {
+	h := &fwconfig.History{Dir: r.HistoryDir, Limit: r.HistoryLimit}
+	if r.StateDir != "" {
+		h.Dir = filepath.Join(r.stateDir(fwl), "history")
+	}
+	if h.Dir == "" {
+		h.Dir = defaultHistoryDir
+	}
+	if h.Limit == 0 {
+		h.Limit = defaultHistoryLimit
+	}
+	return h
+}
+
+func (r *FwLetReconciler) templatePath() string {
+	if r.TemplatePath == "" {
+		return defaultTemplatePath
+	}
+	return r.TemplatePath
+}
+
+func (r *FwLetReconciler) baselineDir() string {
+	if r.BaselineDir == "" {
+		return defaultBaselineDir
+	}
+	return r.BaselineDir
+}
+
+func (r *FwLetReconciler) rulePath(fwl *samplecontrollerv2.FwLet) string {
+	if r.StateDir != "" {
+		return filepath.Join(r.stateDir(fwl), "fw.rule")
+	}
+	if r.RulePath == "" {
+		return defaultRulePath
+	}
+	return r.RulePath
+}
+
+// stateDir is the directory holding the rule file and history of fwl
+// under StateDir.
+func (r *FwLetReconciler) stateDir(fwl *samplecontrollerv2.FwLet) string {
+	return filepath.Join(r.StateDir, fwl.GetNamespace(), fwl.GetName())
+}
+
+// Bound tells whether the FwLet obj is enforced by this agent.
+func (r *FwLetReconciler) Bound(obj client.Object) bool {
+	for _, key := range r.Instances {
+		if client.ObjectKeyFromObject(obj) == key {
+			return true
+		}
+	}
+	return r.Selector != nil && r.Selector.Matches(labels.Set(obj.GetLabels()))
+}
+
+// templateNamespace tells whether FwLets of namespace may reference
+// template ConfigMaps.
+func (r *FwLetReconciler) templateNamespace(namespace string) bool {
+	if len(r.TemplateNamespaces) == 0 {
+		return true
+	}
+	for _, ns := range r.TemplateNamespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// rulesetTemplate is a ruleset template and where it was loaded from.
+type rulesetTemplate struct {
+	Content  string
+	Revision string
+	Hash     string
+}
+
+// loadTemplate reads the template referenced by fwl, or the agent's
+// template file if it references none.
+func (r *FwLetReconciler) loadTemplate(ctx context.Context, fwl *samplecontrollerv2.FwLet) (*rulesetTemplate, error) {
+	ref := fwl.Spec.TemplateRef
+	if ref == nil {
+		content, err := os.ReadFile(r.templatePath())
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open template file: %v", err)
+		}
+		return &rulesetTemplate{string(content), r.templatePath(), fwconfig.Hash(content)}, nil
+	}
+
+	key := ref.Key
+	if key == "" {
+		key = samplecontrollerv2.DefaultTemplateKey
+	}
+	if !r.templateNamespace(fwl.GetNamespace()) {
+		return nil, fmt.Errorf("Templates are not read from the ConfigMaps of namespace %s", fwl.GetNamespace())
+	}
+	var reader client.Reader = r.Client
+	if r.TemplateCache != nil {
+		reader = r.TemplateCache
+	}
+	cm := corev1.ConfigMap{}
+	if err := reader.Get(ctx, client.ObjectKey{Namespace: fwl.GetNamespace(), Name: ref.Name}, &cm); err != nil {
+		return nil, fmt.Errorf("Failed to get template ConfigMap %s: %v", ref.Name, err)
+	}
+	content, ok := cm.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("ConfigMap %s has no key %s", ref.Name, key)
+	}
+	return &rulesetTemplate{
+		Content:  content,
+		Revision: fmt.Sprintf("%s/%s@%s", ref.Name, key, cm.GetResourceVersion()),
+		Hash:     fwconfig.Hash([]byte(content)),
+	}, nil
+}
+
+// serviceGroups gets the ServiceGroups referenced by the rules of fwl, in
+// the order they are first referenced.
+func (r *FwLetReconciler) serviceGroups(ctx context.Context, fwl *samplecontrollerv2.FwLet) ([]fwconfig.ServiceGroup, error) {
+	var groups []fwconfig.ServiceGroup
+	seen := map[string]bool{}
+	for _, rule := range fwl.Spec.Rules {
+		for _, name := range rule.ServiceGroups {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			sg := samplecontrollerv2.ServiceGroup{}
+			if err := r.Get(ctx, client.ObjectKey{Namespace: fwl.GetNamespace(), Name: name}, &sg); err != nil {
+				if errors.IsNotFound(err) {
+					return nil, fmt.Errorf("ServiceGroup %s not found", name)
+				}
+				return nil, fmt.Errorf("Failed to get ServiceGroup %s: %v", name, err)
+			}
+			if len(sg.Spec.Services) == 0 {
+				// The rules would match any protocol.
+				return nil, fmt.Errorf("ServiceGroup %s has no services", name)
+			}
+			g := fwconfig.ServiceGroup{Name: name}
+			for _, s := range sg.Spec.Services {
+				g.Services = append(g.Services, fwconfigService(s.Protocol, s.Ports, s.ICMPTypes))
+			}
+			groups = append(groups, g)
+		}
+	}
+	return groups, nil
+}
+
+func fwconfigService(protocol string, ports []samplecontrollerv2.PortRange, icmpTypes []string) fwconfig.Service {
+	s := fwconfig.Service{Protocol: protocol, ICMPTypes: icmpTypes}
+	for _, p := range ports {
+		s.Ports = append(s.Ports, string(p))
+	}
+	return s
+}
+
+// resolvedServiceGroups is the status of the service groups a ruleset was
+// rendered with.
+func resolvedServiceGroups(groups []fwconfig.ServiceGroup) []samplecontrollerv2.ResolvedServiceGroup {
+	var resolved []samplecontrollerv2.ResolvedServiceGroup
+	for _, g := range groups {
+		rg := samplecontrollerv2.ResolvedServiceGroup{Name: g.Name}
+		for _, s := range g.Services {
+			svc := samplecontrollerv2.Service{Protocol: s.Protocol, ICMPTypes: s.ICMPTypes}
+			for _, p := range s.Ports {
+				svc.Ports = append(svc.Ports, samplecontrollerv2.PortRange(p))
+			}
+			rg.Services = append(rg.Services, svc)
+		}
+		resolved = append(resolved, rg)
+	}
+	return resolved
+}
+
+// render renders the ruleset of fwl from its template.
+func (r *FwLetReconciler) render(ctx context.Context, fwl *samplecontrollerv2.FwLet, groups []fwconfig.ServiceGroup) (string, *rulesetTemplate, error) {
+	tmpl, err := r.loadTemplate(ctx, fwl)
+	if err != nil {
+		return "", nil, err
+	}
+	rendered, err := fwconfig.Render(tmpl.Content, r.TemplateMode, firewallModel(fwl, groups))
+	if err != nil {
+		return "", nil, err
+	}
+	return rendered, tmpl, nil
+}
+
+// firewallModel is the data the ruleset template of fwl is rendered with.
+// groups are the service groups referenced by its rules.
+func firewallModel(fwl *samplecontrollerv2.FwLet, groups []fwconfig.ServiceGroup) *fwconfig.FirewallModel {
+	m := &fwconfig.FirewallModel{
+		Name:             fwl.GetName(),
+		MgmtAddressRange: fwl.Spec.ManagementAddresses,
+		ICMPRateLimit:    string(fwl.Spec.ICMPRateLimit),
+		ServiceGroups:    groups,
+	}
+	services := map[string][]fwconfig.Service{}
+	for _, g := range groups {
+		services[g.Name] = g.Services
+	}
+	for _, z := range fwl.Spec.Zones {
+		// A second trust or untrust zone is left to fail rendering as a
+		// duplicate.
+		switch {
+		case z.Name == samplecontrollerv2.ZoneTrust && m.TrustIf == nil:
+			m.TrustIf = z.Interfaces
+		case z.Name == samplecontrollerv2.ZoneUntrust && m.UntrustIfs == nil:
+			m.UntrustIfs = z.Interfaces
+		default:
+			m.Zones = append(m.Zones, fwconfig.Zone{Name: string(z.Name), Interfaces: z.Interfaces})
+		}
+	}
+	if len(m.UntrustIfs) > 0 {
+		m.UntrustIf = m.UntrustIfs[0]
+	}
+	for _, p := range fwl.Spec.ZonePolicies {
+		m.Policies = append(m.Policies, fwconfig.ZonePolicy{From: string(p.From), To: string(p.To), Default: string(p.Default)})
+	}
+	for _, rule := range fwl.Spec.Rules {
+		own := fwconfigService(rule.Protocol, rule.Ports, rule.ICMPTypes)
+		pr := fwconfig.PolicyRule{
+			Name:        rule.Name,
+			From:        string(rule.From),
+			To:          string(rule.To),
+			Source:      rule.Source,
+			Destination: rule.Destination,
+			Protocol:    own.Protocol,
+			Ports:       own.Ports,
+			ICMPTypes:   own.ICMPTypes,
+			Action:      string(rule.Action),
+		}
+		for _, name := range rule.ServiceGroups {
+			pr.Services = append(pr.Services, services[name]...)
+		}
+		m.Rules = append(m.Rules, pr)
+	}
+	return m
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *FwLetReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&samplecontrollerv1.FwLet{}).
-		Complete(r)
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &samplecontrollerv2.FwLet{}, templateRefIndex, templateRefName); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &samplecontrollerv2.FwLet{}, serviceGroupIndex, serviceGroupNames); err != nil {
+		return err
+	}
+	// FwLets sharing a rule file must not be applied at once.
+	concurrency := 1
+	if r.StateDir != "" && r.MaxConcurrentReconciles > 1 {
+		concurrency = r.MaxConcurrentReconciles
+	}
+	// A FwLet that stops being bound is still reconciled, to tear it down.
+	templates := mgr.GetCache()
+	if r.TemplateCache != nil {
+		templates = r.TemplateCache
+	}
+	bound := predicate.NewPredicateFuncs(r.Bound)
+	bound.UpdateFunc = func(e event.UpdateEvent) bool {
+		return r.Bound(e.ObjectOld) || r.Bound(e.ObjectNew)
+	}
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&samplecontrollerv2.FwLet{}, builder.WithPredicates(bound)).
+		WatchesRawSource(source.Kind(templates, &corev1.ConfigMap{}), handler.EnqueueRequestsFromMapFunc(r.fwLetsForConfigMap)).
+		Watches(&samplecontrollerv2.ServiceGroup{}, handler.EnqueueRequestsFromMapFunc(r.fwLetsForServiceGroup)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: concurrency})
+	if r.WatchLinks && r.LinkMonitor != nil {
+		r.links = newLinkWatcher(r.LinkMonitor)
+		if err := mgr.Add(r.links); err != nil {
+			return err
+		}
+		b = b.WatchesRawSource(&source.Channel{Source: r.links.events}, &handler.EnqueueRequestForObject{})
+	}
+	if r.StateDir != "" {
+		b = b.WatchesRawSource(source.Func(r.recordedFwLets), &handler.EnqueueRequestForObject{})
+	}
+	return b.Complete(r)
+}
+
+// templateRefIndex indexes FwLets by the ConfigMap holding their template.
+const templateRefIndex = ".spec.templateRef.name"
+
+func templateRefName(obj client.Object) []string {
+	fwl := obj.(*samplecontrollerv2.FwLet)
+	if fwl.Spec.TemplateRef == nil {
+		return nil
+	}
+	return []string{fwl.Spec.TemplateRef.Name}
+}
+
+// fwLetsForConfigMap re-renders the FwLets whose template is in cm.
+func (r *FwLetReconciler) fwLetsForConfigMap(ctx context.Context, cm client.Object) []reconcile.Request {
+	fwls := samplecontrollerv2.FwLetList{}
+	if err := r.List(ctx, &fwls, client.InNamespace(cm.GetNamespace()), client.MatchingFields{templateRefIndex: cm.GetName()}); err != nil {
+		log.FromContext(ctx).Error(err, "msg", "line", util.LINE())
+		return nil
+	}
+	var reqs []reconcile.Request
+	for _, fwl := range fwls.Items {
+		if r.Bound(&fwl) {
+			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&fwl)})
+		}
+	}
+	return reqs
+}
+
+// serviceGroupIndex indexes FwLets by the ServiceGroups their rules
+// reference.
+const serviceGroupIndex = ".spec.rules.serviceGroups"
+
+func serviceGroupNames(obj client.Object) []string {
+	fwl := obj.(*samplecontrollerv2.FwLet)
+	var names []string
+	for _, rule := range fwl.Spec.Rules {
+		names = append(names, rule.ServiceGroups...)
+	}
+	return names
+}
+
+// fwLetsForServiceGroup re-renders the FwLets whose rules reference sg.
+func (r *FwLetReconciler) fwLetsForServiceGroup(ctx context.Context, sg client.Object) []reconcile.Request {
+	fwls := samplecontrollerv2.FwLetList{}
+	if err := r.List(ctx, &fwls, client.InNamespace(sg.GetNamespace()), client.MatchingFields{serviceGroupIndex: sg.GetName()}); err != nil {
+		log.FromContext(ctx).Error(err, "msg", "line", util.LINE())
+		return nil
+	}
+	var reqs []reconcile.Request
+	for _, fwl := range fwls.Items {
+		if r.Bound(&fwl) {
+			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&fwl)})
+		}
+	}
+	return reqs
 }
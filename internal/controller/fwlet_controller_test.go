@@ -0,0 +1,1239 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	samplecontrollerv2 "github.com/Yosshi72/fw-controller/api/v2"
+	"github.com/Yosshi72/fw-controller/pkg/executer"
+)
+
+func newTestFwLetReconciler(t *testing.T, objs ...client.Object) (*FwLetReconciler, *executer.FakeApplier) {
+	t.Helper()
+	s := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := samplecontrollerv2.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	builder := fake.NewClientBuilder().
+		WithScheme(s).
+		WithObjects(objs...).
+		WithStatusSubresource(&samplecontrollerv2.FwLet{}, &samplecontrollerv2.FwAgent{}).
+		WithIndex(&samplecontrollerv2.FwLet{}, templateRefIndex, templateRefName).
+		WithIndex(&samplecontrollerv2.FwLet{}, serviceGroupIndex, serviceGroupNames)
+
+	dir := t.TempDir()
+	rulePath := filepath.Join(dir, "fw.rule")
+	if err := os.WriteFile(rulePath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	applier := executer.NewFakeApplier()
+	return &FwLetReconciler{
+		Client:       builder.Build(),
+		Scheme:       s,
+		Applier:      applier,
+		Netns:        "vSIX",
+		Instances:    []types.NamespacedName{{Namespace: "default", Name: "kote"}},
+		TemplatePath: filepath.Join("..", "..", "fw", "fw-template.rule"),
+		RulePath:     rulePath,
+		HistoryDir:   filepath.Join(dir, "history"),
+	}, applier
+}
+
+// trustZones returns the trust and untrust zones of a FwLet.
+func trustZones(trust []string, untrust ...string) []samplecontrollerv2.Zone {
+	return []samplecontrollerv2.Zone{
+		{Name: samplecontrollerv2.ZoneTrust, Interfaces: trust},
+		{Name: samplecontrollerv2.ZoneUntrust, Interfaces: untrust},
+	}
+}
+
+func TestFwLetReconcileApplies(t *testing.T) {
+	fwl := &samplecontrollerv2.FwLet{
+		ObjectMeta: metav1.ObjectMeta{Name: "kote", Namespace: "default"},
+		Spec: samplecontrollerv2.FwLetSpec{
+			Zones:               trustZones([]string{"eth-a", "eth-b"}, "vsix-bb", "vsix-ix"),
+			ManagementAddresses: []string{"203.178.128.0/17", "2001:db8:10:10::/64"},
+		},
+	}
+	r, applier := newTestFwLetReconciler(t, fwl)
+
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "kote", Namespace: "default"}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	ruleset, _ := applier.Dump(ctx, "vSIX")
+	for _, want := range []string{
+		`oifname "eth-a" jump ZONE_TRUST;`,
+		`oifname "eth-b" jump ZONE_TRUST;`,
+		`oifname "vsix-bb" jump ZONE_UNTRUST;`,
+		`oifname "vsix-ix" jump ZONE_UNTRUST;`,
+		`ip saddr @mgmt_v4 accept;`,
+		`ip6 saddr @mgmt_v6 accept;`,
+		`elements = { 203.178.128.0/17 }`,
+		`elements = { 2001:db8:10:10::/64 }`,
+	} {
+		if !strings.Contains(ruleset, want) {
+			t.Errorf("applied ruleset does not contain %q", want)
+		}
+	}
+
+	got := samplecontrollerv2.FwLet{}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	if untrust := got.Status.Interfaces(samplecontrollerv2.ZoneUntrust); len(untrust) != 2 || untrust[0] != "vsix-bb" ||
+		len(got.Status.Interfaces(samplecontrollerv2.ZoneTrust)) != 2 {
+		t.Errorf("unexpected status %+v", got.Status)
+	}
+	if !reflect.DeepEqual(got.Status.ManagementAddresses, fwl.Spec.ManagementAddresses) {
+		t.Errorf("Status.ManagementAddresses = %v, want %v", got.Status.ManagementAddresses, fwl.Spec.ManagementAddresses)
+	}
+	if !meta.IsStatusConditionTrue(got.Status.Conditions, samplecontrollerv2.ConditionReady) {
+		t.Errorf("FwLet not Ready: %+v", got.Status.Conditions)
+	}
+	if got.Status.RulesetHash == "" || got.Status.LastAppliedTime == nil {
+		t.Errorf("ruleset hash or apply time missing: %+v", got.Status)
+	}
+}
+
+func TestFwLetReconcileIgnoresOtherRegions(t *testing.T) {
+	fwl := &samplecontrollerv2.FwLet{
+		ObjectMeta: metav1.ObjectMeta{Name: "kote", Namespace: "default"},
+		Spec:       samplecontrollerv2.FwLetSpec{Zones: trustZones([]string{"eth-a"}, "vsix-bb")},
+	}
+	r, applier := newTestFwLetReconciler(t, fwl)
+	r.Instances = []types.NamespacedName{{Namespace: "default", Name: "note"}}
+
+	key := types.NamespacedName{Name: "kote", Namespace: "default"}
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if len(applier.Applied) != 0 {
+		t.Errorf("applied %v for a FwLet of another region", applier.Applied)
+	}
+}
+
+func TestFwLetReconcileRejectsInvalidRuleset(t *testing.T) {
+	fwl := &samplecontrollerv2.FwLet{
+		ObjectMeta: metav1.ObjectMeta{Name: "kote", Namespace: "default"},
+		Spec:       samplecontrollerv2.FwLetSpec{Zones: trustZones([]string{"eth a"}, "vsix-bb")},
+	}
+	r, applier := newTestFwLetReconciler(t, fwl)
+	applier.CheckErr = fmt.Errorf("syntax error, unexpected string")
+
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "kote", Namespace: "default"}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err == nil {
+		t.Fatal("Reconcile() succeeded with a rejected ruleset")
+	}
+	if len(applier.Applied) != 0 {
+		t.Errorf("applied %v although the check failed", applier.Applied)
+	}
+	if content, _ := os.ReadFile(r.RulePath); len(content) != 0 {
+		t.Errorf("rule file was overwritten: %q", content)
+	}
+
+	got := samplecontrollerv2.FwLet{}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	applied := meta.FindStatusCondition(got.Status.Conditions, samplecontrollerv2.ConditionApplied)
+	if applied == nil || applied.Status != metav1.ConditionFalse || applied.Reason != ReasonCheckFailed ||
+		!strings.Contains(applied.Message, "syntax error") {
+		t.Errorf("unexpected Applied condition %+v", applied)
+	}
+	if meta.IsStatusConditionTrue(got.Status.Conditions, samplecontrollerv2.ConditionDegraded) {
+		t.Errorf("Degraded although the node was not touched")
+	}
+}
+
+type failingProber struct{}
+
+func (failingProber) Probe(ctx context.Context, netns string) error {
+	return fmt.Errorf("connection refused")
+}
+
+func TestFwLetReconcileRollsBackUnconfirmedRuleset(t *testing.T) {
+	fwl := &samplecontrollerv2.FwLet{
+		ObjectMeta: metav1.ObjectMeta{Name: "kote", Namespace: "default"},
+		Spec:       samplecontrollerv2.FwLetSpec{Zones: trustZones([]string{"eth-a"}, "vsix-bb")},
+	}
+	r, applier := newTestFwLetReconciler(t, fwl)
+
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "kote", Namespace: "default"}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	good, _ := applier.Dump(ctx, "vSIX")
+
+	got := samplecontrollerv2.FwLet{}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.LastAppliedRevision != 1 {
+		t.Fatalf("Status.LastAppliedRevision = %d, want 1", got.Status.LastAppliedRevision)
+	}
+
+	got.Spec.Zones[0].Interfaces = []string{"eth-b"}
+	if err := r.Update(ctx, &got); err != nil {
+		t.Fatal(err)
+	}
+	r.Prober = failingProber{}
+	r.ConfirmWindow = 10 * time.Millisecond
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err == nil {
+		t.Fatal("Reconcile() succeeded although the probe failed")
+	}
+
+	if live, _ := applier.Dump(ctx, "vSIX"); live != good {
+		t.Errorf("live ruleset was not rolled back")
+	}
+	if content, _ := os.ReadFile(r.RulePath); string(content) != good {
+		t.Errorf("rule file was not rolled back")
+	}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.LastAppliedRevision != 1 {
+		t.Errorf("Status.LastAppliedRevision = %d, want 1", got.Status.LastAppliedRevision)
+	}
+	applied := meta.FindStatusCondition(got.Status.Conditions, samplecontrollerv2.ConditionApplied)
+	if applied == nil || applied.Reason != ReasonRolledBack || !strings.Contains(applied.Message, "rolled back to revision 1") {
+		t.Errorf("unexpected Applied condition %+v", applied)
+	}
+	if !meta.IsStatusConditionTrue(got.Status.Conditions, samplecontrollerv2.ConditionDegraded) ||
+		meta.IsStatusConditionTrue(got.Status.Conditions, samplecontrollerv2.ConditionReady) {
+		t.Errorf("unexpected conditions %+v", got.Status.Conditions)
+	}
+}
+
+func TestFwLetReconcileFallsBackToBaselineOnFirstApply(t *testing.T) {
+	fwl := &samplecontrollerv2.FwLet{
+		ObjectMeta: metav1.ObjectMeta{Name: "kote", Namespace: "default"},
+		Spec:       samplecontrollerv2.FwLetSpec{Zones: trustZones([]string{"eth-a"}, "vsix-bb")},
+	}
+	r, applier := newTestFwLetReconciler(t, fwl)
+	r.Prober = failingProber{}
+	r.ConfirmWindow = 10 * time.Millisecond
+
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "kote", Namespace: "default"}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err == nil {
+		t.Fatal("Reconcile() succeeded although the probe failed")
+	}
+	if live, _ := applier.Dump(ctx, "vSIX"); live != "flush ruleset\n" {
+		t.Errorf("live ruleset = %q, want the fail-open baseline", live)
+	}
+	got := samplecontrollerv2.FwLet{}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	applied := meta.FindStatusCondition(got.Status.Conditions, samplecontrollerv2.ConditionApplied)
+	if applied == nil || applied.Reason != ReasonRolledBack || !strings.Contains(applied.Message, "applied the baseline") {
+		t.Errorf("unexpected Applied condition %+v", applied)
+	}
+	if got.Status.FailedRulesetHash == "" {
+		t.Error("Status.FailedRulesetHash not set")
+	}
+
+	// The same ruleset is not tried again.
+	n := len(applier.Applied)
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if len(applier.Applied) != n {
+		t.Errorf("rolled back ruleset applied again: %v", applier.Applied[n:])
+	}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	if applied := meta.FindStatusCondition(got.Status.Conditions, samplecontrollerv2.ConditionApplied); applied == nil || applied.Reason != ReasonRolledBack {
+		t.Errorf("unexpected Applied condition %+v", applied)
+	}
+
+	// Another spec is.
+	got.Spec.Zones[0].Interfaces = []string{"eth-b"}
+	if err := r.Update(ctx, &got); err != nil {
+		t.Fatal(err)
+	}
+	r.Prober = nil
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !meta.IsStatusConditionTrue(got.Status.Conditions, samplecontrollerv2.ConditionReady) || got.Status.FailedRulesetHash != "" {
+		t.Errorf("unexpected status %+v", got.Status)
+	}
+}
+
+func TestFwLetReconcileAppliesBaselineOnDeletion(t *testing.T) {
+	for _, tt := range []struct {
+		baseline  *samplecontrollerv2.BaselineSpec
+		want      string
+		wantEvent string
+	}{
+		{nil, "flush ruleset\n", "Applied FailOpen baseline to netns vSIX"},
+		{&samplecontrollerv2.BaselineSpec{Mode: samplecontrollerv2.BaselineFailClosed}, "policy drop", "Applied FailClosed baseline to netns vSIX"},
+		{&samplecontrollerv2.BaselineSpec{Mode: samplecontrollerv2.BaselineTemplate, Template: "maintenance.rule"}, "maintenance", "Applied Template maintenance.rule baseline to netns vSIX"},
+	} {
+		fwl := &samplecontrollerv2.FwLet{
+			ObjectMeta: metav1.ObjectMeta{Name: "kote", Namespace: "default"},
+			Spec:       samplecontrollerv2.FwLetSpec{Zones: trustZones([]string{"eth-a"}, "vsix-bb"), Baseline: tt.baseline},
+		}
+		r, applier := newTestFwLetReconciler(t, fwl)
+		recorder := record.NewFakeRecorder(10)
+		r.Recorder = recorder
+		r.BaselineDir = t.TempDir()
+		if err := os.WriteFile(filepath.Join(r.BaselineDir, "maintenance.rule"), []byte("# maintenance\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx := context.Background()
+		key := types.NamespacedName{Name: "kote", Namespace: "default"}
+		if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		if err := r.Get(ctx, key, fwl); err != nil {
+			t.Fatal(err)
+		}
+		if !controllerutil.ContainsFinalizer(fwl, fwLetFinalizer) {
+			t.Fatalf("finalizer not added")
+		}
+		if err := r.Delete(ctx, fwl); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+
+		if live, _ := applier.Dump(ctx, "vSIX"); !strings.Contains(live, tt.want) {
+			t.Errorf("live ruleset after deletion = %q, want %q", live, tt.want)
+		}
+		if _, err := os.Stat(r.RulePath); !os.IsNotExist(err) {
+			t.Errorf("rule file still present after deletion")
+		}
+		if err := r.Get(ctx, key, fwl); !errors.IsNotFound(err) {
+			t.Errorf("FwLet not released after baseline: %v", err)
+		}
+		select {
+		case e := <-recorder.Events:
+			if !strings.Contains(e, "BaselineApplied "+tt.wantEvent) {
+				t.Errorf("unexpected event %q", e)
+			}
+		default:
+			t.Errorf("no event recorded")
+		}
+	}
+}
+
+func TestFwLetReconcileTearsDownMovedFwLet(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		// move relabels fwl for node2 as seen by r.
+		move func(t *testing.T, r *FwLetReconciler, fwl *samplecontrollerv2.FwLet)
+		want string
+	}{
+		{"still cached", func(t *testing.T, r *FwLetReconciler, fwl *samplecontrollerv2.FwLet) {
+			fwl.Labels[samplecontrollerv2.NodeLabel] = "node2"
+			if err := r.Update(context.Background(), fwl); err != nil {
+				t.Fatal(err)
+			}
+		}, "policy drop"},
+		{"left the cache", func(t *testing.T, r *FwLetReconciler, fwl *samplecontrollerv2.FwLet) {
+			// A cache filtered by label no longer has it, the API server
+			// still does.
+			r.APIReader = r.Client
+			r.Client = fake.NewClientBuilder().WithScheme(r.Scheme).Build()
+		}, "policy drop"},
+		{"gone", func(t *testing.T, r *FwLetReconciler, fwl *samplecontrollerv2.FwLet) {
+			r.Client = fake.NewClientBuilder().WithScheme(r.Scheme).Build()
+		}, "flush ruleset\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fwl := &samplecontrollerv2.FwLet{
+				ObjectMeta: metav1.ObjectMeta{Name: "kote", Namespace: "default", Labels: map[string]string{samplecontrollerv2.NodeLabel: "node1"}},
+				Spec: samplecontrollerv2.FwLetSpec{
+					Zones:    trustZones([]string{"eth-a"}, "vsix-bb"),
+					Baseline: &samplecontrollerv2.BaselineSpec{Mode: samplecontrollerv2.BaselineFailClosed},
+				},
+			}
+			r, applier := newTestFwLetReconciler(t, fwl)
+			r.Instances = nil
+			r.Selector = labels.SelectorFromSet(labels.Set{samplecontrollerv2.NodeLabel: "node1"})
+			r.StateDir = filepath.Join(t.TempDir(), "state")
+			recorder := record.NewFakeRecorder(10)
+			r.Recorder = recorder
+
+			ctx := context.Background()
+			key := types.NamespacedName{Name: "kote", Namespace: "default"}
+			if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			if err := r.Get(ctx, key, fwl); err != nil {
+				t.Fatal(err)
+			}
+
+			// The agent restarts after the move.
+			q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+			defer q.ShutDown()
+			if err := r.recordedFwLets(ctx, nil, q); err != nil {
+				t.Fatal(err)
+			}
+			if q.Len() != 1 {
+				t.Fatalf("recorded FwLets = %d, want 1", q.Len())
+			}
+			tt.move(t, r, fwl)
+			if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+
+			if live, _ := applier.Dump(ctx, "vSIX"); !strings.Contains(live, tt.want) {
+				t.Errorf("live ruleset after move = %q, want %q", live, tt.want)
+			}
+			if _, err := os.Stat(r.stateDir(fwl)); !os.IsNotExist(err) {
+				t.Errorf("state dir still present after move")
+			}
+			applied := len(applier.Applied)
+			if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			if len(applier.Applied) != applied {
+				t.Errorf("applied %v after teardown", applier.Applied[applied:])
+			}
+		})
+	}
+}
+
+func TestFwLetReconcileDetectsDrift(t *testing.T) {
+	for _, policy := range []samplecontrollerv2.DriftPolicy{"", samplecontrollerv2.DriftPolicyReport} {
+		fwl := &samplecontrollerv2.FwLet{
+			ObjectMeta: metav1.ObjectMeta{Name: "kote", Namespace: "default"},
+			Spec:       samplecontrollerv2.FwLetSpec{Zones: trustZones([]string{"eth-a"}, "vsix-bb"), DriftPolicy: policy},
+		}
+		r, applier := newTestFwLetReconciler(t, fwl)
+		r.ResyncPeriod = time.Minute
+
+		ctx := context.Background()
+		key := types.NamespacedName{Name: "kote", Namespace: "default"}
+		res, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		if err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		if res.RequeueAfter != time.Minute {
+			t.Errorf("RequeueAfter = %v, want %v", res.RequeueAfter, time.Minute)
+		}
+		applied, _ := applier.Dump(ctx, "vSIX")
+
+		// Someone edits the ruleset on the node.
+		applier.Rulesets["vSIX"] = "flush ruleset\n"
+		if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+
+		got := samplecontrollerv2.FwLet{}
+		if err := r.Get(ctx, key, &got); err != nil {
+			t.Fatal(err)
+		}
+		live, _ := applier.Dump(ctx, "vSIX")
+		drift := meta.FindStatusCondition(got.Status.Conditions, samplecontrollerv2.ConditionDriftDetected)
+		if policy == samplecontrollerv2.DriftPolicyReport {
+			if live == applied {
+				t.Errorf("Report policy reapplied the ruleset")
+			}
+			if drift == nil || drift.Status != metav1.ConditionTrue {
+				t.Errorf("unexpected DriftDetected condition %+v", drift)
+			}
+			if meta.IsStatusConditionTrue(got.Status.Conditions, samplecontrollerv2.ConditionReady) {
+				t.Errorf("Ready while drifted")
+			}
+		} else {
+			if live != applied {
+				t.Errorf("drifted ruleset was not reapplied: %q", live)
+			}
+			if drift == nil || drift.Status != metav1.ConditionFalse || drift.Reason != ReasonDriftCorrected {
+				t.Errorf("unexpected DriftDetected condition %+v", drift)
+			}
+		}
+	}
+}
+
+func TestFwLetReconcileDoesNotAdoptUnknownRuleset(t *testing.T) {
+	for _, policy := range []samplecontrollerv2.DriftPolicy{"", samplecontrollerv2.DriftPolicyReport} {
+		fwl := &samplecontrollerv2.FwLet{
+			ObjectMeta: metav1.ObjectMeta{Name: "kote", Namespace: "default"},
+			Spec:       samplecontrollerv2.FwLetSpec{Zones: trustZones([]string{"eth-a"}, "vsix-bb"), DriftPolicy: policy},
+		}
+		r, applier := newTestFwLetReconciler(t, fwl)
+
+		ctx := context.Background()
+		key := types.NamespacedName{Name: "kote", Namespace: "default"}
+		if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		applied, _ := applier.Dump(ctx, "vSIX")
+
+		// The dump taken after the apply is lost, and the ruleset on the
+		// node is edited.
+		got := samplecontrollerv2.FwLet{}
+		if err := r.Get(ctx, key, &got); err != nil {
+			t.Fatal(err)
+		}
+		got.Status.LiveRulesetHash = ""
+		if err := r.Status().Update(ctx, &got); err != nil {
+			t.Fatal(err)
+		}
+		applier.Rulesets["vSIX"] = "flush ruleset\n"
+		if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+
+		if err := r.Get(ctx, key, &got); err != nil {
+			t.Fatal(err)
+		}
+		live, _ := applier.Dump(ctx, "vSIX")
+		drift := meta.FindStatusCondition(got.Status.Conditions, samplecontrollerv2.ConditionDriftDetected)
+		if policy == samplecontrollerv2.DriftPolicyReport {
+			if drift == nil || drift.Status != metav1.ConditionTrue {
+				t.Errorf("unexpected DriftDetected condition %+v", drift)
+			}
+			if got.Status.LiveRulesetHash != "" {
+				t.Errorf("adopted the live ruleset %q", live)
+			}
+		} else {
+			if live != applied {
+				t.Errorf("unknown ruleset was not replaced: %q", live)
+			}
+			if drift == nil || drift.Status != metav1.ConditionFalse || drift.Reason != ReasonDriftCorrected {
+				t.Errorf("unexpected DriftDetected condition %+v", drift)
+			}
+		}
+	}
+}
+
+func TestFwLetReconcileRendersConfigMapTemplate(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "fw-template", Namespace: "default"},
+		Data: map[string]string{
+			"custom.rule": "table inet filter {\n\tchain FORWARD {\n{{- range .TrustIf}}\n\t\toifname {{quote .}} jump ZONE_TRUST;\n{{- end}}\n\t}\n}\n",
+		},
+	}
+	fwl := &samplecontrollerv2.FwLet{
+		ObjectMeta: metav1.ObjectMeta{Name: "kote", Namespace: "default"},
+		Spec: samplecontrollerv2.FwLetSpec{
+			Zones:       []samplecontrollerv2.Zone{{Name: samplecontrollerv2.ZoneTrust, Interfaces: []string{"eth-a"}}},
+			TemplateRef: &samplecontrollerv2.TemplateRef{Name: "fw-template", Key: "custom.rule"},
+		},
+	}
+	r, applier := newTestFwLetReconciler(t, fwl, cm)
+
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "kote", Namespace: "default"}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if ruleset, _ := applier.Dump(ctx, "vSIX"); !strings.Contains(ruleset, `oifname "eth-a" jump ZONE_TRUST;`) || strings.Contains(ruleset, "PAIR_") {
+		t.Errorf("ruleset not rendered from the ConfigMap:\n%s", ruleset)
+	}
+	got := samplecontrollerv2.FwLet{}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(got.Status.TemplateRevision, "fw-template/custom.rule@") || got.Status.TemplateHash == "" {
+		t.Errorf("unexpected template revision %q, hash %q", got.Status.TemplateRevision, got.Status.TemplateHash)
+	}
+
+	// A template change re-renders the ruleset of the referencing FwLets.
+	if err := r.Get(ctx, types.NamespacedName{Name: "fw-template", Namespace: "default"}, cm); err != nil {
+		t.Fatal(err)
+	}
+	cm.Data["custom.rule"] = strings.Replace(cm.Data["custom.rule"], "jump ZONE_TRUST", "accept", 1)
+	if err := r.Update(ctx, cm); err != nil {
+		t.Fatal(err)
+	}
+	reqs := r.fwLetsForConfigMap(ctx, cm)
+	if len(reqs) != 1 || reqs[0].NamespacedName != key {
+		t.Fatalf("fwLetsForConfigMap() = %v, want %v", reqs, key)
+	}
+	if _, err := r.Reconcile(ctx, reqs[0]); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if ruleset, _ := applier.Dump(ctx, "vSIX"); !strings.Contains(ruleset, `oifname "eth-a" accept;`) {
+		t.Errorf("ruleset not re-rendered after the template changed:\n%s", ruleset)
+	}
+	rev := got.Status.TemplateRevision
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.TemplateRevision == rev || got.Status.LastAppliedRevision != 2 {
+		t.Errorf("template revision %q, ruleset revision %d after the template changed", got.Status.TemplateRevision, got.Status.LastAppliedRevision)
+	}
+
+	// A missing ConfigMap fails rendering without touching the node.
+	if err := r.Delete(ctx, cm); err != nil {
+		t.Fatal(err)
+	}
+	applied := len(applier.Applied)
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err == nil {
+		t.Fatal("Reconcile() succeeded without a template")
+	}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	if c := meta.FindStatusCondition(got.Status.Conditions, samplecontrollerv2.ConditionApplied); c == nil || c.Reason != ReasonRenderFailed {
+		t.Errorf("Applied condition = %+v, want reason %s", c, ReasonRenderFailed)
+	}
+	if len(applier.Applied) != applied {
+		t.Errorf("applied a ruleset without a template")
+	}
+}
+
+func TestFwLetReconcileRestrictsTemplateNamespaces(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "fw-template", Namespace: "default"},
+		Data:       map[string]string{samplecontrollerv2.DefaultTemplateKey: "flush ruleset\n"},
+	}
+	fwl := &samplecontrollerv2.FwLet{
+		ObjectMeta: metav1.ObjectMeta{Name: "kote", Namespace: "default"},
+		Spec: samplecontrollerv2.FwLetSpec{
+			Zones:       trustZones([]string{"eth-a"}, "vsix-bb"),
+			TemplateRef: &samplecontrollerv2.TemplateRef{Name: "fw-template"},
+		},
+	}
+	r, applier := newTestFwLetReconciler(t, fwl, cm)
+	r.TemplateNamespaces = []string{"fw-controller-system"}
+
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "kote", Namespace: "default"}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err == nil {
+		t.Fatalf("Reconcile() with a template outside the template namespaces succeeded")
+	}
+	got := samplecontrollerv2.FwLet{}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	if cond := meta.FindStatusCondition(got.Status.Conditions, samplecontrollerv2.ConditionApplied); cond == nil || cond.Reason != ReasonRenderFailed {
+		t.Errorf("Applied condition = %+v", cond)
+	}
+	if len(applier.Applied) != 0 {
+		t.Errorf("applied %v", applier.Applied)
+	}
+}
+
+func TestFwLetReconcileRendersRules(t *testing.T) {
+	fwl := &samplecontrollerv2.FwLet{
+		ObjectMeta: metav1.ObjectMeta{Name: "kote", Namespace: "default"},
+		Spec: samplecontrollerv2.FwLetSpec{
+			Zones: trustZones([]string{"eth-a"}, "vsix-bb"),
+			Rules: []samplecontrollerv2.PolicyRule{{
+				Name:     "ssh",
+				From:     samplecontrollerv2.ZoneUntrust,
+				To:       samplecontrollerv2.ZoneTrust,
+				Protocol: "tcp",
+				Ports:    []samplecontrollerv2.PortRange{"22"},
+				Action:   samplecontrollerv2.RuleActionAccept,
+			}},
+		},
+	}
+	r, applier := newTestFwLetReconciler(t, fwl)
+
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "kote", Namespace: "default"}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if ruleset, _ := applier.Dump(ctx, "vSIX"); !strings.Contains(ruleset, `tcp dport 22 accept comment "ssh";`) {
+		t.Errorf("applied ruleset does not contain the ssh rule:\n%s", ruleset)
+	}
+
+	// Changing only the rules re-renders the ruleset.
+	got := samplecontrollerv2.FwLet{}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	got.Spec.Rules[0].Ports = append(got.Spec.Rules[0].Ports, "2222")
+	if err := r.Update(ctx, &got); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if ruleset, _ := applier.Dump(ctx, "vSIX"); !strings.Contains(ruleset, `tcp dport { 22, 2222 } accept comment "ssh";`) {
+		t.Errorf("ruleset not re-rendered after the rules changed:\n%s", ruleset)
+	}
+	applied := len(applier.Applied)
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if len(applier.Applied) != applied {
+		t.Errorf("re-applied an unchanged ruleset")
+	}
+}
+
+func TestFwLetReconcileUpdatesSetElements(t *testing.T) {
+	fwl := &samplecontrollerv2.FwLet{
+		ObjectMeta: metav1.ObjectMeta{Name: "kote", Namespace: "default"},
+		Spec: samplecontrollerv2.FwLetSpec{
+			Zones:               trustZones([]string{"eth-a"}, "vsix-bb"),
+			ManagementAddresses: []string{"2001:db8:10:10::/64", "2001:db8:10:20::/64"},
+		},
+	}
+	r, applier := newTestFwLetReconciler(t, fwl)
+
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "kote", Namespace: "default"}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if len(applier.Applied) != 1 || applier.Applied[0] != r.RulePath {
+		t.Fatalf("first ruleset not loaded from the rule file: %v", applier.Applied)
+	}
+
+	// Changing only the management addresses updates the set in place.
+	got := samplecontrollerv2.FwLet{}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	got.Spec.ManagementAddresses = []string{"2001:db8:10:20::/64", "192.0.2.0/24"}
+	if err := r.Update(ctx, &got); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if len(applier.Applied) != 2 || applier.Applied[1] == r.RulePath {
+		t.Fatalf("set elements not updated incrementally: %v", applier.Applied)
+	}
+	ruleset, _ := applier.Dump(ctx, "vSIX")
+	for _, want := range []string{
+		"delete element inet filter mgmt_v6 { 2001:db8:10:10::/64 }",
+		"add element inet filter mgmt_v4 { 192.0.2.0/24 }",
+	} {
+		if !strings.Contains(ruleset, want) {
+			t.Errorf("loaded ruleset does not contain %q:\n%s", want, ruleset)
+		}
+	}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"192.0.2.0/24", "2001:db8:10:20::/64"}; !reflect.DeepEqual(got.Status.ManagementAddresses, want) {
+		t.Errorf("Status.ManagementAddresses = %v, want %v", got.Status.ManagementAddresses, want)
+	}
+	if got.Status.LastAppliedRevision != 2 {
+		t.Errorf("Status.LastAppliedRevision = %d, want 2", got.Status.LastAppliedRevision)
+	}
+}
+
+func TestFwLetReconcileResolvesServiceGroups(t *testing.T) {
+	fwl := &samplecontrollerv2.FwLet{
+		ObjectMeta: metav1.ObjectMeta{Name: "kote", Namespace: "default"},
+		Spec: samplecontrollerv2.FwLetSpec{
+			Zones: trustZones([]string{"eth-a"}, "vsix-bb"),
+			Rules: []samplecontrollerv2.PolicyRule{{
+				Name:          "mgmt",
+				From:          samplecontrollerv2.ZoneUntrust,
+				To:            samplecontrollerv2.ZoneTrust,
+				ServiceGroups: []string{"ssh", "snmp"},
+				Action:        samplecontrollerv2.RuleActionAccept,
+			}},
+		},
+	}
+	ssh := &samplecontrollerv2.ServiceGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "ssh", Namespace: "default"},
+		Spec: samplecontrollerv2.ServiceGroupSpec{Services: []samplecontrollerv2.Service{
+			{Protocol: "tcp", Ports: []samplecontrollerv2.PortRange{"22"}},
+		}},
+	}
+	r, applier := newTestFwLetReconciler(t, fwl, ssh)
+
+	// A missing group keeps the node untouched.
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "kote", Namespace: "default"}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err == nil {
+		t.Fatalf("Reconcile() with a missing ServiceGroup succeeded")
+	}
+	got := samplecontrollerv2.FwLet{}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	if cond := meta.FindStatusCondition(got.Status.Conditions, samplecontrollerv2.ConditionApplied); cond == nil ||
+		cond.Reason != ReasonRenderFailed || !strings.Contains(cond.Message, "ServiceGroup snmp not found") {
+		t.Errorf("Applied condition = %+v", cond)
+	}
+	if len(applier.Applied) != 0 {
+		t.Errorf("applied a ruleset without the missing group")
+	}
+
+	snmp := &samplecontrollerv2.ServiceGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "snmp", Namespace: "default"},
+		Spec: samplecontrollerv2.ServiceGroupSpec{Services: []samplecontrollerv2.Service{
+			{Protocol: "udp", Ports: []samplecontrollerv2.PortRange{"161", "162"}},
+		}},
+	}
+	if err := r.Create(ctx, snmp); err != nil {
+		t.Fatal(err)
+	}
+	if reqs := r.fwLetsForServiceGroup(ctx, snmp); len(reqs) != 1 || reqs[0].NamespacedName != key {
+		t.Errorf("fwLetsForServiceGroup() = %v, want %v", reqs, key)
+	}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	ruleset, _ := applier.Dump(ctx, "vSIX")
+	for _, want := range []string{
+		`tcp dport 22 accept comment "mgmt";`,
+		`udp dport { 161, 162 } accept comment "mgmt";`,
+		"set svc_snmp_udp {",
+	} {
+		if !strings.Contains(ruleset, want) {
+			t.Errorf("applied ruleset does not contain %q:\n%s", want, ruleset)
+		}
+	}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []samplecontrollerv2.ResolvedServiceGroup{
+		{Name: "ssh", Services: ssh.Spec.Services},
+		{Name: "snmp", Services: snmp.Spec.Services},
+	}
+	if !reflect.DeepEqual(got.Status.ServiceGroups, want) {
+		t.Errorf("Status.ServiceGroups = %+v, want %+v", got.Status.ServiceGroups, want)
+	}
+}
+
+type fakeContainerRuntime map[string]string
+
+func (f fakeContainerRuntime) NetnsPath(ctx context.Context, container string) (string, error) {
+	path, ok := f[container]
+	if !ok {
+		return "", fmt.Errorf("No such container: %s", container)
+	}
+	return path, nil
+}
+
+func TestFwLetReconcileEnforcesInSpecNetns(t *testing.T) {
+	fwl := &samplecontrollerv2.FwLet{
+		ObjectMeta: metav1.ObjectMeta{Name: "kote", Namespace: "default"},
+		Spec: samplecontrollerv2.FwLetSpec{
+			Zones: trustZones([]string{"eth-a"}, "vsix-bb"),
+			Netns: &samplecontrollerv2.NetworkNamespace{Path: "/proc/self/ns/net"},
+		},
+	}
+	r, applier := newTestFwLetReconciler(t, fwl)
+	r.ContainerRuntime = fakeContainerRuntime{"fw1": "/proc/thread-self/ns/net"}
+	// The tests run in the namespace of the test process.
+	r.AllowHostNetns = true
+
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "kote", Namespace: "default"}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if ruleset, _ := applier.Dump(ctx, "/proc/self/ns/net"); !strings.Contains(ruleset, `oifname "eth-a" jump ZONE_TRUST;`) {
+		t.Errorf("ruleset not applied to the namespace of the spec:\n%s", ruleset)
+	}
+	if ruleset, _ := applier.Dump(ctx, "vSIX"); ruleset != "" {
+		t.Errorf("applied to the default namespace:\n%s", ruleset)
+	}
+	got := samplecontrollerv2.FwLet{}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.Netns != "/proc/self/ns/net" {
+		t.Errorf("Status.Netns = %q", got.Status.Netns)
+	}
+
+	// Moving to a container loads the whole ruleset there, although the
+	// rule file is unchanged, and leaves the baseline behind.
+	got.Spec.Netns = &samplecontrollerv2.NetworkNamespace{Container: "fw1"}
+	if err := r.Update(ctx, &got); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if ruleset, _ := applier.Dump(ctx, "/proc/thread-self/ns/net"); !strings.Contains(ruleset, `oifname "eth-a" jump ZONE_TRUST;`) {
+		t.Errorf("ruleset not applied to the namespace of the container:\n%s", ruleset)
+	}
+	if ruleset, _ := applier.Dump(ctx, "/proc/self/ns/net"); strings.Contains(ruleset, "ZONE_TRUST") {
+		t.Errorf("ruleset left in the previous namespace:\n%s", ruleset)
+	}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.Netns != "/proc/thread-self/ns/net" {
+		t.Errorf("Status.Netns = %q", got.Status.Netns)
+	}
+
+	// A namespace that does not exist keeps the node untouched.
+	applied := len(applier.Applied)
+	got.Spec.Netns = &samplecontrollerv2.NetworkNamespace{Name: "fw-test-missing"}
+	if err := r.Update(ctx, &got); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err == nil {
+		t.Fatalf("Reconcile() with a missing netns succeeded")
+	}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	if cond := meta.FindStatusCondition(got.Status.Conditions, samplecontrollerv2.ConditionApplied); cond == nil || cond.Reason != ReasonNetnsUnavailable {
+		t.Errorf("Applied condition = %+v", cond)
+	}
+	if len(applier.Applied) != applied {
+		t.Errorf("applied %v to a missing netns", applier.Applied[applied:])
+	}
+}
+
+func TestFwLetReconcileRefusesNonNetns(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "net")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		path string
+	}{
+		// Without --allow-host-netns the agent's own namespace is refused.
+		{"own netns", "/proc/self/ns/net"},
+		{"regular file", file},
+		{"other namespace type", "/proc/self/ns/uts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fwl := &samplecontrollerv2.FwLet{
+				ObjectMeta: metav1.ObjectMeta{Name: "kote", Namespace: "default"},
+				Spec: samplecontrollerv2.FwLetSpec{
+					Zones: trustZones([]string{"eth-a"}, "vsix-bb"),
+					Netns: &samplecontrollerv2.NetworkNamespace{Path: tt.path},
+				},
+			}
+			r, applier := newTestFwLetReconciler(t, fwl)
+
+			ctx := context.Background()
+			key := types.NamespacedName{Name: "kote", Namespace: "default"}
+			if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err == nil {
+				t.Fatalf("Reconcile() in %s succeeded", tt.path)
+			}
+			got := samplecontrollerv2.FwLet{}
+			if err := r.Get(ctx, key, &got); err != nil {
+				t.Fatal(err)
+			}
+			if cond := meta.FindStatusCondition(got.Status.Conditions, samplecontrollerv2.ConditionApplied); cond == nil || cond.Reason != ReasonNetnsUnavailable {
+				t.Errorf("Applied condition = %+v", cond)
+			}
+			if len(applier.Applied) != 0 {
+				t.Errorf("applied %v", applier.Applied)
+			}
+		})
+	}
+}
+
+func TestFwLetReconcileEnforcesBoundInstances(t *testing.T) {
+	fwLet := func(name, node, netns, trust string) *samplecontrollerv2.FwLet {
+		return &samplecontrollerv2.FwLet{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{samplecontrollerv2.NodeLabel: node}},
+			Spec: samplecontrollerv2.FwLetSpec{
+				Zones: trustZones([]string{trust}, "vsix-bb"),
+				Netns: &samplecontrollerv2.NetworkNamespace{Path: netns},
+			},
+		}
+	}
+	kote := fwLet("kote", "node1", "/proc/self/ns/net", "eth-a")
+	note := fwLet("note", "node1", "/proc/thread-self/ns/net", "eth-b")
+	other := fwLet("other", "node2", "/proc/self/ns/net", "eth-c")
+	r, applier := newTestFwLetReconciler(t, kote, note, other)
+	r.AllowHostNetns = true
+	r.Instances = nil
+	r.Selector = labels.SelectorFromSet(labels.Set{samplecontrollerv2.NodeLabel: "node1"})
+	r.StateDir = filepath.Join(t.TempDir(), "state")
+
+	ctx := context.Background()
+	for _, fwl := range []*samplecontrollerv2.FwLet{kote, note, other} {
+		if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(fwl)}); err != nil {
+			t.Fatalf("Reconcile(%s) error = %v", fwl.Name, err)
+		}
+	}
+	for _, tt := range []struct {
+		netns, want, notWant string
+	}{
+		{"/proc/self/ns/net", `oifname "eth-a"`, `oifname "eth-c"`},
+		{"/proc/thread-self/ns/net", `oifname "eth-b"`, `oifname "eth-a"`},
+	} {
+		ruleset, _ := applier.Dump(ctx, tt.netns)
+		if !strings.Contains(ruleset, tt.want) || strings.Contains(ruleset, tt.notWant) {
+			t.Errorf("ruleset of %s:\n%s", tt.netns, ruleset)
+		}
+	}
+	for _, fwl := range []*samplecontrollerv2.FwLet{kote, note} {
+		content, err := os.ReadFile(filepath.Join(r.StateDir, "default", fwl.Name, "fw.rule"))
+		if err != nil || !strings.Contains(string(content), fwl.Spec.Zones[0].Interfaces[0]) {
+			t.Errorf("rule file of %s = %q, %v", fwl.Name, content, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(r.StateDir, "default", "other")); !os.IsNotExist(err) {
+		t.Errorf("state dir of an unbound FwLet exists: %v", err)
+	}
+
+	// Deleting an instance tears down its namespace and state only.
+	got := samplecontrollerv2.FwLet{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(note), &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Delete(ctx, &got); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(note)}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if ruleset, _ := applier.Dump(ctx, "/proc/thread-self/ns/net"); strings.Contains(ruleset, `oifname "eth-b"`) {
+		t.Errorf("ruleset left after deletion:\n%s", ruleset)
+	}
+	if ruleset, _ := applier.Dump(ctx, "/proc/self/ns/net"); !strings.Contains(ruleset, `oifname "eth-a"`) {
+		t.Errorf("deletion touched another instance:\n%s", ruleset)
+	}
+	if _, err := os.Stat(filepath.Join(r.StateDir, "default", "note")); !os.IsNotExist(err) {
+		t.Errorf("state dir left after deletion: %v", err)
+	}
+}
+
+func TestFwLetReconcileRefusesSharedNetns(t *testing.T) {
+	fwLet := func(namespace, name, trust string) *samplecontrollerv2.FwLet {
+		return &samplecontrollerv2.FwLet{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       samplecontrollerv2.FwLetSpec{Zones: trustZones([]string{trust}, "vsix-bb")},
+		}
+	}
+	kote := fwLet("default", "kote", "eth-a")
+	note := fwLet("default", "note", "eth-b")
+	// Binding is by namespace and name.
+	otherKote := fwLet("other", "kote", "eth-c")
+	r, applier := newTestFwLetReconciler(t, kote, note, otherKote)
+	r.Instances = append(r.Instances, types.NamespacedName{Namespace: "default", Name: "note"})
+	r.StateDir = filepath.Join(t.TempDir(), "state")
+
+	ctx := context.Background()
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(kote)}); err != nil {
+		t.Fatalf("Reconcile(kote) error = %v", err)
+	}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(otherKote)}); err != nil {
+		t.Fatalf("Reconcile(other/kote) error = %v", err)
+	}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(note)}); err == nil {
+		t.Fatal("Reconcile(note) succeeded in the netns of kote")
+	}
+	if ruleset, _ := applier.Dump(ctx, "vSIX"); !strings.Contains(ruleset, `oifname "eth-a"`) ||
+		strings.Contains(ruleset, `oifname "eth-b"`) || strings.Contains(ruleset, `oifname "eth-c"`) {
+		t.Errorf("ruleset of vSIX:\n%s", ruleset)
+	}
+	got := samplecontrollerv2.FwLet{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(note), &got); err != nil {
+		t.Fatal(err)
+	}
+	if cond := meta.FindStatusCondition(got.Status.Conditions, samplecontrollerv2.ConditionDegraded); cond == nil ||
+		cond.Status != metav1.ConditionTrue || cond.Reason != ReasonNetnsConflict {
+		t.Errorf("Degraded condition = %+v, want True with reason %s", cond, ReasonNetnsConflict)
+	}
+
+	// A restarted agent keeps the namespace to the FwLet enforced in it.
+	r.claims = nil
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(note)}); err == nil {
+		t.Fatal("Reconcile(note) succeeded in the netns of kote after a restart")
+	}
+
+	// Deleting the refused FwLet leaves the namespace alone.
+	if err := r.Delete(ctx, &got); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(note)}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if ruleset, _ := applier.Dump(ctx, "vSIX"); !strings.Contains(ruleset, `oifname "eth-a"`) {
+		t.Errorf("deleting note flushed the netns of kote:\n%s", ruleset)
+	}
+}
+
+func TestAgentHeartbeat(t *testing.T) {
+	r, applier := newTestFwLetReconciler(t,
+		&samplecontrollerv2.FwLet{ObjectMeta: metav1.ObjectMeta{Name: "kote", Namespace: "default"}},
+		&samplecontrollerv2.FwLet{ObjectMeta: metav1.ObjectMeta{Name: "note", Namespace: "default"}},
+	)
+	h := &AgentHeartbeat{
+		Client:   r.Client,
+		Name:     "node1",
+		NodeName: "node1",
+		Version:  "v0.0.1",
+		Backend:  "fake",
+		Applier:  applier,
+		Bound:    r.Bound,
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := h.beat(ctx); err != nil {
+			t.Fatalf("beat() error = %v", err)
+		}
+	}
+	agent := samplecontrollerv2.FwAgent{}
+	if err := r.Get(ctx, types.NamespacedName{Name: "node1"}, &agent); err != nil {
+		t.Fatal(err)
+	}
+	if agent.Spec.NodeName != "node1" {
+		t.Errorf("nodeName = %q, want node1", agent.Spec.NodeName)
+	}
+	if !reflect.DeepEqual(agent.Status.FwLets, []string{"default/kote"}) {
+		t.Errorf("fwlets = %v, want [default/kote]", agent.Status.FwLets)
+	}
+	if agent.Status.NftVersion != "fake" || agent.Status.Version != "v0.0.1" {
+		t.Errorf("versions = %q, %q", agent.Status.NftVersion, agent.Status.Version)
+	}
+	if len(agent.Status.Interfaces) == 0 {
+		t.Error("no interfaces reported")
+	}
+	if agent.Status.HeartbeatPeriod.Duration != samplecontrollerv2.DefaultHeartbeatPeriod {
+		t.Errorf("heartbeatPeriod = %v, want %v", agent.Status.HeartbeatPeriod.Duration, samplecontrollerv2.DefaultHeartbeatPeriod)
+	}
+	if !agent.Status.Alive(time.Now()) {
+		t.Error("agent not alive right after a heartbeat")
+	}
+}
+
+func TestFwLetReconcileReportsMissingInterfaces(t *testing.T) {
+	fwl := &samplecontrollerv2.FwLet{
+		ObjectMeta: metav1.ObjectMeta{Name: "kote", Namespace: "default"},
+		Spec:       samplecontrollerv2.FwLetSpec{Zones: trustZones([]string{"eth-a", "eth-b"}, "vsix-bb")},
+	}
+	r, _ := newTestFwLetReconciler(t, fwl)
+	links := executer.NewFakeLinkMonitor()
+	links.SetLinks("vSIX", executer.Interface{Name: "eth-a", Up: true}, executer.Interface{Name: "lo", Up: true})
+	r.LinkMonitor = links
+	recorder := record.NewFakeRecorder(10)
+	r.Recorder = recorder
+
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "kote", Namespace: "default"}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	got := samplecontrollerv2.FwLet{}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Status.MissingInterfaces, []string{"eth-b", "vsix-bb"}) {
+		t.Errorf("Status.MissingInterfaces = %v, want [eth-b vsix-bb]", got.Status.MissingInterfaces)
+	}
+	if len(got.Status.Links) != 2 || got.Status.Links[0].Name != "eth-a" {
+		t.Errorf("Status.Links = %+v", got.Status.Links)
+	}
+	// The ruleset is applied all the same, the rules just do not match.
+	if !meta.IsStatusConditionTrue(got.Status.Conditions, samplecontrollerv2.ConditionApplied) {
+		t.Errorf("FwLet not Applied: %+v", got.Status.Conditions)
+	}
+	if cond := meta.FindStatusCondition(got.Status.Conditions, samplecontrollerv2.ConditionDegraded); cond == nil ||
+		cond.Status != metav1.ConditionTrue || cond.Reason != ReasonInterfacesMissing {
+		t.Errorf("Degraded condition = %+v, want True with reason %s", cond, ReasonInterfacesMissing)
+	}
+	if meta.IsStatusConditionTrue(got.Status.Conditions, samplecontrollerv2.ConditionReady) {
+		t.Error("FwLet Ready with missing interfaces")
+	}
+	select {
+	case e := <-recorder.Events:
+		if !strings.Contains(e, ReasonInterfacesMissing) || !strings.Contains(e, "eth-b, vsix-bb") {
+			t.Errorf("event = %q", e)
+		}
+	default:
+		t.Error("no event for the missing interfaces")
+	}
+
+	links.SetLinks("vSIX",
+		executer.Interface{Name: "eth-a", Up: true},
+		executer.Interface{Name: "eth-b", Up: true},
+		executer.Interface{Name: "lo", Up: true},
+		executer.Interface{Name: "vsix-bb", Up: true},
+	)
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Status.MissingInterfaces) != 0 || len(got.Status.Links) != 4 {
+		t.Errorf("Status.MissingInterfaces = %v, Status.Links = %+v", got.Status.MissingInterfaces, got.Status.Links)
+	}
+	if !meta.IsStatusConditionTrue(got.Status.Conditions, samplecontrollerv2.ConditionReady) {
+		t.Errorf("FwLet not Ready: %+v", got.Status.Conditions)
+	}
+}
+
+func TestLinkWatcher(t *testing.T) {
+	links := executer.NewFakeLinkMonitor()
+	w := newLinkWatcher(links)
+	defer w.stop()
+
+	// waitWatched waits for the watch of ns to start or end.
+	waitWatched := func(ns string, want int) {
+		t.Helper()
+		if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+			return links.Watched(ns) == want, nil
+		}); err != nil {
+			t.Fatalf("%d watches of %s, want %d", links.Watched(ns), ns, want)
+		}
+	}
+
+	kote := types.NamespacedName{Name: "kote", Namespace: "default"}
+	note := types.NamespacedName{Name: "note", Namespace: "default"}
+	w.watch(kote, "vSIX")
+	w.watch(note, "vSIX")
+	waitWatched("vSIX", 1)
+
+	links.SetLinks("vSIX", executer.Interface{Name: "eth-a"})
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case e := <-w.events:
+			got[e.Object.GetName()] = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event for a link change")
+		}
+	}
+	if !got["kote"] || !got["note"] {
+		t.Errorf("events for %v, want kote and note", got)
+	}
+
+	// The watch ends with the last FwLet enforced in the namespace.
+	w.watch(kote, "other")
+	waitWatched("other", 1)
+	if links.Watched("vSIX") != 1 {
+		t.Errorf("vSIX no longer watched with note in it")
+	}
+	w.forget(note)
+	waitWatched("vSIX", 0)
+}
@@ -18,15 +18,26 @@ package controller
 
 import (
 	"context"
+	"strings"
+	"time"
 
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	controllerutil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
-	samplecontrollerv1 "github.com/Yosshi72/fw-controller/api/v1"
+	samplecontrollerv2 "github.com/Yosshi72/fw-controller/api/v2"
 	"github.com/Yosshi72/fw-controller/pkg/util"
 )
 
@@ -39,6 +50,8 @@ type FwMasterReconciler struct {
 //+kubebuilder:rbac:groups=samplecontroller.yossy.vsix.wide.ad.jp,resources=fwmasters,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=samplecontroller.yossy.vsix.wide.ad.jp,resources=fwmasters/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=samplecontroller.yossy.vsix.wide.ad.jp,resources=fwmasters/finalizers,verbs=update
+//+kubebuilder:rbac:groups=samplecontroller.yossy.vsix.wide.ad.jp,resources=addressgroups,verbs=get;list;watch
+//+kubebuilder:rbac:groups=samplecontroller.yossy.vsix.wide.ad.jp,resources=fwagents,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -52,34 +65,56 @@ type FwMasterReconciler struct {
 func (r *FwMasterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
 	res := util.NewResult()
-	fwm := samplecontrollerv1.FwMaster{}
+	fwm := samplecontrollerv2.FwMaster{}
 
 	if err := r.Get(ctx, req.NamespacedName, &fwm); err != nil {
 		if errors.IsNotFound(err) {
-			return ctrl.Result{Requeue: true}, nil
+			// FwLets are owned by the FwMaster and collected by the API server
+			return ctrl.Result{}, nil
 		}
 		log.Error(err, "msg", "line", util.LINE())
 		return ctrl.Result{Requeue: true}, err
 	}
+	origStatus := fwm.Status.DeepCopy()
+
+	// AddressGroupを展開する
+	spec, err := r.expandAddressGroups(ctx, &fwm)
+	if e, ok := err.(*applyError); ok {
+		// Wait for the groups to be fixed, which triggers a reconcile.
+		log.Error(err, "msg", "line", util.LINE())
+		conds := &fwm.Status.Conditions
+		setCondition(conds, fwm.GetGeneration(), samplecontrollerv2.ConditionApplied, metav1.ConditionFalse, e.Reason, e.Error())
+		setReady(conds, fwm.GetGeneration())
+		if !equality.Semantic.DeepEqual(origStatus, &fwm.Status) {
+			if err := r.Status().Update(ctx, &fwm); err != nil {
+				log.Error(err, "msg", "line", util.LINE())
+				return ctrl.Result{Requeue: true}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		log.Error(err, "msg", "line", util.LINE())
+		return ctrl.Result{Requeue: true}, err
+	}
 
 	// SpecとStatusでRegionに齟齬がないか
 	allok := true
-	for _, regionSpec := range fwm.Spec.Regions {
+	for _, regionSpec := range spec.Regions {
 		foundRegionInStatus := false
 		for _, regionStatus := range fwm.Status.Regions {
-			if regionStatus.RegionName == regionSpec.RegionName {
+			if regionStatus.Name == regionSpec.Name {
 				foundRegionInStatus = true
 			}
 		}
 		if !foundRegionInStatus {
-			newRegionStatus := samplecontrollerv1.RegionStatus{
-				RegionName:       regionSpec.RegionName,
-				TrustIf:          regionSpec.TrustIf,
-				UntrustIf:        regionSpec.UntrustIf,
-				MgmtAddressRange: fwm.Spec.MgmtAddressRange,
-				Created:          false,
+			newRegionStatus := samplecontrollerv2.RegionStatus{
+				Name:                regionSpec.Name,
+				Zones:               regionSpec.Zones,
+				ManagementAddresses: mergePrefixes(spec.RegionManagementAddresses(&regionSpec)),
+				Created:             false,
 			}
-			err := r.ReconcileFwLet(ctx, fwm, regionSpec, fwm.Spec.MgmtAddressRange)
+			err := r.ReconcileFwLet(ctx, fwm, regionSpec, newRegionStatus.ManagementAddresses)
 			if err != nil {
 				log.Error(err, "msg", "line", util.LINE())
 				return ctrl.Result{Requeue: true}, err
@@ -91,10 +126,10 @@ func (r *FwMasterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		}
 	}
 
-	for _, regionSpec := range fwm.Spec.Regions {
+	for _, regionSpec := range spec.Regions {
 		for _, regionStatus := range fwm.Status.Regions {
-			if regionSpec.RegionName == regionStatus.RegionName {
-				err := r.ReconcileFwLet(ctx, fwm, regionSpec, fwm.Spec.MgmtAddressRange)
+			if regionSpec.Name == regionStatus.Name {
+				err := r.ReconcileFwLet(ctx, fwm, regionSpec, mergePrefixes(spec.RegionManagementAddresses(&regionSpec)))
 				if err != nil {
 					log.Error(err, "msg", "line", util.LINE())
 					return ctrl.Result{Requeue: true}, err
@@ -102,6 +137,23 @@ func (r *FwMasterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 			}
 		}
 	}
+
+	// Specから消えたRegionのFwLetを片付ける
+	if err := r.pruneRegions(ctx, &fwm); err != nil {
+		log.Error(err, "msg", "line", util.LINE())
+		return ctrl.Result{Requeue: true}, err
+	}
+
+	// FwLetの状態をStatusに反映する
+	recheck, err := r.aggregateStatus(ctx, &fwm)
+	if err != nil {
+		log.Error(err, "msg", "line", util.LINE())
+		return ctrl.Result{Requeue: true}, err
+	}
+	if !equality.Semantic.DeepEqual(origStatus, &fwm.Status) {
+		res.StatusUpdated = true
+	}
+
 	if !allok {
 		if res.SpecUpdated {
 			if err := r.Update(ctx, &fwm); err != nil {
@@ -130,19 +182,41 @@ func (r *FwMasterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 			return ctrl.Result{Requeue: true}, err
 		}
 	}
-	return ctrl.Result{}, nil
+	// Notice an agent that stops heartbeating.
+	return ctrl.Result{RequeueAfter: recheck}, nil
 }
 
-func (r *FwMasterReconciler) ReconcileFwLet(ctx context.Context, fwm samplecontrollerv1.FwMaster, regionSpec samplecontrollerv1.RegionSpec, MgmtAddressRange []string) error {
+func (r *FwMasterReconciler) ReconcileFwLet(ctx context.Context, fwm samplecontrollerv2.FwMaster, regionSpec samplecontrollerv2.RegionSpec, mgmtAddrs []string) error {
 	// FwMasterからFwLetへ
 	log := log.FromContext(ctx)
-	fwl := samplecontrollerv1.FwLet{}
+	// Regions of a FwMaster written without the webhook are not defaulted
+	// yet.
+	regionSpec = *regionSpec.DeepCopy()
+	regionSpec.SetDefaults(fwm.Spec.Defaults)
+	fwl := samplecontrollerv2.FwLet{}
 	fwl.SetNamespace(fwm.GetNamespace())
-	fwl.SetName(regionSpec.RegionName)
+	fwl.SetName(regionSpec.Name)
 	op, err := ctrl.CreateOrUpdate(ctx, r.Client, &fwl, func() error {
-		fwl.Spec.TrustIf = regionSpec.TrustIf
-		fwl.Spec.UntrustIf = regionSpec.UntrustIf
-		fwl.Spec.MgmtAddressRange = MgmtAddressRange
+		fwl.Spec.ManagementAddresses = mgmtAddrs
+		fwl.Spec.Baseline = regionSpec.Baseline
+		fwl.Spec.DriftPolicy = regionSpec.DriftPolicy
+		fwl.Spec.ICMPRateLimit = regionSpec.ICMPRateLimit
+		fwl.Spec.Zones = regionSpec.Zones
+		fwl.Spec.ZonePolicies = regionSpec.ZonePolicies
+		fwl.Spec.Rules = regionSpec.Rules
+		fwl.Spec.Netns = regionSpec.Netns
+		// The node label follows the region's node, but a label set by
+		// hand on a region without node is kept.
+		if regionSpec.NodeName != "" {
+			metav1.SetMetaDataLabel(&fwl.ObjectMeta, samplecontrollerv2.NodeLabel, regionSpec.NodeName)
+		} else if fwl.Spec.NodeName != "" && fwl.Labels[samplecontrollerv2.NodeLabel] == fwl.Spec.NodeName {
+			delete(fwl.Labels, samplecontrollerv2.NodeLabel)
+		}
+		fwl.Spec.NodeName = regionSpec.NodeName
+		fwl.Spec.TemplateRef = fwm.Spec.TemplateRef
+		if regionSpec.TemplateRef != nil {
+			fwl.Spec.TemplateRef = regionSpec.TemplateRef
+		}
 		return ctrl.SetControllerReference(&fwm, &fwl, r.Scheme)
 	})
 
@@ -157,10 +231,198 @@ func (r *FwMasterReconciler) ReconcileFwLet(ctx context.Context, fwm samplecontr
 	return nil
 }
 
+// pruneRegions deletes, or orphans if the FwMaster asks for it, the FwLets
+// of regions no longer in spec and drops their status entries.
+func (r *FwMasterReconciler) pruneRegions(ctx context.Context, fwm *samplecontrollerv2.FwMaster) error {
+	log := log.FromContext(ctx)
+	inSpec := map[string]bool{}
+	for _, regionSpec := range fwm.Spec.Regions {
+		inSpec[regionSpec.Name] = true
+	}
+
+	fwls := samplecontrollerv2.FwLetList{}
+	if err := r.List(ctx, &fwls, client.InNamespace(fwm.GetNamespace())); err != nil {
+		return err
+	}
+	orphan := fwm.GetAnnotations()[samplecontrollerv2.RegionDeletionPolicyAnnotation] == samplecontrollerv2.RegionDeletionPolicyOrphan
+	for i := range fwls.Items {
+		fwl := &fwls.Items[i]
+		if inSpec[fwl.GetName()] || !metav1.IsControlledBy(fwl, fwm) {
+			continue
+		}
+		if orphan {
+			refs := []metav1.OwnerReference{}
+			for _, ref := range fwl.GetOwnerReferences() {
+				if ref.UID != fwm.GetUID() {
+					refs = append(refs, ref)
+				}
+			}
+			fwl.SetOwnerReferences(refs)
+			if err := r.Update(ctx, fwl); err != nil {
+				return err
+			}
+			log.Info("orphaned FwLet of removed region", "region", fwl.GetName())
+			continue
+		}
+		if err := r.Delete(ctx, fwl); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		log.Info("deleted FwLet of removed region", "region", fwl.GetName())
+	}
+
+	regions := []samplecontrollerv2.RegionStatus{}
+	for _, regionStatus := range fwm.Status.Regions {
+		if inSpec[regionStatus.Name] {
+			regions = append(regions, regionStatus)
+		}
+	}
+	fwm.Status.Regions = regions
+	return nil
+}
+
+// aggregateStatus copies the state of every region's FwLet and its agent
+// into the region status and derives the FwMaster conditions from them. It
+// returns when the liveness of an agent expires next, 0 if none is alive.
+func (r *FwMasterReconciler) aggregateStatus(ctx context.Context, fwm *samplecontrollerv2.FwMaster) (time.Duration, error) {
+	var notApplied, degraded, drifted, lost []string
+	now := time.Now()
+	var recheck time.Duration
+	for i := range fwm.Status.Regions {
+		rs := &fwm.Status.Regions[i]
+		fwl := samplecontrollerv2.FwLet{}
+		err := r.Get(ctx, types.NamespacedName{Namespace: fwm.GetNamespace(), Name: rs.Name}, &fwl)
+		if errors.IsNotFound(err) {
+			rs.Ready = false
+			rs.Agent, rs.AgentAlive = "", false
+			notApplied = append(notApplied, rs.Name)
+			lost = append(lost, rs.Name)
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		conds := fwl.Status.Conditions
+		rs.Ready = meta.IsStatusConditionTrue(conds, samplecontrollerv2.ConditionReady)
+		rs.LastAppliedTime = fwl.Status.LastAppliedTime
+		rs.RulesetHash = fwl.Status.RulesetHash
+		rs.Agent, rs.AgentAlive = fwl.Status.Agent, false
+		if rs.Agent != "" {
+			agent := samplecontrollerv2.FwAgent{}
+			err := r.Get(ctx, types.NamespacedName{Name: rs.Agent}, &agent)
+			if err != nil && !errors.IsNotFound(err) {
+				return 0, err
+			}
+			if err == nil && agent.Status.Alive(now) {
+				rs.AgentAlive = true
+				if left := agent.Status.Expiry().Sub(now); recheck == 0 || left < recheck {
+					recheck = left
+				}
+			}
+		}
+		if !rs.AgentAlive {
+			lost = append(lost, rs.Name)
+		}
+		if !meta.IsStatusConditionTrue(conds, samplecontrollerv2.ConditionApplied) ||
+			fwl.Status.ObservedGeneration != fwl.GetGeneration() {
+			notApplied = append(notApplied, rs.Name)
+		}
+		if meta.IsStatusConditionTrue(conds, samplecontrollerv2.ConditionDegraded) {
+			degraded = append(degraded, rs.Name)
+		}
+		if meta.IsStatusConditionTrue(conds, samplecontrollerv2.ConditionDriftDetected) {
+			drifted = append(drifted, rs.Name)
+		}
+	}
+
+	conds := &fwm.Status.Conditions
+	gen := fwm.GetGeneration()
+	if len(notApplied) == 0 {
+		setCondition(conds, gen, samplecontrollerv2.ConditionApplied, metav1.ConditionTrue, ReasonApplied, "")
+	} else {
+		setCondition(conds, gen, samplecontrollerv2.ConditionApplied, metav1.ConditionFalse, ReasonPending,
+			"waiting for regions: "+strings.Join(notApplied, ", "))
+	}
+	if len(degraded) == 0 {
+		setCondition(conds, gen, samplecontrollerv2.ConditionDegraded, metav1.ConditionFalse, ReasonAsExpected, "")
+	} else {
+		setCondition(conds, gen, samplecontrollerv2.ConditionDegraded, metav1.ConditionTrue, ReasonNotReady,
+			"degraded regions: "+strings.Join(degraded, ", "))
+	}
+	if len(drifted) == 0 {
+		setCondition(conds, gen, samplecontrollerv2.ConditionDriftDetected, metav1.ConditionFalse, ReasonNoDrift, "")
+	} else {
+		setCondition(conds, gen, samplecontrollerv2.ConditionDriftDetected, metav1.ConditionTrue, ReasonNotReady,
+			"drifted regions: "+strings.Join(drifted, ", "))
+	}
+	if len(lost) == 0 {
+		setCondition(conds, gen, samplecontrollerv2.ConditionAgentsAlive, metav1.ConditionTrue, ReasonAgentsAlive, "")
+	} else {
+		setCondition(conds, gen, samplecontrollerv2.ConditionAgentsAlive, metav1.ConditionFalse, ReasonAgentLost,
+			"regions without a live agent: "+strings.Join(lost, ", "))
+	}
+	setReady(conds, gen)
+	fwm.Status.ObservedGeneration = gen
+	return recheck, nil
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *FwMasterReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &samplecontrollerv2.FwMaster{}, addressGroupIndex, addressGroupNames); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &samplecontrollerv2.FwLet{}, agentIndex, agentName); err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&samplecontrollerv1.FwMaster{}).
-		Owns(&samplecontrollerv1.FwLet{}).
+		For(&samplecontrollerv2.FwMaster{}).
+		Owns(&samplecontrollerv2.FwLet{}).
+		Watches(&samplecontrollerv2.AddressGroup{}, handler.EnqueueRequestsFromMapFunc(r.fwMastersForAddressGroup)).
+		Watches(&samplecontrollerv2.FwAgent{}, handler.EnqueueRequestsFromMapFunc(r.fwMastersForFwAgent),
+			builder.WithPredicates(agentLivenessChanged)).
 		Complete(r)
 }
+
+// agentIndex indexes FwLets by the FwAgent enforcing them.
+const agentIndex = ".status.agent"
+
+func agentName(obj client.Object) []string {
+	fwl := obj.(*samplecontrollerv2.FwLet)
+	if fwl.Status.Agent == "" {
+		return nil
+	}
+	return []string{fwl.Status.Agent}
+}
+
+// agentLivenessChanged passes the FwAgent events that may change the
+// liveness seen by FwMasters. Heartbeats of a live agent do not; its
+// expiry is noticed by the requeue of the FwMaster.
+var agentLivenessChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		old, ok := e.ObjectOld.(*samplecontrollerv2.FwAgent)
+		return !ok || !old.Status.Alive(time.Now())
+	},
+}
+
+// fwMastersForFwAgent reconciles the FwMasters owning the FwLets enforced
+// by agent.
+func (r *FwMasterReconciler) fwMastersForFwAgent(ctx context.Context, agent client.Object) []reconcile.Request {
+	fwls := samplecontrollerv2.FwLetList{}
+	if err := r.List(ctx, &fwls, client.MatchingFields{agentIndex: agent.GetName()}); err != nil {
+		log.FromContext(ctx).Error(err, "msg", "line", util.LINE())
+		return nil
+	}
+	seen := map[types.NamespacedName]bool{}
+	var reqs []reconcile.Request
+	for i := range fwls.Items {
+		owner := metav1.GetControllerOf(&fwls.Items[i])
+		if owner == nil || owner.Kind != "FwMaster" {
+			continue
+		}
+		key := types.NamespacedName{Namespace: fwls.Items[i].GetNamespace(), Name: owner.Name}
+		if !seen[key] {
+			seen[key] = true
+			reqs = append(reqs, reconcile.Request{NamespacedName: key})
+		}
+	}
+	return reqs
+}
@@ -0,0 +1,512 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	samplecontrollerv2 "github.com/Yosshi72/fw-controller/api/v2"
+)
+
+func newTestFwMasterReconciler(t *testing.T, objs ...client.Object) *FwMasterReconciler {
+	t.Helper()
+	s := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := samplecontrollerv2.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewClientBuilder().
+		WithScheme(s).
+		WithObjects(objs...).
+		WithStatusSubresource(&samplecontrollerv2.FwMaster{}, &samplecontrollerv2.FwLet{}, &samplecontrollerv2.FwAgent{}).
+		WithIndex(&samplecontrollerv2.FwMaster{}, addressGroupIndex, addressGroupNames).
+		WithIndex(&samplecontrollerv2.FwLet{}, agentIndex, agentName).
+		Build()
+	return &FwMasterReconciler{Client: c, Scheme: s}
+}
+
+func TestFwMasterReconcileAggregatesFwLets(t *testing.T) {
+	fwm := &samplecontrollerv2.FwMaster{
+		ObjectMeta: metav1.ObjectMeta{Name: "master", Namespace: "default"},
+		Spec: samplecontrollerv2.FwMasterSpec{
+			Regions: []samplecontrollerv2.RegionSpec{
+				{Name: "kote", Zones: append(trustZones([]string{"eth-a"}, "vsix-bb"),
+					samplecontrollerv2.Zone{Name: "dmz", Interfaces: []string{"eth-d"}},
+				), Rules: []samplecontrollerv2.PolicyRule{
+					{From: samplecontrollerv2.ZoneUntrust, To: samplecontrollerv2.ZoneTrust, Protocol: "tcp", Ports: []samplecontrollerv2.PortRange{"22"}, Action: samplecontrollerv2.RuleActionAccept},
+				}},
+			},
+			ManagementAddresses: []string{"2001:db8:10:10::/64"},
+		},
+	}
+	r := newTestFwMasterReconciler(t, fwm)
+
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "master", Namespace: "default"}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	fwl := samplecontrollerv2.FwLet{}
+	if err := r.Get(ctx, types.NamespacedName{Name: "kote", Namespace: "default"}, &fwl); err != nil {
+		t.Fatalf("FwLet not created: %v", err)
+	}
+	if untrust := fwl.Spec.Interfaces(samplecontrollerv2.ZoneUntrust); len(untrust) != 1 || untrust[0] != "vsix-bb" ||
+		len(fwl.Spec.ManagementAddresses) != 1 || len(fwl.Spec.Rules) != 1 || len(fwl.Spec.Zones) != 3 {
+		t.Errorf("unexpected FwLet spec %+v", fwl.Spec)
+	}
+
+	got := samplecontrollerv2.FwMaster{}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	if meta.IsStatusConditionTrue(got.Status.Conditions, samplecontrollerv2.ConditionReady) {
+		t.Errorf("FwMaster Ready before its FwLet applied anything")
+	}
+
+	// Pretend the agent applied the ruleset.
+	now := metav1.Now()
+	if err := r.Create(ctx, fwAgent("node1", now.Time)); err != nil {
+		t.Fatal(err)
+	}
+	fwl.Status.Agent = "node1"
+	fwl.Status.RulesetHash = "abc"
+	fwl.Status.LastAppliedTime = &now
+	fwl.Status.ObservedGeneration = fwl.GetGeneration()
+	for _, c := range []string{samplecontrollerv2.ConditionApplied, samplecontrollerv2.ConditionReady} {
+		setCondition(&fwl.Status.Conditions, fwl.GetGeneration(), c, metav1.ConditionTrue, ReasonApplied, "")
+	}
+	if err := r.Status().Update(ctx, &fwl); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !meta.IsStatusConditionTrue(got.Status.Conditions, samplecontrollerv2.ConditionReady) {
+		t.Errorf("FwMaster not Ready: %+v", got.Status.Conditions)
+	}
+	if len(got.Status.Regions) != 1 || !got.Status.Regions[0].Ready || got.Status.Regions[0].RulesetHash != "abc" ||
+		got.Status.Regions[0].Agent != "node1" || !got.Status.Regions[0].AgentAlive {
+		t.Errorf("unexpected region status %+v", got.Status.Regions)
+	}
+	if got.Status.ObservedGeneration != got.GetGeneration() {
+		t.Errorf("Status.ObservedGeneration = %d, want %d", got.Status.ObservedGeneration, got.GetGeneration())
+	}
+}
+
+func TestFwMasterReconcileRegionManagementAddresses(t *testing.T) {
+	fwm := &samplecontrollerv2.FwMaster{
+		ObjectMeta: metav1.ObjectMeta{Name: "master", Namespace: "default"},
+		Spec: samplecontrollerv2.FwMasterSpec{
+			Regions: []samplecontrollerv2.RegionSpec{
+				{Name: "kote", Zones: trustZones([]string{"eth-a"}, "vsix-bb")},
+				{Name: "note", Zones: trustZones([]string{"eth-a"}, "vsix-bb"),
+					AdditionalManagementAddresses: []string{"2001:db8:20:10::/64"}},
+				{Name: "dojima", Zones: trustZones([]string{"eth-a"}, "vsix-bb"),
+					ManagementAddresses: []string{"2001:db8:30:10::/64"}},
+			},
+			ManagementAddresses: []string{"2001:db8:10:10::/64"},
+		},
+	}
+	r := newTestFwMasterReconciler(t, fwm)
+
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "master", Namespace: "default"}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	want := map[string][]string{
+		"kote":   {"2001:db8:10:10::/64"},
+		"note":   {"2001:db8:10:10::/64", "2001:db8:20:10::/64"},
+		"dojima": {"2001:db8:30:10::/64"},
+	}
+	for name, addrs := range want {
+		fwl := samplecontrollerv2.FwLet{}
+		if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, &fwl); err != nil {
+			t.Fatalf("FwLet %s not created: %v", name, err)
+		}
+		if !reflect.DeepEqual(fwl.Spec.ManagementAddresses, addrs) {
+			t.Errorf("FwLet %s ManagementAddresses = %v, want %v", name, fwl.Spec.ManagementAddresses, addrs)
+		}
+	}
+}
+
+func TestFwMasterReconcileAppliesDefaults(t *testing.T) {
+	// Written without the defaulting webhook.
+	fwm := &samplecontrollerv2.FwMaster{
+		ObjectMeta: metav1.ObjectMeta{Name: "master", Namespace: "default"},
+		Spec: samplecontrollerv2.FwMasterSpec{
+			Regions: []samplecontrollerv2.RegionSpec{
+				{Name: "kote", Zones: append(trustZones([]string{"eth-a"}, "vsix-bb"),
+					samplecontrollerv2.Zone{Name: "dmz", Interfaces: []string{"eth-d"}})},
+				{Name: "note", Zones: trustZones([]string{"eth-a"}, "vsix-bb"),
+					DriftPolicy: samplecontrollerv2.DriftPolicyReport, ICMPRateLimit: "1/second"},
+			},
+			Defaults: &samplecontrollerv2.RegionDefaults{
+				ZonePolicies:  []samplecontrollerv2.ZonePolicy{{From: samplecontrollerv2.ZoneTrust, To: "dmz", Default: "accept"}},
+				Baseline:      &samplecontrollerv2.BaselineSpec{Mode: samplecontrollerv2.BaselineFailClosed},
+				ICMPRateLimit: "5/second",
+			},
+		},
+	}
+	r := newTestFwMasterReconciler(t, fwm)
+
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "master", Namespace: "default"}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	kote := samplecontrollerv2.FwLet{}
+	if err := r.Get(ctx, types.NamespacedName{Name: "kote", Namespace: "default"}, &kote); err != nil {
+		t.Fatalf("FwLet kote not created: %v", err)
+	}
+	if len(kote.Spec.ZonePolicies) != 1 || kote.Spec.Baseline == nil || kote.Spec.Baseline.Mode != samplecontrollerv2.BaselineFailClosed ||
+		kote.Spec.DriftPolicy != samplecontrollerv2.DriftPolicyReapply || kote.Spec.ICMPRateLimit != "5/second" {
+		t.Errorf("FwLet kote not defaulted: %+v", kote.Spec)
+	}
+	note := samplecontrollerv2.FwLet{}
+	if err := r.Get(ctx, types.NamespacedName{Name: "note", Namespace: "default"}, &note); err != nil {
+		t.Fatalf("FwLet note not created: %v", err)
+	}
+	if len(note.Spec.ZonePolicies) != 0 || note.Spec.DriftPolicy != samplecontrollerv2.DriftPolicyReport || note.Spec.ICMPRateLimit != "1/second" {
+		t.Errorf("FwLet note settings overwritten: %+v", note.Spec)
+	}
+
+	// The FwMaster itself is left as written.
+	got := samplecontrollerv2.FwMaster{}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Spec, fwm.Spec) {
+		t.Errorf("FwMaster spec changed to %+v", got.Spec)
+	}
+}
+
+func TestFwMasterReconcilePrunesRemovedRegions(t *testing.T) {
+	for _, policy := range []string{"", samplecontrollerv2.RegionDeletionPolicyOrphan} {
+		t.Run("policy="+policy, func(t *testing.T) {
+			fwm := &samplecontrollerv2.FwMaster{
+				ObjectMeta: metav1.ObjectMeta{Name: "master", Namespace: "default", UID: "master-uid"},
+				Spec: samplecontrollerv2.FwMasterSpec{
+					Regions: []samplecontrollerv2.RegionSpec{
+						{Name: "kote", Zones: trustZones([]string{"eth-a"}, "vsix-bb")},
+						{Name: "note", Zones: trustZones([]string{"eth-b"}, "vsix-bb")},
+					},
+				},
+			}
+			if policy != "" {
+				fwm.SetAnnotations(map[string]string{samplecontrollerv2.RegionDeletionPolicyAnnotation: policy})
+			}
+			r := newTestFwMasterReconciler(t, fwm)
+
+			ctx := context.Background()
+			key := types.NamespacedName{Name: "master", Namespace: "default"}
+			if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+
+			got := samplecontrollerv2.FwMaster{}
+			if err := r.Get(ctx, key, &got); err != nil {
+				t.Fatal(err)
+			}
+			got.Spec.Regions = got.Spec.Regions[:1]
+			if err := r.Update(ctx, &got); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+
+			if err := r.Get(ctx, key, &got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Status.Regions) != 1 || got.Status.Regions[0].Name != "kote" {
+				t.Errorf("status regions not pruned: %+v", got.Status.Regions)
+			}
+
+			fwl := samplecontrollerv2.FwLet{}
+			err := r.Get(ctx, types.NamespacedName{Name: "note", Namespace: "default"}, &fwl)
+			if policy == samplecontrollerv2.RegionDeletionPolicyOrphan {
+				if err != nil {
+					t.Fatalf("orphaned FwLet was deleted: %v", err)
+				}
+				if metav1.IsControlledBy(&fwl, &got) {
+					t.Errorf("orphaned FwLet is still owned by the FwMaster")
+				}
+			} else if !errors.IsNotFound(err) {
+				t.Errorf("FwLet of removed region not deleted: %v", err)
+			}
+		})
+	}
+}
+
+func addressGroup(name string, addrs []string, groups ...string) *samplecontrollerv2.AddressGroup {
+	return &samplecontrollerv2.AddressGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       samplecontrollerv2.AddressGroupSpec{Addresses: addrs, Groups: groups},
+	}
+}
+
+func TestFwMasterReconcileResolvesAddressGroups(t *testing.T) {
+	fwm := &samplecontrollerv2.FwMaster{
+		ObjectMeta: metav1.ObjectMeta{Name: "master", Namespace: "default"},
+		Spec: samplecontrollerv2.FwMasterSpec{
+			Regions: []samplecontrollerv2.RegionSpec{
+				{Name: "kote", Zones: trustZones([]string{"eth-a"}, "vsix-bb"),
+					ManagementAddressGroups: []string{"kote-noc"},
+					Rules: []samplecontrollerv2.PolicyRule{{
+						From: samplecontrollerv2.ZoneUntrust, To: samplecontrollerv2.ZoneTrust,
+						SourceGroups: []string{"wide"}, Action: samplecontrollerv2.RuleActionAccept,
+					}}},
+			},
+			ManagementAddresses:     []string{"2001:db8:10::/48"},
+			ManagementAddressGroups: []string{"noc"},
+		},
+	}
+	r := newTestFwMasterReconciler(t, fwm,
+		// noc's first prefix is within the inline 2001:db8:10::/48.
+		addressGroup("noc", []string{"2001:db8:10:10::/64"}, "wide"),
+		addressGroup("wide", []string{"203.178.128.0/17"}),
+		// wide is reached twice, through noc and directly.
+		addressGroup("kote-noc", []string{"2001:db8:20:10::/64"}, "wide"),
+	)
+
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "master", Namespace: "default"}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	fwl := samplecontrollerv2.FwLet{}
+	if err := r.Get(ctx, types.NamespacedName{Name: "kote", Namespace: "default"}, &fwl); err != nil {
+		t.Fatalf("FwLet not created: %v", err)
+	}
+	want := []string{"2001:db8:10::/48", "203.178.128.0/17", "2001:db8:20:10::/64"}
+	if !reflect.DeepEqual(fwl.Spec.ManagementAddresses, want) {
+		t.Errorf("ManagementAddresses = %v, want %v", fwl.Spec.ManagementAddresses, want)
+	}
+	rule := fwl.Spec.Rules[0]
+	if !reflect.DeepEqual(rule.Source, []string{"203.178.128.0/17"}) || rule.SourceGroups != nil {
+		t.Errorf("rule not resolved: %+v", rule)
+	}
+
+	got := samplecontrollerv2.FwMaster{}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Spec.ManagementAddressGroups) != 1 || len(got.Spec.ManagementAddresses) != 1 {
+		t.Errorf("FwMaster spec modified: %+v", got.Spec)
+	}
+}
+
+func TestFwMasterReconcileInvalidAddressGroups(t *testing.T) {
+	tests := []struct {
+		name   string
+		groups []client.Object
+		msg    string
+	}{
+		{"missing", nil, "AddressGroup noc not found"},
+		{"cycle", []client.Object{
+			addressGroup("noc", []string{"2001:db8:10:10::/64"}, "wide"),
+			addressGroup("wide", []string{"203.178.128.0/17"}, "ops"),
+			addressGroup("ops", nil, "wide"),
+		}, "AddressGroup cycle: wide -> ops -> wide"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fwm := &samplecontrollerv2.FwMaster{
+				ObjectMeta: metav1.ObjectMeta{Name: "master", Namespace: "default"},
+				Spec: samplecontrollerv2.FwMasterSpec{
+					Regions:                 []samplecontrollerv2.RegionSpec{{Name: "kote", Zones: trustZones([]string{"eth-a"}, "vsix-bb")}},
+					ManagementAddressGroups: []string{"noc"},
+				},
+			}
+			r := newTestFwMasterReconciler(t, append(tt.groups, fwm)...)
+
+			ctx := context.Background()
+			key := types.NamespacedName{Name: "master", Namespace: "default"}
+			res, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+			if err != nil || res.Requeue {
+				t.Fatalf("Reconcile() = %+v, %v, want to wait for the groups to change", res, err)
+			}
+			got := samplecontrollerv2.FwMaster{}
+			if err := r.Get(ctx, key, &got); err != nil {
+				t.Fatal(err)
+			}
+			cond := meta.FindStatusCondition(got.Status.Conditions, samplecontrollerv2.ConditionApplied)
+			if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != ReasonAddressGroupInvalid || cond.Message != tt.msg {
+				t.Errorf("Applied condition = %+v, want %s", cond, tt.msg)
+			}
+			fwl := samplecontrollerv2.FwLet{}
+			if err := r.Get(ctx, types.NamespacedName{Name: "kote", Namespace: "default"}, &fwl); !errors.IsNotFound(err) {
+				t.Errorf("FwLet created from unresolved groups: %v", err)
+			}
+		})
+	}
+}
+
+func TestFwMastersForAddressGroup(t *testing.T) {
+	master := func(name string, groups ...string) *samplecontrollerv2.FwMaster {
+		return &samplecontrollerv2.FwMaster{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: samplecontrollerv2.FwMasterSpec{Regions: []samplecontrollerv2.RegionSpec{{
+				Name:  name,
+				Rules: []samplecontrollerv2.PolicyRule{{SourceGroups: groups}},
+			}}},
+		}
+	}
+	wide := addressGroup("wide", []string{"203.178.128.0/17"})
+	r := newTestFwMasterReconciler(t,
+		addressGroup("noc", nil, "wide"),
+		addressGroup("ops", nil, "noc"),
+		wide,
+		addressGroup("lab", nil),
+		master("kote", "ops"),
+		master("note", "wide"),
+		master("dojima", "lab"),
+	)
+
+	var got []string
+	for _, req := range r.fwMastersForAddressGroup(context.Background(), wide) {
+		got = append(got, req.Name)
+	}
+	sort.Strings(got)
+	if want := []string{"kote", "note"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("fwMastersForAddressGroup(wide) = %v, want %v", got, want)
+	}
+}
+
+func TestFwMasterReconcilePlacesFwLets(t *testing.T) {
+	fwm := &samplecontrollerv2.FwMaster{
+		ObjectMeta: metav1.ObjectMeta{Name: "master", Namespace: "default"},
+		Spec: samplecontrollerv2.FwMasterSpec{
+			Regions: []samplecontrollerv2.RegionSpec{
+				{Name: "kote", Zones: trustZones([]string{"eth-a"}, "vsix-bb"), NodeName: "node1"},
+			},
+		},
+	}
+	r := newTestFwMasterReconciler(t, fwm)
+
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "master", Namespace: "default"}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	fwl := samplecontrollerv2.FwLet{}
+	fwlKey := types.NamespacedName{Name: "kote", Namespace: "default"}
+	if err := r.Get(ctx, fwlKey, &fwl); err != nil {
+		t.Fatal(err)
+	}
+	if fwl.Spec.NodeName != "node1" || fwl.Labels[samplecontrollerv2.NodeLabel] != "node1" {
+		t.Errorf("FwLet not placed on node1: nodeName %q, labels %v", fwl.Spec.NodeName, fwl.Labels)
+	}
+
+	// Moving the region relabels its FwLet, clearing the node drops the
+	// label.
+	for _, node := range []string{"node2", ""} {
+		got := samplecontrollerv2.FwMaster{}
+		if err := r.Get(ctx, key, &got); err != nil {
+			t.Fatal(err)
+		}
+		got.Spec.Regions[0].NodeName = node
+		if err := r.Update(ctx, &got); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		if err := r.Get(ctx, fwlKey, &fwl); err != nil {
+			t.Fatal(err)
+		}
+		label, ok := fwl.Labels[samplecontrollerv2.NodeLabel]
+		if fwl.Spec.NodeName != node || label != node || (node == "") == ok {
+			t.Errorf("node %q: FwLet nodeName %q, labels %v", node, fwl.Spec.NodeName, fwl.Labels)
+		}
+	}
+}
+
+// fwAgent returns an agent that heartbeat at last.
+func fwAgent(name string, last time.Time) *samplecontrollerv2.FwAgent {
+	return &samplecontrollerv2.FwAgent{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: samplecontrollerv2.FwAgentStatus{
+			HeartbeatPeriod:   metav1.Duration{Duration: 10 * time.Second},
+			LastHeartbeatTime: &metav1.Time{Time: last},
+		},
+	}
+}
+
+func TestFwMasterReconcileReportsAgentLiveness(t *testing.T) {
+	fwm := &samplecontrollerv2.FwMaster{
+		ObjectMeta: metav1.ObjectMeta{Name: "master", Namespace: "default", UID: "master-uid"},
+		Spec: samplecontrollerv2.FwMasterSpec{
+			Regions: []samplecontrollerv2.RegionSpec{
+				{Name: "kote", Zones: trustZones([]string{"eth-a"}, "vsix-bb")},
+				{Name: "note", Zones: trustZones([]string{"eth-a"}, "vsix-bb")},
+			},
+		},
+	}
+	r := newTestFwMasterReconciler(t, fwm, fwAgent("node1", time.Now()), fwAgent("node2", time.Now().Add(-time.Minute)))
+
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "master", Namespace: "default"}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	for region, agent := range map[string]string{"kote": "node1", "note": "node2"} {
+		fwl := samplecontrollerv2.FwLet{}
+		if err := r.Get(ctx, types.NamespacedName{Name: region, Namespace: "default"}, &fwl); err != nil {
+			t.Fatal(err)
+		}
+		fwl.Status.Agent = agent
+		if err := r.Status().Update(ctx, &fwl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if res.RequeueAfter <= 0 || res.RequeueAfter > 30*time.Second {
+		t.Errorf("RequeueAfter = %v, want the expiry of node1", res.RequeueAfter)
+	}
+	got := samplecontrollerv2.FwMaster{}
+	if err := r.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	alive := map[string]bool{}
+	for _, rs := range got.Status.Regions {
+		alive[rs.Name] = rs.AgentAlive
+	}
+	if !alive["kote"] || alive["note"] {
+		t.Errorf("agent liveness = %v, want kote alive only", alive)
+	}
+	if cond := meta.FindStatusCondition(got.Status.Conditions, samplecontrollerv2.ConditionAgentsAlive); cond == nil ||
+		cond.Status != metav1.ConditionFalse || cond.Message != "regions without a live agent: note" {
+		t.Errorf("AgentsAlive condition = %+v", cond)
+	}
+
+	// A heartbeat of the dead agent reconciles the FwMaster owning its
+	// FwLet.
+	reqs := r.fwMastersForFwAgent(ctx, fwAgent("node2", time.Now()))
+	if len(reqs) != 1 || reqs[0].NamespacedName != key {
+		t.Errorf("fwMastersForFwAgent() = %v, want %v", reqs, key)
+	}
+}
@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"context"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	samplecontrollerv2 "github.com/Yosshi72/fw-controller/api/v2"
+	"github.com/Yosshi72/fw-controller/pkg/executer"
+	"github.com/Yosshi72/fw-controller/pkg/util"
+)
+
+// linkWatcher watches the links of the namespaces FwLets are enforced in
+// and sends an event for each of those FwLets when a link changes, so
+// that interfaces coming and going are reported without waiting for a
+// resync.
+type linkWatcher struct {
+	monitor executer.LinkMonitor
+	events  chan event.GenericEvent
+
+	mu      sync.Mutex
+	ctx     context.Context
+	stop    context.CancelFunc
+	watches map[string]*linkWatch
+	// netns is the namespace each FwLet is watched in.
+	netns map[types.NamespacedName]string
+}
+
+// linkWatch is the subscription to the links of one namespace.
+type linkWatch struct {
+	stop   context.CancelFunc
+	fwlets map[types.NamespacedName]bool
+}
+
+func newLinkWatcher(monitor executer.LinkMonitor) *linkWatcher {
+	ctx, stop := context.WithCancel(context.Background())
+	return &linkWatcher{
+		monitor: monitor,
+		events:  make(chan event.GenericEvent, 64),
+		ctx:     ctx,
+		stop:    stop,
+		watches: map[string]*linkWatch{},
+		netns:   map[types.NamespacedName]string{},
+	}
+}
+
+// Start implements manager.Runnable. It ends every watch when ctx is done.
+func (w *linkWatcher) Start(ctx context.Context) error {
+	<-ctx.Done()
+	w.stop()
+	return nil
+}
+
+// watch watches netns for the FwLet key, and stops watching the namespace
+// it was watched in before.
+func (w *linkWatcher) watch(key types.NamespacedName, netns string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if prev, ok := w.netns[key]; ok {
+		if prev == netns {
+			return
+		}
+		w.release(key, prev)
+	}
+	w.netns[key] = netns
+	if lw, ok := w.watches[netns]; ok {
+		lw.fwlets[key] = true
+		return
+	}
+
+	ctx, stop := context.WithCancel(w.ctx)
+	lw := &linkWatch{stop: stop, fwlets: map[types.NamespacedName]bool{key: true}}
+	w.watches[netns] = lw
+	go func() {
+		err := w.monitor.WatchLinks(ctx, netns, func() { w.notify(netns) })
+		if err != nil {
+			log.FromContext(ctx).Error(err, "msg", "line", util.LINE())
+		}
+		// Let the next reconcile subscribe again.
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.watches[netns] == lw {
+			delete(w.watches, netns)
+			for key := range lw.fwlets {
+				delete(w.netns, key)
+			}
+		}
+	}()
+}
+
+// forget stops watching for the FwLet key.
+func (w *linkWatcher) forget(key types.NamespacedName) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if netns, ok := w.netns[key]; ok {
+		w.release(key, netns)
+	}
+}
+
+// release removes key from the watch of netns and ends the watch when no
+// FwLet is left. w.mu must be held.
+func (w *linkWatcher) release(key types.NamespacedName, netns string) {
+	delete(w.netns, key)
+	lw, ok := w.watches[netns]
+	if !ok {
+		return
+	}
+	delete(lw.fwlets, key)
+	if len(lw.fwlets) == 0 {
+		lw.stop()
+		delete(w.watches, netns)
+	}
+}
+
+// notify sends an event for every FwLet enforced in netns.
+func (w *linkWatcher) notify(netns string) {
+	w.mu.Lock()
+	var keys []types.NamespacedName
+	if lw, ok := w.watches[netns]; ok {
+		for key := range lw.fwlets {
+			keys = append(keys, key)
+		}
+	}
+	w.mu.Unlock()
+	for _, key := range keys {
+		fwl := &samplecontrollerv2.FwLet{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}}
+		select {
+		case w.events <- event.GenericEvent{Object: fwl}:
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
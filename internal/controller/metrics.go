@@ -0,0 +1,21 @@
+package controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	driftDetected = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fwlet_drift_detected",
+		Help: "Whether the live ruleset of a FwLet differs from the applied one (1) or not (0).",
+	}, []string{"namespace", "name"})
+	driftTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fwlet_drift_total",
+		Help: "Number of times drift of the live ruleset was detected.",
+	}, []string{"namespace", "name"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(driftDetected, driftTotal)
+}
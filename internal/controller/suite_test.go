@@ -31,6 +31,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	samplecontrollerv1 "github.com/Yosshi72/fw-controller/api/v1"
+	samplecontrollerv2 "github.com/Yosshi72/fw-controller/api/v2"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -64,6 +65,8 @@ var _ = BeforeSuite(func() {
 
 	err = samplecontrollerv1.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
+	err = samplecontrollerv2.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
 
 	//+kubebuilder:scaffold:scheme
 
@@ -1,15 +1,43 @@
 package executer
 
 import (
-	"os/exec"
+	"context"
+	"fmt"
 )
 
-func ExecCommand(containerName string) error {
-	netns := "vSIX"
-	scriptPath := "/etc/nftables/fw-template.rule"
+// Backend names accepted by New.
+const (
+	BackendNft   = "nft"
+	BackendSetns = "setns"
+	BackendFake  = "fake"
+)
+
+// Applier loads nftables rulesets into a network namespace.
+// An empty netns means the namespace the controller itself runs in.
+type Applier interface {
+	// Apply loads the ruleset script at path.
+	Apply(ctx context.Context, netns, path string) error
+	// Check validates the ruleset script at path without committing it.
+	Check(ctx context.Context, netns, path string) error
+	// Dump returns the ruleset currently loaded in the kernel.
+	Dump(ctx context.Context, netns string) (string, error)
+}
 
-	cmd := exec.Command("ip", "netns", "exec", netns, "nft", "-f", scriptPath)
-	err := cmd.Run()
+// Versioner is implemented by Appliers that can tell the version of the
+// nftables they drive.
+type Versioner interface {
+	Version(ctx context.Context) (string, error)
+}
 
-	return err
+// New returns the Applier for the named backend.
+func New(backend string) (Applier, error) {
+	switch backend {
+	case BackendNft:
+		return &NftApplier{}, nil
+	case BackendSetns:
+		return &SetnsApplier{}, nil
+	case BackendFake:
+		return NewFakeApplier(), nil
+	}
+	return nil, fmt.Errorf("Unknown applier backend: %q", backend)
 }
@@ -0,0 +1,120 @@
+package executer
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+)
+
+// FakeApplier keeps rulesets in memory. It is meant for tests and for
+// running the controller on hosts without nftables.
+type FakeApplier struct {
+	mu sync.Mutex
+	// Rulesets holds the last applied script per namespace.
+	Rulesets map[string]string
+	// Applied records every path passed to Apply, in order.
+	Applied []string
+	// ApplyErr and CheckErr, when set, are returned by Apply and Check.
+	ApplyErr error
+	CheckErr error
+}
+
+func NewFakeApplier() *FakeApplier {
+	return &FakeApplier{Rulesets: map[string]string{}}
+}
+
+func (a *FakeApplier) Apply(ctx context.Context, netns, path string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.ApplyErr != nil {
+		return a.ApplyErr
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// Like nft, a script that does not flush the ruleset adds to the loaded
+	// one.
+	if strings.HasPrefix(string(content), "flush ruleset") {
+		a.Rulesets[netns] = string(content)
+	} else {
+		a.Rulesets[netns] += string(content)
+	}
+	a.Applied = append(a.Applied, path)
+	return nil
+}
+
+func (a *FakeApplier) Check(ctx context.Context, netns, path string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.CheckErr != nil {
+		return a.CheckErr
+	}
+	_, err := os.Stat(path)
+	return err
+}
+
+func (a *FakeApplier) Version(ctx context.Context) (string, error) {
+	return "fake", nil
+}
+
+func (a *FakeApplier) Dump(ctx context.Context, netns string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.Rulesets[netns], nil
+}
+
+// FakeLinkMonitor reports the links it is given. It is meant for tests.
+type FakeLinkMonitor struct {
+	mu sync.Mutex
+	// Interfaces holds the links per namespace.
+	Interfaces map[string][]Interface
+	watchers   map[string]map[*func()]bool
+}
+
+func NewFakeLinkMonitor() *FakeLinkMonitor {
+	return &FakeLinkMonitor{Interfaces: map[string][]Interface{}, watchers: map[string]map[*func()]bool{}}
+}
+
+func (m *FakeLinkMonitor) Links(ns string) ([]Interface, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Interfaces[ns], nil
+}
+
+func (m *FakeLinkMonitor) WatchLinks(ctx context.Context, ns string, changed func()) error {
+	m.mu.Lock()
+	if m.watchers[ns] == nil {
+		m.watchers[ns] = map[*func()]bool{}
+	}
+	m.watchers[ns][&changed] = true
+	m.mu.Unlock()
+
+	<-ctx.Done()
+	m.mu.Lock()
+	delete(m.watchers[ns], &changed)
+	m.mu.Unlock()
+	return nil
+}
+
+// Watched tells how many watches ns has.
+func (m *FakeLinkMonitor) Watched(ns string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.watchers[ns])
+}
+
+// SetLinks replaces the links of ns and notifies its watchers.
+func (m *FakeLinkMonitor) SetLinks(ns string, links ...Interface) {
+	m.mu.Lock()
+	m.Interfaces[ns] = links
+	var watchers []func()
+	for changed := range m.watchers[ns] {
+		watchers = append(watchers, *changed)
+	}
+	m.mu.Unlock()
+	for _, changed := range watchers {
+		changed()
+	}
+}
@@ -0,0 +1,224 @@
+package executer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/mdlayher/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// ContainerRuntime finds the network namespace of a container.
+type ContainerRuntime interface {
+	// NetnsPath returns the path of the network namespace of the named
+	// container, which must be running.
+	NetnsPath(ctx context.Context, container string) (string, error)
+}
+
+// DockerRuntime asks a Docker compatible engine API, such as the one of
+// dockerd or podman, listening on a unix socket. The namespace path is
+// under /proc of the host, so the agent has to share the host's PID
+// namespace or mount its /proc.
+type DockerRuntime struct {
+	Socket string
+}
+
+func (d *DockerRuntime) NetnsPath(ctx context.Context, container string) (string, error) {
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", d.Socket)
+		},
+	}}
+	// The host is ignored, requests go to the socket.
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://docker/containers/"+url.PathEscape(container)+"/json", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Failed to inspect container %s: %v", container, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("Failed to inspect container %s: %s: %s", container, resp.Status, body)
+	}
+
+	var inspect struct {
+		State struct {
+			Running bool
+			Pid     int
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&inspect); err != nil {
+		return "", fmt.Errorf("Failed to decode container %s: %v", container, err)
+	}
+	if !inspect.State.Running || inspect.State.Pid == 0 {
+		return "", fmt.Errorf("Container %s is not running", container)
+	}
+	return fmt.Sprintf("/proc/%d/ns/net", inspect.State.Pid), nil
+}
+
+// nsGetNstype is the NS_GET_NSTYPE ioctl of nsfs, _IO(0xb7, 0x3).
+const nsGetNstype = 0xb703
+
+// CheckNetns tells whether ns, a name under /var/run/netns or a path, is a
+// network namespace that can be entered. Any other file is rejected, as
+// setns(2) on it would fail only after the agent trusted it.
+func CheckNetns(ns string) error {
+	handle, err := openNetns(ns)
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+	if !handle.IsOpen() {
+		return nil
+	}
+	var fs unix.Statfs_t
+	if err := unix.Fstatfs(int(handle), &fs); err != nil {
+		return fmt.Errorf("Failed to stat netns %s: %v", ns, err)
+	}
+	if fs.Type != unix.NSFS_MAGIC {
+		return fmt.Errorf("%s is not a namespace", ns)
+	}
+	nstype, err := unix.IoctlRetInt(int(handle), nsGetNstype)
+	if err != nil {
+		return fmt.Errorf("Failed to get the type of namespace %s: %v", ns, err)
+	}
+	if nstype != unix.CLONE_NEWNET {
+		return fmt.Errorf("%s is not a network namespace", ns)
+	}
+	return nil
+}
+
+// HostNetns tells whether ns is the network namespace of the agent or of
+// the host's init process, as far as the agent can see the latter.
+func HostNetns(ns string) (bool, error) {
+	handle, err := openNetns(ns)
+	if err != nil {
+		return false, err
+	}
+	defer handle.Close()
+	if !handle.IsOpen() {
+		return true, nil
+	}
+	for _, host := range []string{"/proc/self/ns/net", "/proc/1/ns/net"} {
+		other, err := netns.GetFromPath(host)
+		if err != nil {
+			// /proc/1 may be hidden from the agent.
+			continue
+		}
+		same := handle.Equal(other)
+		other.Close()
+		if same {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Interface is a network interface of a namespace.
+type Interface struct {
+	Name string
+	Up   bool
+	// Addresses are the prefixes assigned to the interface.
+	Addresses []string
+}
+
+// Interfaces lists the interfaces of ns, a name under /var/run/netns or a
+// path, sorted by name. An empty ns lists those of the agent's namespace.
+// The links are dumped over rtnetlink from a thread in ns.
+func Interfaces(ns string) ([]Interface, error) {
+	var list []Interface
+	err := withNetns(ns, func() error {
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			return fmt.Errorf("Failed to list interfaces: %v", err)
+		}
+		for _, iface := range ifaces {
+			addrs, err := iface.Addrs()
+			if err != nil {
+				return fmt.Errorf("Failed to list addresses of %s: %v", iface.Name, err)
+			}
+			i := Interface{Name: iface.Name, Up: iface.Flags&net.FlagUp != 0}
+			for _, addr := range addrs {
+				i.Addresses = append(i.Addresses, addr.String())
+			}
+			list = append(list, i)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// LinkMonitor lists the links of network namespaces and reports their
+// changes.
+type LinkMonitor interface {
+	// Links lists the interfaces of ns like Interfaces.
+	Links(ns string) ([]Interface, error)
+	// WatchLinks calls changed whenever a link of ns is added, removed or
+	// changes state, until ctx is done or the subscription fails.
+	WatchLinks(ctx context.Context, ns string, changed func()) error
+}
+
+// NetlinkMonitor is the LinkMonitor of the kernel, reached over rtnetlink.
+type NetlinkMonitor struct{}
+
+func (NetlinkMonitor) Links(ns string) ([]Interface, error) {
+	return Interfaces(ns)
+}
+
+func (NetlinkMonitor) WatchLinks(ctx context.Context, ns string, changed func()) error {
+	handle, err := openNetns(ns)
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	config := &netlink.Config{Groups: unix.RTMGRP_LINK}
+	if handle.IsOpen() {
+		config.NetNS = int(handle)
+	}
+	conn, err := netlink.Dial(unix.NETLINK_ROUTE, config)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to link events: %v", err)
+	}
+	// Closing the connection unblocks Receive.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	for {
+		msgs, err := conn.Receive()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("Failed to receive link events: %v", err)
+		}
+		for _, msg := range msgs {
+			if msg.Header.Type == unix.RTM_NEWLINK || msg.Header.Type == unix.RTM_DELLINK {
+				changed()
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package executer
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// NftApplier drives the nft command line tool, entering the namespace
+// with `ip netns exec` (named namespaces) or nsenter (namespace paths).
+type NftApplier struct {
+	// Path to the nft binary. Defaults to "nft" from $PATH.
+	NftPath string
+}
+
+func (a *NftApplier) Apply(ctx context.Context, netns, path string) error {
+	_, err := a.run(ctx, netns, "-f", path)
+	return err
+}
+
+func (a *NftApplier) Check(ctx context.Context, netns, path string) error {
+	_, err := a.run(ctx, netns, "-c", "-f", path)
+	return err
+}
+
+// Dump lists the ruleset without stateful values, such as the packets of
+// counters, which change without the ruleset changing.
+func (a *NftApplier) Dump(ctx context.Context, netns string) (string, error) {
+	return a.run(ctx, netns, "-s", "list", "ruleset")
+}
+
+func (a *NftApplier) Version(ctx context.Context) (string, error) {
+	return nftVersion(ctx, a.NftPath)
+}
+
+func (a *NftApplier) run(ctx context.Context, netns string, args ...string) (string, error) {
+	nft := a.NftPath
+	if nft == "" {
+		nft = "nft"
+	}
+	var cmd *exec.Cmd
+	switch {
+	case netns == "":
+		cmd = exec.CommandContext(ctx, nft, args...)
+	case strings.HasPrefix(netns, "/"):
+		cmd = exec.CommandContext(ctx, "nsenter", append([]string{"--net=" + netns, nft}, args...)...)
+	default:
+		cmd = exec.CommandContext(ctx, "ip", append([]string{"netns", "exec", netns, nft}, args...)...)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("Failed to run %s: %v: %s", strings.Join(cmd.Args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// nftVersion returns the version printed by `nft --version`, such as
+// "v1.0.2".
+func nftVersion(ctx context.Context, nft string) (string, error) {
+	if nft == "" {
+		nft = "nft"
+	}
+	out, err := exec.CommandContext(ctx, nft, "--version").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("Failed to run %s --version: %v: %s", nft, err, strings.TrimSpace(string(out)))
+	}
+	// nftables v1.0.2 (Lester Gooch)
+	fields := strings.Fields(string(out))
+	if len(fields) < 2 {
+		return "", fmt.Errorf("Unexpected output of %s --version: %q", nft, out)
+	}
+	return fields[1], nil
+}
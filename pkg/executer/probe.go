@@ -0,0 +1,36 @@
+package executer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Prober tells whether the node is still healthy after a ruleset change.
+type Prober interface {
+	Probe(ctx context.Context, netns string) error
+}
+
+// DialProber opens a TCP connection to Address from inside the namespace.
+// It is meant to point at something that must stay reachable through the
+// firewall, such as the API server or a management router.
+type DialProber struct {
+	Address string
+	Timeout time.Duration
+}
+
+func (p *DialProber) Probe(ctx context.Context, netns string) error {
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = 3 * time.Second
+	}
+	return withNetns(netns, func() error {
+		d := net.Dialer{Timeout: timeout}
+		conn, err := d.DialContext(ctx, "tcp", p.Address)
+		if err != nil {
+			return fmt.Errorf("Failed to reach %s: %v", p.Address, err)
+		}
+		return conn.Close()
+	})
+}
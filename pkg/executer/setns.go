@@ -0,0 +1,106 @@
+package executer
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/vishvananda/netns"
+)
+
+// SetnsApplier runs nft from an OS thread that has entered the namespace
+// with setns(2), instead of relying on iproute2 or nsenter being installed.
+// Rulesets are still parsed, checked, loaded and listed by nft, so Dump
+// prints nft's own syntax like NftApplier.
+type SetnsApplier struct {
+	// Path to the nft binary. Defaults to "nft" from $PATH.
+	NftPath string
+}
+
+func (a *SetnsApplier) Apply(ctx context.Context, ns, path string) error {
+	_, err := a.runNft(ctx, ns, "-f", path)
+	return err
+}
+
+func (a *SetnsApplier) Check(ctx context.Context, ns, path string) error {
+	_, err := a.runNft(ctx, ns, "-c", "-f", path)
+	return err
+}
+
+// Dump lists the ruleset without stateful values, such as the packets of
+// counters, which change without the ruleset changing.
+func (a *SetnsApplier) Dump(ctx context.Context, ns string) (string, error) {
+	return a.runNft(ctx, ns, "-s", "list", "ruleset")
+}
+
+func (a *SetnsApplier) Version(ctx context.Context) (string, error) {
+	return nftVersion(ctx, a.NftPath)
+}
+
+func (a *SetnsApplier) runNft(ctx context.Context, ns string, args ...string) (string, error) {
+	nft := a.NftPath
+	if nft == "" {
+		nft = "nft"
+	}
+	var out []byte
+	err := withNetns(ns, func() error {
+		cmd := exec.CommandContext(ctx, nft, args...)
+		var err error
+		out, err = cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("Failed to run %s: %v: %s", strings.Join(cmd.Args, " "), err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// openNetns resolves a namespace name (under /var/run/netns) or an
+// absolute namespace path. An empty name yields a closed handle.
+func openNetns(ns string) (netns.NsHandle, error) {
+	if ns == "" {
+		return netns.None(), nil
+	}
+	var handle netns.NsHandle
+	var err error
+	if strings.HasPrefix(ns, "/") {
+		handle, err = netns.GetFromPath(ns)
+	} else {
+		handle, err = netns.GetFromName(ns)
+	}
+	if err != nil {
+		return netns.None(), fmt.Errorf("Failed to open netns %s: %v", ns, err)
+	}
+	return handle, nil
+}
+
+// withNetns runs fn on an OS thread that has entered ns. Child processes
+// started from fn inherit the namespace.
+func withNetns(ns string, fn func() error) error {
+	if ns == "" {
+		return fn()
+	}
+	target, err := openNetns(ns)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		// The thread is never unlocked, so the runtime discards it when the
+		// goroutine exits instead of reusing it in the wrong namespace.
+		runtime.LockOSThread()
+		if err := netns.Set(target); err != nil {
+			errCh <- fmt.Errorf("Failed to enter netns %s: %v", ns, err)
+			return
+		}
+		errCh <- fn()
+	}()
+	return <-errCh
+}
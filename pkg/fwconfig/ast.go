@@ -0,0 +1,265 @@
+package fwconfig
+
+import "strings"
+
+// Stmt is a statement of an nft ruleset: a top level command, a table, an
+// object inside a table (chain, set, map, ...), a rule or a comment.
+//
+// Every statement keeps the whitespace that preceded it in Lead, so a
+// parsed ruleset prints back byte for byte. Statements built in code leave
+// Lead nil and get a newline and tab indentation when printed.
+type Stmt interface {
+	lead() *string
+}
+
+// Ruleset is a parsed nft ruleset file.
+type Ruleset struct {
+	Body []Stmt
+	// Trail is the whitespace after the last statement. nil prints a
+	// single newline.
+	Trail *string
+}
+
+// Comment is a "#" comment. It is inline if Lead does not contain a
+// newline, i.e. it trails the previous statement on the same line.
+type Comment struct {
+	Lead *string
+	Text string
+}
+
+// Inline reports whether the comment trails the previous statement.
+func (c *Comment) Inline() bool {
+	return c.Lead != nil && *c.Lead != "" && !strings.Contains(*c.Lead, "\n")
+}
+
+// Rule is a statement made of plain tokens: a rule inside a chain, a
+// property such as "type filter hook input priority 0" or "policy drop",
+// the "elements = { ... }" of a set, or a top level command such as
+// "flush ruleset" or "define".
+type Rule struct {
+	Lead   *string
+	Tokens []Token
+	// Semi records a terminating ";" and SemiSpace the whitespace before it.
+	Semi      bool
+	SemiSpace string
+}
+
+// Block is a statement followed by a { } body. Table, Chain and Set wrap
+// it for the objects the controller cares about; any other object (flowtable,
+// counter, quota, ct helper, ...) stays a plain Block.
+type Block struct {
+	Lead   *string
+	Header []Token
+	Body   []Stmt
+	// Open and Close are the whitespace before the opening and closing
+	// braces. nil prints a single space and a newline respectively.
+	Open  *string
+	Close *string
+}
+
+// Table is "table [family] name { ... }".
+type Table struct{ Block }
+
+// Chain is "chain name { ... }".
+type Chain struct{ Block }
+
+// Set is "set name { ... }" or "map name { ... }".
+type Set struct{ Block }
+
+func (c *Comment) lead() *string { return c.Lead }
+func (r *Rule) lead() *string    { return r.Lead }
+func (b *Block) lead() *string   { return b.Lead }
+
+// Family returns the address family of the table, "ip" if omitted.
+func (t *Table) Family() string {
+	if len(t.Header) >= 3 {
+		return t.Header[1].Text
+	}
+	return "ip"
+}
+
+// Name returns the table name.
+func (t *Table) Name() string { return t.Header[len(t.Header)-1].Unquote() }
+
+// Name returns the chain name.
+func (c *Chain) Name() string { return c.Header[len(c.Header)-1].Unquote() }
+
+// Hook returns the hook of a base chain, such as "input", or "" for a
+// regular chain.
+func (c *Chain) Hook() string {
+	for _, r := range c.Rules() {
+		if len(r.Tokens) >= 4 && r.Tokens[0].Text == "type" && r.Tokens[2].Text == "hook" {
+			return r.Tokens[3].Text
+		}
+	}
+	return ""
+}
+
+// Name returns the set or map name.
+func (s *Set) Name() string { return s.Header[len(s.Header)-1].Unquote() }
+
+// IsMap reports whether s is a map.
+func (s *Set) IsMap() bool { return s.Header[0].Text == "map" }
+
+// Rules returns the rules of b, skipping comments and nested blocks.
+func (b *Block) Rules() []*Rule {
+	var rules []*Rule
+	for _, st := range b.Body {
+		if r, ok := st.(*Rule); ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// Tables returns the tables of the ruleset.
+func (rs *Ruleset) Tables() []*Table {
+	var tables []*Table
+	for _, st := range rs.Body {
+		if t, ok := st.(*Table); ok {
+			tables = append(tables, t)
+		}
+	}
+	return tables
+}
+
+// Table returns the table with the given family and name, or nil.
+func (rs *Ruleset) Table(family, name string) *Table {
+	for _, t := range rs.Tables() {
+		if t.Family() == family && t.Name() == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// Chains returns the chains of the table.
+func (t *Table) Chains() []*Chain {
+	var chains []*Chain
+	for _, st := range t.Body {
+		if c, ok := st.(*Chain); ok {
+			chains = append(chains, c)
+		}
+	}
+	return chains
+}
+
+// Chain returns the chain with the given name, or nil.
+func (t *Table) Chain(name string) *Chain {
+	for _, c := range t.Chains() {
+		if c.Name() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Sets returns the sets and maps of the table.
+func (t *Table) Sets() []*Set {
+	var sets []*Set
+	for _, st := range t.Body {
+		if s, ok := st.(*Set); ok {
+			sets = append(sets, s)
+		}
+	}
+	return sets
+}
+
+// Set returns the set or map with the given name, or nil.
+func (t *Table) Set(name string) *Set {
+	for _, s := range t.Sets() {
+		if s.Name() == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Property returns the tokens following keyword in the first rule of the
+// body starting with it, e.g. Property("policy") on a base chain or
+// Property("type") on a set. ok is false if there is no such rule.
+func (b *Block) Property(keyword string) (args []Token, ok bool) {
+	for _, r := range b.Rules() {
+		if len(r.Tokens) > 0 && r.Tokens[0].Text == keyword {
+			return r.Tokens[1:], true
+		}
+	}
+	return nil, false
+}
+
+// Elements returns the elements of a set, or the "key : value" entries of
+// a map, in their source form.
+func (s *Set) Elements() []string {
+	args, ok := s.Property("elements")
+	if !ok {
+		return nil
+	}
+	var elems []string
+	var cur []Token
+	depth := 0
+	flush := func() {
+		if len(cur) > 0 {
+			elems = append(elems, tokensString(cur))
+		}
+		cur = nil
+	}
+	for _, tok := range args {
+		switch {
+		case tok.Kind == TokenPunct && tok.Text == "{":
+			depth++
+			if depth == 1 {
+				continue
+			}
+		case tok.Kind == TokenPunct && tok.Text == "}":
+			depth--
+			if depth == 0 {
+				flush()
+				continue
+			}
+		case tok.Kind == TokenPunct && tok.Text == "," && depth == 1:
+			flush()
+			continue
+		case depth == 0 || tok.Kind == TokenComment:
+			continue // "=" or a comment between elements
+		}
+		cur = append(cur, tok)
+	}
+	return elems
+}
+
+// String returns the rule on one line, without comments and trailing
+// ";". Whitespace between tokens becomes a single space and tokens that
+// were adjacent stay so, so the rule still reads as written.
+func (r *Rule) String() string {
+	var sb strings.Builder
+	var prev *Token
+	for i := range r.Tokens {
+		tok := &r.Tokens[i]
+		if tok.Kind == TokenComment {
+			continue
+		}
+		if prev != nil && (tok.Space != "" || needsSpace(*prev, *tok)) {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(tok.Text)
+		prev = tok
+	}
+	return sb.String()
+}
+
+// tokensString returns toks in a normalized form: tokens separated by a
+// single space, none before ",". Two statements that only differ in
+// layout have the same tokensString.
+func tokensString(toks []Token) string {
+	var sb strings.Builder
+	for _, tok := range toks {
+		if tok.Kind == TokenComment {
+			continue
+		}
+		if sb.Len() > 0 && tok.Text != "," {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(tok.Text)
+	}
+	return sb.String()
+}
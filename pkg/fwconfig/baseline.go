@@ -0,0 +1,52 @@
+package fwconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Baseline modes, matching the values of the FwLet API.
+const (
+	BaselineFailOpen   = "FailOpen"
+	BaselineFailClosed = "FailClosed"
+	BaselineTemplate   = "Template"
+)
+
+const failOpenRuleset = "flush ruleset\n"
+
+const failClosedRuleset = `flush ruleset
+
+table inet filter {
+    chain INPUT {
+        type filter hook input priority 0; policy drop;
+        iifname "lo" accept;
+        ct state established,related accept;
+    }
+
+    chain FORWARD {
+        type filter hook forward priority 0; policy drop;
+    }
+}
+`
+
+// BaselineRuleset returns the ruleset script for a baseline mode. With
+// BaselineTemplate, name is a file in dir.
+func BaselineRuleset(mode, name, dir string) (string, error) {
+	switch mode {
+	case BaselineFailOpen:
+		return failOpenRuleset, nil
+	case BaselineFailClosed:
+		return failClosedRuleset, nil
+	case BaselineTemplate:
+		if name == "" || filepath.Base(name) != name {
+			return "", fmt.Errorf("Invalid baseline template name: %q", name)
+		}
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return "", fmt.Errorf("Failed to read baseline template: %v", err)
+		}
+		return string(content), nil
+	}
+	return "", fmt.Errorf("Unknown baseline mode: %q", mode)
+}
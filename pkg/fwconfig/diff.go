@@ -0,0 +1,114 @@
+package fwconfig
+
+import "fmt"
+
+// Change is a statement added to or removed from a ruleset.
+type Change struct {
+	// Added is true for a statement only in the new ruleset, false for
+	// one only in the old ruleset.
+	Added bool
+	// Path locates the enclosing block, e.g. "table inet filter chain
+	// INPUT". It is empty for top level statements.
+	Path string
+	// Stmt is the normalized statement; blocks appear as their header.
+	Stmt string
+}
+
+func (c Change) String() string {
+	op := "-"
+	if c.Added {
+		op = "+"
+	}
+	if c.Path == "" {
+		return fmt.Sprintf("%s %s", op, c.Stmt)
+	}
+	return fmt.Sprintf("%s [%s] %s", op, c.Path, c.Stmt)
+}
+
+// Diff compares two rulesets statement by statement, ignoring comments and
+// layout. Within a block, statements are compared in order, so moving a
+// rule shows up as a removal and an addition.
+func Diff(old, new *Ruleset) []Change {
+	var paths []string
+	a, b := map[string][]string{}, map[string][]string{}
+	seen := map[string]bool{}
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	flatten(old.Body, "", a, add)
+	flatten(new.Body, "", b, add)
+
+	var changes []Change
+	for _, path := range paths {
+		changes = append(changes, diffLists(path, a[path], b[path])...)
+	}
+	return changes
+}
+
+func flatten(body []Stmt, path string, out map[string][]string, add func(string)) {
+	add(path)
+	for _, st := range body {
+		var b *Block
+		switch st := st.(type) {
+		case *Rule:
+			out[path] = append(out[path], tokensString(st.Tokens))
+		case *Table:
+			b = &st.Block
+		case *Chain:
+			b = &st.Block
+		case *Set:
+			b = &st.Block
+		case *Block:
+			b = st
+		}
+		if b == nil {
+			continue
+		}
+		header := tokensString(b.Header)
+		out[path] = append(out[path], header)
+		child := header
+		if path != "" {
+			child = path + " " + header
+		}
+		flatten(b.Body, child, out, add)
+	}
+}
+
+// diffLists returns the changes turning a into b along their longest
+// common subsequence.
+func diffLists(path string, a, b []string) []Change {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var changes []Change
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			changes = append(changes, Change{Path: path, Stmt: a[i]})
+			i++
+		default:
+			changes = append(changes, Change{Added: true, Path: path, Stmt: b[j]})
+			j++
+		}
+	}
+	return changes
+}
@@ -2,92 +2,164 @@ package fwconfig
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 
 	// "io/ioutil"
 	"os"
-	"regexp"
 	"sort"
 	"strings"
 )
 
-func RulesReader(filePath string) ([]string, string, []string, error) {
-	// read configutation
-	file, err := os.Open(filePath)
+// RulesReader returns the trusted and untrusted interfaces and the
+// management addresses of the ruleset at filePath, i.e. the arguments of
+//
+//	oifname <name> jump ZONE_TRUST
+//	oifname <name> jump ZONE_UNTRUST
+//	ip saddr <prefix> accept
+//	ip6 saddr <prefix> accept
+//
+// Interfaces are read wherever they appear, management addresses only from
+// the input chain: the chain hooked at input, or the one named INPUT when
+// chains declare no hook. The same rules in other chains are policy, not
+// management access. Names and prefixes may also be given as an anonymous
+// set or as a named set of the same table. Management addresses of both
+// families are returned in the order of the ruleset.
+func RulesReader(filePath string) ([]string, []string, []string, error) {
+	rs, err := ParseFile(filePath)
 	if err != nil {
-		return nil, "", nil, fmt.Errorf("Failed to open file: %v", err)
+		return nil, nil, nil, err
 	}
-	defer file.Close()
 
-	// 正規化パターン
-	ip6Regex := regexp.MustCompile(`^[\s\t]*ip6 saddr ([\w:\/]+) accept`)
-	trustIfRegex := regexp.MustCompile(`^[\s\t]*oifname ([\w-"]+) jump ZONE_TRUST`)
-	untrustIfRegex := regexp.MustCompile(`^[\s\t]*oifname ([\w-"]+) jump ZONE_UNTRUST`)
-
-	scanner := bufio.NewScanner(file)
-	var ipv6Addresses []string
+	var mgmtAddresses []string
 	var trustIf []string
-	var untrustIf string
-
-	// 抽出
-	for scanner.Scan() {
-		line := scanner.Text()
-		matchIP6 := ip6Regex.FindStringSubmatch(line)
-		if matchIP6 != nil {
-			ipv6Addresses = append(ipv6Addresses, matchIP6[1])
+	var untrustIf []string
+	for _, t := range rs.Tables() {
+		for _, c := range t.Chains() {
+			input := isInputChain(c)
+			for _, r := range c.Rules() {
+				if v, ok := matchRule(r.Tokens, "ip saddr", "accept"); ok && input {
+					mgmtAddresses = append(mgmtAddresses, setElements(t, v)...)
+				}
+				if v, ok := matchRule(r.Tokens, "ip6 saddr", "accept"); ok && input {
+					mgmtAddresses = append(mgmtAddresses, setElements(t, v)...)
+				}
+				if v, ok := matchRule(r.Tokens, "oifname", "jump ZONE_TRUST"); ok {
+					trustIf = append(trustIf, v...)
+				}
+				if v, ok := matchRule(r.Tokens, "oifname", "jump ZONE_UNTRUST"); ok {
+					untrustIf = append(untrustIf, v...)
+				}
+			}
 		}
-		matchTrustIf := trustIfRegex.FindStringSubmatch(line)
-		if matchTrustIf != nil {
-			removeQuo := strings.TrimPrefix(strings.TrimSuffix(matchTrustIf[1], "\""), "\"")
-			trustIf = append(trustIf, removeQuo)
+	}
+	return trustIf, untrustIf, mgmtAddresses, nil
+}
+
+// isInputChain tells whether c filters the traffic to the node itself.
+func isInputChain(c *Chain) bool {
+	if hook := c.Hook(); hook != "" {
+		return hook == "input"
+	}
+	return strings.EqualFold(c.Name(), "input")
+}
+
+// setElements replaces references to named sets of t, such as @mgmt_v6,
+// by the elements of the set.
+func setElements(t *Table, values []string) []string {
+	var out []string
+	for _, v := range values {
+		if !strings.HasPrefix(v, "@") {
+			out = append(out, v)
+			continue
 		}
-		matchUntrustIf := untrustIfRegex.FindStringSubmatch(line)
-		if matchUntrustIf != nil {
-			removeQuo := strings.TrimPrefix(strings.TrimSuffix(matchUntrustIf[1], "\""), "\"")
-			untrustIf = removeQuo
+		if s := t.Set(v[1:]); s != nil {
+			out = append(out, s.Elements()...)
 		}
 	}
+	return out
+}
 
-	return trustIf, untrustIf, ipv6Addresses, nil
+// matchRule matches a rule of the form "<prefix> <value> <suffix>" where
+// value is a single token or an anonymous set, and returns the values.
+func matchRule(toks []Token, prefix, suffix string) ([]string, bool) {
+	pre, suf := strings.Fields(prefix), strings.Fields(suffix)
+	if len(toks) < len(pre)+len(suf)+1 {
+		return nil, false
+	}
+	for i, w := range pre {
+		if toks[i].Text != w {
+			return nil, false
+		}
+	}
+	for i, w := range suf {
+		if toks[len(toks)-len(suf)+i].Text != w {
+			return nil, false
+		}
+	}
+	value := toks[len(pre) : len(toks)-len(suf)]
+	if len(value) == 1 && value[0].Kind != TokenPunct {
+		return []string{value[0].Unquote()}, true
+	}
+	if len(value) < 2 || value[0].Text != "{" || value[len(value)-1].Text != "}" {
+		return nil, false
+	}
+	var values []string
+	for i, tok := range value[1 : len(value)-1] {
+		if i%2 == 1 {
+			if tok.Text != "," {
+				return nil, false
+			}
+			continue
+		}
+		if tok.Kind != TokenWord && tok.Kind != TokenString {
+			return nil, false
+		}
+		values = append(values, tok.Unquote())
+	}
+	return values, true
 }
 
+// RuleUpdate renders the marker-based template at tmpPath into filePath.
 func RuleUpdate(containername, tmpPath, filePath, newUntrustIf string, newTrustIf, newMgmtAddr []string) error {
-	templateFilePath := tmpPath // テンプレートファイルのパスを適切に指定してください
-	outputFilePath := filePath                           // 出力ファイルのパスを適切に指定してください
-	ipv6Addresses := newMgmtAddr
-	trustIf := newTrustIf
-	untrustIf := newUntrustIf
-
-	// テンプレートファイルを開く
-	templateFile, err := os.Open(templateFilePath)
-	if err != nil {
-		return fmt.Errorf("Failed to open template file: %s", err)
-	}
-	defer templateFile.Close()
+	return RenderFile(tmpPath, filePath, TemplateMarker, &FirewallModel{
+		Name:             containername,
+		TrustIf:          newTrustIf,
+		UntrustIf:        newUntrustIf,
+		MgmtAddressRange: newMgmtAddr,
+	})
+}
 
-	// 出力ファイルを作成または上書きする
-	outputFile, err := os.Create(outputFilePath)
-	if err != nil {
-		return fmt.Errorf("Failed to create output file: %s", err)
+// renderMarker fills a template using the legacy markers: management
+// addresses are inserted after "#Allowed_Address_PLACE" as ip or ip6 rules
+// by family, and lines containing {TRUST_IF_NAME} / {UNTRUST_IF_NAME} are
+// uncommented once per interface.
+func renderMarker(tmpl string, m *FirewallModel) (string, error) {
+	mgmtAddresses := m.MgmtAddressRange
+	trustIf := m.TrustIf
+	untrustIf := m.untrustIfs()
+	// The markers sit inside quoted strings of the template.
+	for _, name := range append(append([]string{}, trustIf...), untrustIf...) {
+		if err := CheckQuotable(name); err != nil {
+			return "", fmt.Errorf("Invalid interface name: %v", err)
+		}
 	}
-	defer outputFile.Close()
 
-	scanner := bufio.NewScanner(templateFile)
-	writer := bufio.NewWriter(outputFile)
+	var out strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(tmpl))
 	for scanner.Scan() {
 		line := scanner.Text()
-		_, err := writer.WriteString(line + "\n")
-		if err != nil {
-			return fmt.Errorf("Failed to write to output file: %s", err)
-		}
-		// replace v6 address
+		out.WriteString(line + "\n")
+		// replace address
 		if strings.Contains(line, "#Allowed_Address_PLACE") {
-			for _, addr := range ipv6Addresses {
-				newLine := fmt.Sprintf("\t\tip6 saddr %s accept;", addr)
-				_, err := writer.WriteString(newLine + "\n")
+			for _, addr := range mgmtAddresses {
+				family, err := Family(addr)
 				if err != nil {
-					return fmt.Errorf("Failed to write to output file: %s", err)
+					return "", err
 				}
+				newLine := fmt.Sprintf("\t\t%s saddr %s accept;", family, addr)
+				out.WriteString(newLine + "\n")
 			}
 		}
 		// replace trustIf
@@ -96,31 +168,22 @@ func RuleUpdate(containername, tmpPath, filePath, newUntrustIf string, newTrustI
 				for _, tif := range trustIf {
 					newLine := strings.Replace(line, "{TRUST_IF_NAME}", tif, -1)
 					newLine = strings.Replace(newLine, "# ", "", -1)
-					_, err := writer.WriteString(newLine + "\n")
-					if err != nil {
-						return fmt.Errorf("Failed to write to output file: %s", err)
-					}
+					out.WriteString(newLine + "\n")
 				}
-			}else {
-				newLine := strings.Replace(line, "{UNTRUST_IF_NAME}", untrustIf, -1)
-				newLine = strings.Replace(newLine, "# ", "", -1)
-				_, err := writer.WriteString(newLine + "\n")
-				if err != nil {
-					return fmt.Errorf("Failed to write to output file: %s", err)
+			} else {
+				for _, uif := range untrustIf {
+					newLine := strings.Replace(line, "{UNTRUST_IF_NAME}", uif, -1)
+					newLine = strings.Replace(newLine, "# ", "", -1)
+					out.WriteString(newLine + "\n")
 				}
 			}
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("Failed to read template file: %s", err)
-	}
-
-	err = writer.Flush()
-	if err != nil {
-		return fmt.Errorf("Failed to flush writer: %s", err)
+		return "", fmt.Errorf("Failed to read template file: %s", err)
 	}
-	return nil
+	return out.String(), nil
 }
 
 // trust_zoneとuntrust_zoneのupdate
@@ -170,6 +233,25 @@ func UpdateZone(zoneMap map[string]interface{}, trustZone []string, untrustZone
 	return fmt.Errorf("either trust_zone or untrust_zone should be specified")
 }
 
+// HashFile returns the hex encoded sha256 of the file at path, or "" if
+// the file does not exist.
+func HashFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("Failed to read file: %v", err)
+	}
+	return Hash(content), nil
+}
+
+// Hash returns the hex encoded sha256 of content.
+func Hash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
 // 順序を気にせず，スライスの要素の比較をする
 func MatchElements(slice1, slice2 []string) bool {
 	if len(slice1) != len(slice2) {
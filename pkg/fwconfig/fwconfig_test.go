@@ -1,6 +1,8 @@
 package fwconfig
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -13,7 +15,7 @@ func TestRulesReader(t *testing.T) {
 		name    string
 		args    args
 		want    []string
-		want1   string
+		want1   []string
 		want2   []string
 		wantErr bool
 	}{
@@ -21,8 +23,8 @@ func TestRulesReader(t *testing.T) {
 			"case1: read fw.rules",
 			args{"demo.rule"},
 			[]string{"eth-a", "eth-b", "eth-c"},
-			"vsix-bb",
-			nil,
+			[]string{"vsix-bb"},
+			[]string{"203.178.128.0/17"},
 			false,
 		},
 	}
@@ -36,7 +38,7 @@ func TestRulesReader(t *testing.T) {
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("RulesReader() got = %v, want %v", got, tt.want)
 			}
-			if got1 != tt.want1 {
+			if !reflect.DeepEqual(got1, tt.want1) {
 				t.Errorf("RulesReader() got1 = %v, want %v", got1, tt.want1)
 			}
 			if !reflect.DeepEqual(got2, tt.want2) {
@@ -104,6 +106,7 @@ func TestMatchElements(t *testing.T) {
 }
 
 func Test_RuleUpdate(t *testing.T) {
+	outDir := t.TempDir()
 	type args struct {
 		containername string
 		tmpPath       string
@@ -123,7 +126,7 @@ func Test_RuleUpdate(t *testing.T) {
 			args{
 				"container1", 
 				"demo-template.rule", 
-				"demo.rule", 
+				filepath.Join(outDir, "demo.rule"),
 				"vsix-bb",
 				[]string{"eth-a","eth-b"},
 				[]string{"2001:db8:10:20::/64", "2001:db8:10:30::/64"}},
@@ -138,3 +141,51 @@ func Test_RuleUpdate(t *testing.T) {
 		})
 	}
 }
+
+func TestRulesReaderVariants(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fw.rule")
+	content := `table inet filter {
+	chain FORWARD {
+		# oifname "{TRUST_IF_NAME}" jump ZONE_TRUST;
+		oifname { "eth-a", eth-b } jump ZONE_TRUST
+		oifname eth-c jump ZONE_TRUST; oifname "vsix-bb" jump ZONE_UNTRUST # upstream
+		oifname "vsix-ix" jump ZONE_UNTRUST
+	}
+	set mgmt_v4 {
+		type ipv4_addr; flags interval;
+		elements = { 203.0.113.0/24 }
+	}
+	chain INPUT {
+		ip6 saddr { 2001:db8::/32, 2001:db8:1::/48 } accept
+		ip6 saddr 2001:db8:2::/48 tcp dport 22 accept
+		ip saddr { 192.0.2.0/24, 198.51.100.0/24 } accept
+		ip saddr @mgmt_v4 accept
+	}
+	chain TRUST_TO_UNTRUST {
+		# policy rules are not management access
+		ip saddr 192.0.2.99 accept
+		ip6 saddr { 2001:db8:9::/48 } accept
+	}
+	chain LOCAL {
+		type filter hook output priority 0; policy accept;
+		ip saddr 192.0.2.98 accept
+	}
+}
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	trust, untrust, mgmt, err := RulesReader(path)
+	if err != nil {
+		t.Fatalf("RulesReader() error = %v", err)
+	}
+	if want := []string{"eth-a", "eth-b", "eth-c"}; !reflect.DeepEqual(trust, want) {
+		t.Errorf("trustIf = %v, want %v", trust, want)
+	}
+	if want := []string{"vsix-bb", "vsix-ix"}; !reflect.DeepEqual(untrust, want) {
+		t.Errorf("untrustIf = %v, want %v", untrust, want)
+	}
+	if want := []string{"2001:db8::/32", "2001:db8:1::/48", "192.0.2.0/24", "198.51.100.0/24", "203.0.113.0/24"}; !reflect.DeepEqual(mgmt, want) {
+		t.Errorf("mgmt = %v, want %v", mgmt, want)
+	}
+}
@@ -0,0 +1,124 @@
+package fwconfig
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const historySuffix = ".rule"
+
+// History keeps numbered copies of rendered rulesets in Dir.
+// Revisions start at 1 and only ever increase.
+type History struct {
+	Dir string
+	// Limit is the number of revisions kept on disk. 0 keeps everything.
+	Limit int
+}
+
+// Path returns the file holding revision rev.
+func (h *History) Path(rev int64) string {
+	return filepath.Join(h.Dir, fmt.Sprintf("%08d%s", rev, historySuffix))
+}
+
+// Revisions lists the stored revisions in ascending order.
+func (h *History) Revisions() ([]int64, error) {
+	entries, err := os.ReadDir(h.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Failed to read history: %v", err)
+	}
+	var revs []int64
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, historySuffix) {
+			continue
+		}
+		rev, err := strconv.ParseInt(strings.TrimSuffix(name, historySuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		revs = append(revs, rev)
+	}
+	sort.Slice(revs, func(i, j int) bool { return revs[i] < revs[j] })
+	return revs, nil
+}
+
+// Save stores a copy of the ruleset at path as a new revision, prunes
+// revisions beyond Limit and returns the new revision number.
+// Revisions listed in keep are never pruned.
+func (h *History) Save(path string, keep ...int64) (int64, error) {
+	if err := os.MkdirAll(h.Dir, 0755); err != nil {
+		return 0, fmt.Errorf("Failed to create history dir: %v", err)
+	}
+	revs, err := h.Revisions()
+	if err != nil {
+		return 0, err
+	}
+	rev := int64(1)
+	if len(revs) > 0 {
+		rev = revs[len(revs)-1] + 1
+	}
+	if err := copyFile(path, h.Path(rev)); err != nil {
+		return 0, err
+	}
+	revs = append(revs, rev)
+
+	if h.Limit > 0 && len(revs) > h.Limit {
+		for _, old := range revs[:len(revs)-h.Limit] {
+			if containsRevision(keep, old) {
+				continue
+			}
+			if err := os.Remove(h.Path(old)); err != nil && !os.IsNotExist(err) {
+				return rev, fmt.Errorf("Failed to prune revision %d: %v", old, err)
+			}
+		}
+	}
+	return rev, nil
+}
+
+// Restore atomically replaces path with the content of revision rev.
+func (h *History) Restore(rev int64, path string) error {
+	tmp := path + ".restore"
+	if err := copyFile(h.Path(rev), tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("Failed to restore revision %d: %v", rev, err)
+	}
+	return nil
+}
+
+func containsRevision(revs []int64, rev int64) bool {
+	for _, r := range revs {
+		if r == rev {
+			return true
+		}
+	}
+	return false
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("Failed to open %s: %v", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("Failed to create %s: %v", dst, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("Failed to copy %s: %v", src, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,42 @@
+package fwconfig
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	dir := t.TempDir()
+	h := &History{Dir: filepath.Join(dir, "history"), Limit: 2}
+	src := filepath.Join(dir, "fw.rule")
+
+	for i, content := range []string{"rev1", "rev2", "rev3", "rev4"} {
+		if err := os.WriteFile(src, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		rev, err := h.Save(src, 1)
+		if err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		if rev != int64(i+1) {
+			t.Errorf("Save() = %d, want %d", rev, i+1)
+		}
+	}
+
+	revs, err := h.Revisions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{1, 3, 4}; !reflect.DeepEqual(revs, want) {
+		t.Errorf("Revisions() = %v, want %v", revs, want)
+	}
+
+	if err := h.Restore(1, src); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if content, _ := os.ReadFile(src); string(content) != "rev1" {
+		t.Errorf("restored content = %q, want %q", content, "rev1")
+	}
+}
@@ -0,0 +1,114 @@
+package fwconfig
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TokenKind classifies the tokens of the nft ruleset language.
+type TokenKind int
+
+const (
+	TokenWord    TokenKind = iota // keywords, identifiers, addresses, numbers, $vars, @sets
+	TokenString                   // double quoted string, quotes included
+	TokenPunct                    // one of { } ; ,
+	TokenComment                  // # up to the end of the line
+	TokenNewline
+	TokenEOF
+)
+
+// Token is a lexical token. Space holds the whitespace (including escaped
+// newlines) that preceded it, so that printing a parsed ruleset reproduces
+// the source.
+type Token struct {
+	Kind  TokenKind
+	Text  string
+	Space string
+	Line  int
+	Col   int
+}
+
+// Unquote returns the text of a string token without quotes, or the text
+// of any other token unchanged.
+func (t Token) Unquote() string {
+	if t.Kind == TokenString && len(t.Text) >= 2 {
+		return strings.ReplaceAll(t.Text[1:len(t.Text)-1], `\"`, `"`)
+	}
+	return t.Text
+}
+
+const punctChars = "{};,"
+
+// Tokenize splits src into tokens. The last token is always TokenEOF.
+func Tokenize(src string) ([]Token, error) {
+	var toks []Token
+	line, col := 1, 1
+	space := ""
+	i := 0
+	advance := func(n int) {
+		for _, c := range src[i : i+n] {
+			if c == '\n' {
+				line++
+				col = 1
+			} else {
+				col++
+			}
+		}
+		i += n
+	}
+	emit := func(kind TokenKind, n int) {
+		toks = append(toks, Token{Kind: kind, Text: src[i : i+n], Space: space, Line: line, Col: col})
+		space = ""
+		advance(n)
+	}
+
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			space += string(c)
+			advance(1)
+		case c == '\\' && i+1 < len(src) && src[i+1] == '\n':
+			space += src[i : i+2]
+			advance(2)
+		case c == '\n':
+			emit(TokenNewline, 1)
+		case c == '#':
+			n := strings.IndexByte(src[i:], '\n')
+			if n < 0 {
+				n = len(src) - i
+			}
+			emit(TokenComment, n)
+		case c == '"':
+			n := 1
+			for ; i+n < len(src); n++ {
+				if src[i+n] == '\\' && i+n+1 < len(src) {
+					n++
+					continue
+				}
+				if src[i+n] == '"' || src[i+n] == '\n' {
+					break
+				}
+			}
+			if i+n >= len(src) || src[i+n] != '"' {
+				return nil, fmt.Errorf("%d:%d: unterminated string", line, col)
+			}
+			emit(TokenString, n+1)
+		case strings.IndexByte(punctChars, c) >= 0:
+			emit(TokenPunct, 1)
+		default:
+			n := 0
+			for i+n < len(src) && !isWordBreak(src[i+n]) {
+				n++
+			}
+			emit(TokenWord, n)
+		}
+	}
+	toks = append(toks, Token{Kind: TokenEOF, Space: space, Line: line, Col: col})
+	return toks, nil
+}
+
+func isWordBreak(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '#' || c == '"' ||
+		strings.IndexByte(punctChars, c) >= 0
+}
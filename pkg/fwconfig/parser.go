@@ -0,0 +1,217 @@
+package fwconfig
+
+import (
+	"fmt"
+	"os"
+)
+
+type level int
+
+const (
+	levelTop    level = iota // commands and tables
+	levelTable               // chains, sets, maps and other objects
+	levelObject              // rules and properties; braces are literals
+)
+
+type parser struct {
+	toks []Token
+	pos  int
+}
+
+// Parse parses an nft ruleset. Printing the result with Print gives src
+// back unchanged.
+func Parse(src string) (*Ruleset, error) {
+	toks, err := Tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	body, lead, err := p.parseBody(levelTop)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.Kind != TokenEOF {
+		return nil, fmt.Errorf("%d:%d: unexpected %q", tok.Line, tok.Col, tok.Text)
+	}
+	return &Ruleset{Body: body, Trail: &lead}, nil
+}
+
+// ParseFile parses the ruleset file at path.
+func ParseFile(path string) (*Ruleset, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read file: %v", err)
+	}
+	rs, err := Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %v", path, err)
+	}
+	return rs, nil
+}
+
+// ParseRule parses a single rule such as `ip6 saddr 2001:db8::/32 accept`.
+func ParseRule(src string) (*Rule, error) {
+	toks, err := Tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	lead := p.skipSpace()
+	r, err := p.parseRule(lead)
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if tok := p.peek(); tok.Kind != TokenEOF {
+		return nil, fmt.Errorf("%d:%d: unexpected %q after rule", tok.Line, tok.Col, tok.Text)
+	}
+	r.Lead = nil
+	return r, nil
+}
+
+func (p *parser) peek() Token { return p.toks[p.pos] }
+
+func (p *parser) next() Token {
+	tok := p.toks[p.pos]
+	if tok.Kind != TokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// skipSpace consumes newlines and returns them with the surrounding
+// whitespace. The whitespace before the next token is included.
+func (p *parser) skipSpace() string {
+	lead := ""
+	for p.peek().Kind == TokenNewline {
+		lead += p.next().Space + "\n"
+	}
+	return lead + p.peek().Space
+}
+
+// parseBody parses statements up to a closing brace or EOF, which is left
+// unconsumed. It returns the whitespace in front of it.
+func (p *parser) parseBody(lv level) ([]Stmt, string, error) {
+	var body []Stmt
+	for {
+		lead := p.skipSpace()
+		tok := p.peek()
+		switch {
+		case tok.Kind == TokenEOF:
+			return body, lead, nil
+		case tok.Kind == TokenPunct && tok.Text == "}":
+			if lv == levelTop {
+				return nil, "", fmt.Errorf("%d:%d: unexpected \"}\"", tok.Line, tok.Col)
+			}
+			return body, lead, nil
+		case tok.Kind == TokenComment:
+			p.next()
+			body = append(body, &Comment{Lead: &lead, Text: tok.Text})
+		default:
+			st, err := p.parseStmt(lv, lead)
+			if err != nil {
+				return nil, "", err
+			}
+			body = append(body, st)
+		}
+	}
+}
+
+// opensBlock tells whether a "{" after header starts a block body rather
+// than an anonymous set, map or element list.
+func opensBlock(lv level, header []Token) bool {
+	switch lv {
+	case levelTop:
+		return len(header) > 0 && header[0].Text == "table"
+	case levelTable:
+		return len(header) > 0
+	}
+	return false
+}
+
+func (p *parser) parseStmt(lv level, lead string) (Stmt, error) {
+	var header []Token
+	for {
+		tok := p.peek()
+		if tok.Kind == TokenPunct && tok.Text == "{" && opensBlock(lv, header) {
+			break
+		}
+		if tok.Kind != TokenWord && tok.Kind != TokenString {
+			// Not a block: parse it again as a rule.
+			p.pos -= len(header)
+			return p.parseRule(lead)
+		}
+		header = append(header, p.next())
+	}
+	header[0].Space = ""
+	open := p.next()
+
+	child := levelObject
+	if lv == levelTop {
+		child = levelTable
+	}
+	body, closeLead, err := p.parseBody(child)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.next(); tok.Kind != TokenPunct || tok.Text != "}" {
+		return nil, fmt.Errorf("%d:%d: missing \"}\" for block opened at %d:%d", tok.Line, tok.Col, open.Line, open.Col)
+	}
+	b := Block{Lead: &lead, Header: header, Open: &open.Space, Body: body, Close: &closeLead}
+	switch {
+	case lv == levelTop:
+		return &Table{b}, nil
+	case header[0].Text == "chain" && len(header) == 2:
+		return &Chain{b}, nil
+	case (header[0].Text == "set" || header[0].Text == "map") && len(header) == 2:
+		return &Set{b}, nil
+	}
+	return &b, nil
+}
+
+func (p *parser) parseRule(lead string) (*Rule, error) {
+	r := &Rule{Lead: &lead}
+	depth := 0
+	var open Token
+	for {
+		tok := p.peek()
+		switch {
+		case tok.Kind == TokenEOF:
+			if depth > 0 {
+				return nil, fmt.Errorf("%d:%d: missing \"}\" for \"{\" at %d:%d", tok.Line, tok.Col, open.Line, open.Col)
+			}
+			return r, nil
+		case tok.Kind == TokenPunct && tok.Text == "{":
+			if depth == 0 {
+				open = tok
+			}
+			depth++
+		case tok.Kind == TokenPunct && tok.Text == "}":
+			if depth == 0 {
+				return r, nil
+			}
+			depth--
+		case tok.Kind == TokenPunct && tok.Text == ";" && depth == 0:
+			p.next()
+			r.Semi, r.SemiSpace = true, tok.Space
+			if len(r.Tokens) == 0 {
+				// The space before an empty statement is its lead.
+				r.SemiSpace = ""
+			}
+			return r, nil
+		case tok.Kind == TokenNewline && depth > 0:
+			// Anonymous sets may span lines; keep the newline as spacing
+			// of the following token.
+			p.next()
+			p.toks[p.pos].Space = tok.Space + "\n" + p.toks[p.pos].Space
+			continue
+		case tok.Kind == TokenNewline || tok.Kind == TokenComment && depth == 0:
+			return r, nil
+		}
+		t := p.next()
+		if len(r.Tokens) == 0 {
+			t.Space = ""
+		}
+		r.Tokens = append(r.Tokens, t)
+	}
+}
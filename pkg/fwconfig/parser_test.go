@@ -0,0 +1,255 @@
+package fwconfig
+
+import (
+	"math/rand"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const complexRuleset = `#!/usr/sbin/nft -f
+define mgmt = { 2001:db8::/32, 2001:db8:1::/48 }
+flush ruleset
+
+table inet filter {
+	set trusted_ifs {
+		type ifname; comment "trusted \"zone\"";
+		elements = { "eth-a", "eth-b" }
+	}
+	map verdicts {
+		type ipv4_addr : verdict
+		elements = { 10.0.0.1 : accept,
+			10.0.0.2 : drop }
+	}
+	flowtable ft {
+		hook ingress priority 0; devices = { eth-a, eth-b }
+	}
+	chain INPUT { type filter hook input priority 0; policy drop;
+		iifname lo accept
+		tcp dport {
+			22, # ssh
+			443
+		} ct state new accept # inline
+		ip6 saddr $mgmt accept;ct state established,related accept
+	}
+}
+table ip nat{
+	chain POSTROUTING {
+		type nat hook postrouting priority 100 ; policy accept ;
+		oifname "vsix-bb" masquerade
+	}
+}
+add rule inet filter INPUT counter \
+	drop
+`
+
+func TestParseRoundTrip(t *testing.T) {
+	sources := map[string]string{"complex": complexRuleset, "empty": "", "no newline": "flush ruleset"}
+	for _, path := range []string{"../../fw/fw-template.rule", "demo.rule", "demo-template.rule"} {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources[path] = string(content)
+	}
+	for name, src := range sources {
+		t.Run(name, func(t *testing.T) {
+			rs, err := Parse(src)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := Print(rs); got != src {
+				t.Errorf("Print() = %q, want %q", got, src)
+			}
+		})
+	}
+}
+
+// roundTripFragments are pieces of rulesets that random sources are made
+// of, covering blocks, anonymous sets, comments, line continuations and
+// empty statements.
+var roundTripFragments = []string{
+	"table", "inet", "filter", "chain", "INPUT", "set", "s", "type", "ipv4_addr",
+	"ct", "state", "established", "related", "accept", "1.2.3.4/24", `"eth-a"`,
+	"{", "}", ";", ",", "\n", " ", "\t", "  ", "# comment\n", "\\\n",
+}
+
+func TestParsePrintProperty(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	sources := []string{"\n\t;", "a;;b", "table t {\n\t;\n}\n", "ct state established,related accept"}
+	for i := 0; i < 20000; i++ {
+		var sb strings.Builder
+		for n := rnd.Intn(40); n > 0; n-- {
+			sb.WriteString(roundTripFragments[rnd.Intn(len(roundTripFragments))])
+		}
+		sources = append(sources, sb.String())
+	}
+	parsed := 0
+	for _, src := range sources {
+		rs, err := Parse(src)
+		if err != nil {
+			continue
+		}
+		parsed++
+		if got := Print(rs); got != src {
+			t.Fatalf("Print(Parse(%q)) = %q", src, got)
+		}
+	}
+	if parsed < 1000 {
+		t.Errorf("only %d sources parsed", parsed)
+	}
+}
+
+func TestRuleString(t *testing.T) {
+	for src, want := range map[string]string{
+		"ct state established,related accept":       "ct state established,related accept",
+		"tcp dport {\n\t22, # ssh\n\t443\n} accept": "tcp dport { 22, 443 } accept",
+		"ip  saddr\t1.2.3.4 \\\n\tdrop":             "ip saddr 1.2.3.4 drop",
+	} {
+		r, err := ParseRule(src)
+		if err != nil {
+			t.Fatalf("ParseRule(%q) error = %v", src, err)
+		}
+		if got := r.String(); got != want {
+			t.Errorf("String() = %q, want %q", got, want)
+		}
+		// The string is a rule of its own with the same tokens.
+		again, err := ParseRule(r.String())
+		if err != nil || again.String() != r.String() {
+			t.Errorf("ParseRule(%q) = %v, %v", r.String(), again, err)
+		}
+	}
+}
+
+func TestParseAST(t *testing.T) {
+	rs, err := Parse(complexRuleset)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if n := len(rs.Tables()); n != 2 {
+		t.Fatalf("len(Tables()) = %d, want 2", n)
+	}
+	filter := rs.Table("inet", "filter")
+	if filter == nil {
+		t.Fatal("table inet filter not found")
+	}
+	if nat := rs.Table("ip", "nat"); nat == nil || nat.Chain("POSTROUTING") == nil {
+		t.Error("chain POSTROUTING of table ip nat not found")
+	}
+
+	set := filter.Set("trusted_ifs")
+	if set == nil || set.IsMap() {
+		t.Fatalf("set trusted_ifs not found")
+	}
+	if got, want := set.Elements(), []string{`"eth-a"`, `"eth-b"`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Elements() = %q, want %q", got, want)
+	}
+	m := filter.Set("verdicts")
+	if m == nil || !m.IsMap() {
+		t.Fatalf("map verdicts not found")
+	}
+	if got, want := m.Elements(), []string{"10.0.0.1 : accept", "10.0.0.2 : drop"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Elements() = %q, want %q", got, want)
+	}
+	if _, ok := filter.Body[2].(*Block); !ok {
+		t.Errorf("flowtable parsed as %T, want *Block", filter.Body[2])
+	}
+
+	input := filter.Chain("INPUT")
+	if input == nil {
+		t.Fatal("chain INPUT not found")
+	}
+	if policy, _ := input.Property("policy"); len(policy) != 1 || policy[0].Text != "drop" {
+		t.Errorf("Property(policy) = %v", policy)
+	}
+	var rules []string
+	for _, r := range input.Rules() {
+		rules = append(rules, r.String())
+	}
+	want := []string{
+		"type filter hook input priority 0",
+		"policy drop",
+		"iifname lo accept",
+		"tcp dport { 22, 443 } ct state new accept",
+		"ip6 saddr $mgmt accept",
+		"ct state established,related accept",
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("rules = %q, want %q", rules, want)
+	}
+	var inline int
+	for _, st := range input.Body {
+		if c, ok := st.(*Comment); ok && c.Inline() {
+			inline++
+		}
+	}
+	if inline != 1 {
+		t.Errorf("inline comments = %d, want 1", inline)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for name, src := range map[string]string{
+		"unterminated string": `table inet filter { chain x { oifname "eth-a accept } }`,
+		"unclosed table":      "table inet filter {\n\tchain x {\n\t}\n",
+		"unclosed set":        "table inet filter { chain x { tcp dport { 22, 80 accept } }",
+		"stray brace":         "flush ruleset\n}\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse(src); err == nil {
+				t.Errorf("Parse(%q) succeeded", src)
+			}
+		})
+	}
+}
+
+func TestPrintBuiltRuleset(t *testing.T) {
+	rs, err := Parse("table inet filter {\n\tchain FORWARD {\n\t\ttype filter hook forward priority 0; policy accept;\n\t}\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, err := ParseRule(`oifname "eth-a" jump ZONE_TRUST`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fwd := rs.Table("inet", "filter").Chain("FORWARD")
+	fwd.Body = append(fwd.Body, &Comment{Text: "# trust"}, rule)
+	rs.Table("inet", "filter").Body = append(rs.Table("inet", "filter").Body, &Chain{Block{
+		Header: []Token{{Kind: TokenWord, Text: "chain"}, {Kind: TokenWord, Text: "ZONE_TRUST"}},
+		Body:   []Stmt{&Rule{Tokens: []Token{{Kind: TokenWord, Text: "accept"}}}},
+	}})
+
+	want := "table inet filter {\n\tchain FORWARD {\n\t\ttype filter hook forward priority 0; policy accept;\n" +
+		"\t\t# trust\n\t\toifname \"eth-a\" jump ZONE_TRUST\n\t}\n" +
+		"\tchain ZONE_TRUST {\n\t\taccept\n\t}\n}\n"
+	if got := Print(rs); got != want {
+		t.Errorf("Print() = %q, want %q", got, want)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old, err := Parse("table inet filter {\n\tchain INPUT {\n\t\tiifname lo accept\n\t\tip6 saddr 2001:db8::/32 accept\n\t}\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	new, err := Parse("# reformatted\ntable inet filter {\n  chain INPUT {\n    iifname lo   accept;\n    ip6 saddr 2001:db8:1::/48 accept\n  }\n  chain OUTPUT {\n  }\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range Diff(old, new) {
+		got = append(got, c.String())
+	}
+	want := []string{
+		"+ [table inet filter] chain OUTPUT",
+		"- [table inet filter chain INPUT] ip6 saddr 2001:db8::/32 accept",
+		"+ [table inet filter chain INPUT] ip6 saddr 2001:db8:1::/48 accept",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %q, want %q", got, want)
+	}
+	if changes := Diff(old, old); len(changes) != 0 {
+		t.Errorf("Diff() of identical rulesets = %v", changes)
+	}
+}
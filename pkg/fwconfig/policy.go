@@ -0,0 +1,166 @@
+package fwconfig
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Policy rule actions.
+const (
+	ActionAccept = "accept"
+	ActionDrop   = "drop"
+	ActionReject = "reject"
+	ActionLog    = "log"
+)
+
+// PolicyRule is a rule for traffic from one zone to another. Rules are
+// rendered in order into the PAIR_<from>_to_<to> chain.
+type PolicyRule struct {
+	// Name is kept as the rule's comment and log prefix.
+	Name        string
+	From        string
+	To          string
+	Source      []string
+	Destination []string
+	// Protocol is tcp, udp, sctp, icmp, icmpv6 or empty for any.
+	Protocol string
+	// Ports are destination ports or ranges such as "8000-8080".
+	Ports []string
+	// ICMPTypes are icmp or icmpv6 type names such as "echo-request".
+	ICMPTypes []string
+	// Services are matched besides Protocol, Ports and ICMPTypes, if set.
+	// The rule is rendered once per protocol.
+	Services []Service
+	Action   string
+}
+
+// MaxRuleNameLength keeps a rule name within nft's limits: the log prefix,
+// the name and ": ", holds 127 bytes and a comment 128.
+const MaxRuleNameLength = 125
+
+// CheckRuleName checks that name, written into the comment and log prefix
+// of a rule, only holds letters, digits, '-', '_' and '.'.
+func CheckRuleName(name string) error {
+	if len(name) > MaxRuleNameLength {
+		return fmt.Errorf("Rule name %s is longer than %d bytes", strconv.Quote(name), MaxRuleNameLength)
+	}
+	for _, c := range name {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return fmt.Errorf("Rule name %s may only hold letters, digits, '-', '_' and '.'", strconv.Quote(name))
+		}
+	}
+	return nil
+}
+
+// Chain returns the name of the chain the rule is rendered into.
+func (r *PolicyRule) Chain() string {
+	return PairChain(r.From, r.To)
+}
+
+// PairChain returns the name of the chain holding the policy from one zone
+// to another.
+func PairChain(from, to string) string {
+	return fmt.Sprintf("PAIR_%s_to_%s", from, to)
+}
+
+// Statements renders r as nft rule statements. Prefixes of both families
+// yield one statement per family, and services one per protocol.
+func (r *PolicyRule) Statements() ([]string, error) {
+	if err := CheckRuleName(r.Name); err != nil {
+		return nil, err
+	}
+	var verdict string
+	switch r.Action {
+	case ActionAccept, ActionDrop, ActionReject:
+		verdict = r.Action
+	case ActionLog:
+		prefix := r.Name
+		if prefix == "" {
+			prefix = r.Chain()
+		}
+		quoted, err := Quote(prefix + ": ")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid log prefix of rule %s: %v", strconv.Quote(r.Name), err)
+		}
+		verdict = "log prefix " + quoted
+	default:
+		return nil, fmt.Errorf("Unknown action %q in rule %q", r.Action, r.Name)
+	}
+	if r.Name != "" {
+		quoted, err := Quote(r.Name)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid rule name: %v", err)
+		}
+		verdict += " comment " + quoted
+	}
+
+	matches, err := r.matches()
+	if err != nil {
+		return nil, err
+	}
+	// statements joins the address match with every service match.
+	statements := func(addrs string) []string {
+		var stmts []string
+		for _, m := range matches {
+			var parts []string
+			for _, p := range []string{addrs, m, verdict} {
+				if p != "" {
+					parts = append(parts, p)
+				}
+			}
+			stmts = append(stmts, strings.Join(parts, " "))
+		}
+		return stmts
+	}
+
+	if len(r.Source) == 0 && len(r.Destination) == 0 {
+		return statements(""), nil
+	}
+	var stmts []string
+	for _, family := range []string{"ip", "ip6"} {
+		src, err := filterFamily(r.Source, family)
+		if err != nil {
+			return nil, err
+		}
+		dst, err := filterFamily(r.Destination, family)
+		if err != nil {
+			return nil, err
+		}
+		if len(src) == 0 && len(r.Source) > 0 || len(dst) == 0 && len(r.Destination) > 0 {
+			continue
+		}
+		var addrs []string
+		if len(src) > 0 {
+			addrs = append(addrs, fmt.Sprintf("%s saddr %s", family, setLiteral(src, identity)))
+		}
+		if len(dst) > 0 {
+			addrs = append(addrs, fmt.Sprintf("%s daddr %s", family, setLiteral(dst, identity)))
+		}
+		stmts = append(stmts, statements(strings.Join(addrs, " "))...)
+	}
+	if len(stmts) == 0 {
+		return nil, fmt.Errorf("Rule %q mixes IPv4 and IPv6 source and destination prefixes", r.Name)
+	}
+	return stmts, nil
+}
+
+// matches returns the protocol matches of r, "" matching any protocol.
+func (r *PolicyRule) matches() ([]string, error) {
+	services := r.Services
+	if r.Protocol != "" || len(r.Ports) > 0 || len(r.ICMPTypes) > 0 || len(r.Services) == 0 {
+		own := Service{Protocol: r.Protocol, Ports: r.Ports, ICMPTypes: r.ICMPTypes}
+		services = append([]Service{own}, r.Services...)
+	}
+	var matches []string
+	for _, s := range mergeServices(services) {
+		m, err := s.match(r.Name)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, m)
+	}
+	return matches, nil
+}
+
+func identity(s string) string { return s }
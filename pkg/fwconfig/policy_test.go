@@ -0,0 +1,202 @@
+package fwconfig
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPolicyRuleStatements(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    PolicyRule
+		want    []string
+		wantErr bool
+	}{
+		{
+			"ports",
+			PolicyRule{Name: "web", Protocol: "tcp", Ports: []string{"80", "443"}, Action: ActionAccept},
+			[]string{`tcp dport { 80, 443 } accept comment "web"`},
+			false,
+		},
+		{
+			"dual stack source",
+			PolicyRule{Source: []string{"192.0.2.0/24", "2001:db8::/32"}, Protocol: "udp", Ports: []string{"53"}, Action: ActionAccept},
+			[]string{"ip saddr 192.0.2.0/24 udp dport 53 accept", "ip6 saddr 2001:db8::/32 udp dport 53 accept"},
+			false,
+		},
+		{
+			"source and destination of one family",
+			PolicyRule{Source: []string{"2001:db8::/32"}, Destination: []string{"192.0.2.1", "2001:db8:1::1"}, Action: ActionDrop},
+			[]string{"ip6 saddr 2001:db8::/32 ip6 daddr 2001:db8:1::1 drop"},
+			false,
+		},
+		{
+			"icmp types",
+			PolicyRule{Protocol: "icmpv6", ICMPTypes: []string{"echo-request"}, Action: ActionReject},
+			[]string{"icmpv6 type echo-request reject"},
+			false,
+		},
+		{
+			"protocol only",
+			PolicyRule{Protocol: "sctp", Action: ActionDrop},
+			[]string{"meta l4proto sctp drop"},
+			false,
+		},
+		{
+			"log",
+			PolicyRule{Name: "ssh", From: "untrust", To: "trust", Protocol: "tcp", Ports: []string{"22"}, Action: ActionLog},
+			[]string{`tcp dport 22 log prefix "ssh: " comment "ssh"`},
+			false,
+		},
+		{
+			"services",
+			PolicyRule{Services: []Service{{"tcp", []string{"22"}, nil}, {"udp", []string{"161"}, nil}, {"tcp", []string{"179", "22"}, nil}}, Action: ActionAccept},
+			[]string{"tcp dport { 22, 179 } accept", "udp dport 161 accept"},
+			false,
+		},
+		{
+			"services and own protocol",
+			PolicyRule{Source: []string{"192.0.2.0/24", "2001:db8::/32"}, Protocol: "icmp", Services: []Service{{Protocol: "tcp", Ports: []string{"22"}}}, Action: ActionAccept},
+			[]string{
+				"ip saddr 192.0.2.0/24 meta l4proto icmp accept", "ip saddr 192.0.2.0/24 tcp dport 22 accept",
+				"ip6 saddr 2001:db8::/32 meta l4proto icmp accept", "ip6 saddr 2001:db8::/32 tcp dport 22 accept",
+			},
+			false,
+		},
+		{
+			"service of a whole protocol",
+			PolicyRule{Services: []Service{{"tcp", []string{"22"}, nil}, {Protocol: "tcp"}, {"tcp", []string{"80"}, nil}}, Action: ActionDrop},
+			[]string{"meta l4proto tcp drop"},
+			false,
+		},
+		{"ports without protocol", PolicyRule{Ports: []string{"22"}, Action: ActionAccept}, nil, true},
+		{"ports without protocol besides services", PolicyRule{Ports: []string{"22"}, Services: []Service{{Protocol: "udp"}}, Action: ActionAccept}, nil, true},
+		{"service ports for icmp", PolicyRule{Services: []Service{{"icmp", []string{"22"}, nil}}, Action: ActionAccept}, nil, true},
+		{"ports for icmp", PolicyRule{Protocol: "icmp", Ports: []string{"22"}, Action: ActionAccept}, nil, true},
+		{"unknown action", PolicyRule{Action: "allow"}, nil, true},
+		{"invalid prefix", PolicyRule{Source: []string{"2001:db8::/129"}, Action: ActionAccept}, nil, true},
+		{"mixed families", PolicyRule{Source: []string{"192.0.2.0/24"}, Destination: []string{"2001:db8::1"}, Action: ActionAccept}, nil, true},
+		{"quote in name", PolicyRule{Name: `a" ; flush ruleset ; #`, Action: ActionAccept}, nil, true},
+		{"space in name", PolicyRule{Name: "allow ssh", Action: ActionAccept}, nil, true},
+		{"long name", PolicyRule{Name: strings.Repeat("a", MaxRuleNameLength+1), Action: ActionAccept}, nil, true},
+		{"unknown icmp type", PolicyRule{Protocol: "icmp", ICMPTypes: []string{"echo-request ; flush ruleset"}, Action: ActionAccept}, nil, true},
+		{"icmpv6 type for icmp", PolicyRule{Protocol: "icmp", ICMPTypes: []string{"nd-neighbor-solicit"}, Action: ActionAccept}, nil, true},
+		{"unknown service icmp type", PolicyRule{Services: []Service{{"icmpv6", nil, []string{"ping"}}}, Action: ActionAccept}, nil, true},
+		{"quote in log prefix", PolicyRule{Name: `a"`, Action: ActionLog}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.rule.Statements()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Statements() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Statements() = %q, want %q", got, tt.want)
+			}
+			for _, stmt := range got {
+				if _, err := ParseRule(stmt); err != nil {
+					t.Errorf("ParseRule(%q) error = %v", stmt, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderPairRules(t *testing.T) {
+	m := &FirewallModel{
+		TrustIf:   []string{"eth-a"},
+		UntrustIf: "vsix-bb",
+		Rules: []PolicyRule{
+			{Name: "ssh", From: "untrust", To: "trust", Protocol: "tcp", Ports: []string{"22"}, Action: ActionAccept},
+			{Name: "smtp", From: "trust", To: "untrust", Protocol: "tcp", Ports: []string{"25"}, Action: ActionReject},
+			{Name: "web", From: "untrust", To: "trust", Protocol: "tcp", Ports: []string{"80"}, Action: ActionAccept},
+		},
+	}
+	tmpl, err := os.ReadFile("../../fw/fw-template.rule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered, err := Render(string(tmpl), TemplateAuto, m)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	rs, err := Parse(rendered)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	filter := rs.Table("inet", "filter")
+	var u2t []string
+	for _, r := range filter.Chain(PairChain("untrust", "trust")).Rules() {
+		u2t = append(u2t, r.String())
+	}
+	ssh, web, drop := index(u2t, `tcp dport 22 accept comment "ssh"`), index(u2t, `tcp dport 80 accept comment "web"`), index(u2t, "drop")
+	if ssh < 0 || web < ssh || drop < web {
+		t.Errorf("rules of %s out of order: %q", PairChain("untrust", "trust"), u2t)
+	}
+	var t2u []string
+	for _, r := range filter.Chain(PairChain("trust", "untrust")).Rules() {
+		t2u = append(t2u, r.String())
+	}
+	if want := `tcp dport 25 reject comment "smtp"`; index(t2u, want) < 0 || t2u[len(t2u)-1] != "accept" {
+		t.Errorf("rules of %s = %q, want %q followed by the default accept", PairChain("trust", "untrust"), t2u, want)
+	}
+
+	marker, err := os.ReadFile("demo-template.rule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Render(string(marker), TemplateAuto, m); err == nil || !strings.Contains(err.Error(), "Marker") {
+		t.Errorf("Render() of a marker template with rules error = %v", err)
+	}
+}
+
+func index(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestRenderICMPRulesBeforeICMPPass(t *testing.T) {
+	m := &FirewallModel{
+		TrustIf:   []string{"eth-a"},
+		UntrustIf: "vsix-bb",
+		Rules: []PolicyRule{
+			{Name: "no-ping", From: "untrust", To: "trust", Protocol: "icmp", ICMPTypes: []string{"echo-request"}, Action: ActionDrop},
+			{Name: "no-ping6", From: "untrust", To: "trust", Protocol: "icmpv6", ICMPTypes: []string{"echo-request"}, Action: ActionDrop},
+		},
+	}
+	tmpl, err := os.ReadFile("../../fw/fw-template.rule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered, err := Render(string(tmpl), TemplateAuto, m)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	rs, err := Parse(rendered)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	var u2t []string
+	for _, r := range rs.Table("inet", "filter").Chain(PairChain("untrust", "trust")).Rules() {
+		u2t = append(u2t, r.String())
+	}
+	ping, ping6 := -1, -1
+	for i, r := range u2t {
+		switch {
+		case strings.HasSuffix(r, `drop comment "no-ping"`):
+			ping = i
+		case strings.HasSuffix(r, `drop comment "no-ping6"`):
+			ping6 = i
+		}
+	}
+	icmp, icmpv6 := index(u2t, "ip protocol icmp return"), index(u2t, "ip6 nexthdr icmpv6 return")
+	if ping < 0 || ping6 < 0 || icmp < 0 || icmpv6 < 0 || ping > icmp || ping > icmpv6 || ping6 > icmp || ping6 > icmpv6 {
+		t.Errorf("icmp rules of %s not ahead of the icmp pass: %q", PairChain("untrust", "trust"), u2t)
+	}
+}
@@ -0,0 +1,90 @@
+package fwconfig
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Print renders rs as nft syntax. A ruleset returned by Parse prints back
+// exactly as it was read.
+func Print(rs *Ruleset) string {
+	var sb strings.Builder
+	printBody(&sb, rs.Body, 0)
+	if rs.Trail != nil {
+		sb.WriteString(*rs.Trail)
+	} else {
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// WriteFile prints rs to path.
+func WriteFile(rs *Ruleset, path string) error {
+	if err := os.WriteFile(path, []byte(Print(rs)), 0644); err != nil {
+		return fmt.Errorf("Failed to write file: %v", err)
+	}
+	return nil
+}
+
+func printBody(sb *strings.Builder, body []Stmt, depth int) {
+	for i, st := range body {
+		if lead := st.lead(); lead != nil {
+			sb.WriteString(*lead)
+		} else if depth > 0 || i > 0 {
+			sb.WriteString("\n" + strings.Repeat("\t", depth))
+		}
+		switch st := st.(type) {
+		case *Comment:
+			sb.WriteString(st.Text)
+		case *Rule:
+			printTokens(sb, st.Tokens)
+			if st.Semi {
+				sb.WriteString(st.SemiSpace + ";")
+			}
+		case *Table:
+			printBlock(sb, &st.Block, depth)
+		case *Chain:
+			printBlock(sb, &st.Block, depth)
+		case *Set:
+			printBlock(sb, &st.Block, depth)
+		case *Block:
+			printBlock(sb, st, depth)
+		}
+	}
+}
+
+func printBlock(sb *strings.Builder, b *Block, depth int) {
+	printTokens(sb, b.Header)
+	if b.Open != nil {
+		sb.WriteString(*b.Open)
+	} else {
+		sb.WriteString(" ")
+	}
+	sb.WriteString("{")
+	printBody(sb, b.Body, depth+1)
+	if b.Close != nil {
+		sb.WriteString(*b.Close)
+	} else {
+		sb.WriteString("\n" + strings.Repeat("\t", depth))
+	}
+	sb.WriteString("}")
+}
+
+func printTokens(sb *strings.Builder, toks []Token) {
+	for i, tok := range toks {
+		if i > 0 {
+			if tok.Space == "" && needsSpace(toks[i-1], tok) {
+				sb.WriteString(" ")
+			}
+			sb.WriteString(tok.Space)
+		}
+		sb.WriteString(tok.Text)
+	}
+}
+
+// needsSpace tells whether two adjacent words built in code would merge
+// into one if printed without a space. The lexer never produces such a pair.
+func needsSpace(prev, tok Token) bool {
+	return prev.Kind == TokenWord && tok.Kind == TokenWord
+}
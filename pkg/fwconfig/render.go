@@ -0,0 +1,237 @@
+package fwconfig
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// Template modes.
+const (
+	// TemplateAuto picks TemplateMarker for templates that contain one of
+	// the legacy markers and TemplateGo otherwise.
+	TemplateAuto   = "auto"
+	TemplateGo     = "go"
+	TemplateMarker = "marker"
+)
+
+// FirewallModel is the data a ruleset template is rendered with.
+type FirewallModel struct {
+	// Name is the name of the FwLet.
+	Name    string
+	TrustIf []string
+	// UntrustIf is the first of UntrustIfs, for templates written for a
+	// single upstream interface.
+	UntrustIf        string
+	UntrustIfs       []string
+	MgmtAddressRange []string
+	// ICMPRateLimit is the rate at which the INPUT chain accepts ICMP.
+	// Empty means DefaultICMPRateLimit.
+	ICMPRateLimit string
+	// Zones are named zones besides trust and untrust.
+	Zones []Zone
+	// Policies set the default verdict of zone pairs.
+	Policies []ZonePolicy
+	// Rules are the zone pair policies, in order.
+	Rules []PolicyRule
+	// ServiceGroups are the service groups the rules reference.
+	ServiceGroups []ServiceGroup
+}
+
+// DefaultICMPRateLimit is the ICMP rate of models that set none.
+const DefaultICMPRateLimit = "10/second"
+
+// ICMPRate returns ICMPRateLimit, or DefaultICMPRateLimit if it is empty.
+func (m *FirewallModel) ICMPRate() string {
+	if m.ICMPRateLimit == "" {
+		return DefaultICMPRateLimit
+	}
+	return m.ICMPRateLimit
+}
+
+// untrustIfs returns UntrustIfs, or UntrustIf for models that only set it.
+func (m *FirewallModel) untrustIfs() []string {
+	if len(m.UntrustIfs) == 0 && m.UntrustIf != "" {
+		return []string{m.UntrustIf}
+	}
+	return m.UntrustIfs
+}
+
+// PairRules renders the rules from one zone to another, e.g.
+//
+//	{{range .PairRules "untrust" "trust"}}
+//	        {{.}}
+//	{{- end}}
+func (m *FirewallModel) PairRules(from, to string) ([]string, error) {
+	var stmts []string
+	for i := range m.Rules {
+		r := &m.Rules[i]
+		if r.From != from || r.To != to {
+			continue
+		}
+		s, err := r.Statements()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, s...)
+	}
+	return stmts, nil
+}
+
+var markers = []string{"#Allowed_Address_PLACE", "{TRUST_IF_NAME}", "{UNTRUST_IF_NAME}"}
+
+// IsMarkerTemplate tells whether tmpl is a legacy marker-based template.
+func IsMarkerTemplate(tmpl string) bool {
+	for _, m := range markers {
+		if strings.Contains(tmpl, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// Render renders tmpl against m.
+//
+// Go templates get the following functions besides the text/template
+// builtins:
+//
+//	quote "eth-a"              "eth-a" as an nft string
+//	ifnames .TrustIf           "eth-a" or { "eth-a", "eth-b" }
+//	set .MgmtAddressRange      2001:db8::/32 or { 2001:db8::/32, ... }
+//	elements .Elements         { 2001:db8::/32, ... } for a set declaration
+//	family "2001:db8::/32"     ip6 (or ip for IPv4 prefixes)
+//	ipv4 .MgmtAddressRange     the IPv4 prefixes of a list
+//	ipv6 .MgmtAddressRange     the IPv6 prefixes of a list
+//
+// An empty list is not valid in an nft set literal, so guard ifnames, set
+// and elements with {{if}}.
+func Render(tmpl, mode string, m *FirewallModel) (string, error) {
+	if mode == "" || mode == TemplateAuto {
+		mode = TemplateGo
+		if IsMarkerTemplate(tmpl) {
+			mode = TemplateMarker
+		}
+	}
+	switch mode {
+	case TemplateMarker:
+		if len(m.Rules) > 0 || len(m.Zones) > 0 || len(m.Policies) > 0 {
+			return "", fmt.Errorf("Marker templates cannot render rules or zones")
+		}
+		return renderMarker(tmpl, m)
+	case TemplateGo:
+		t, err := template.New("ruleset").Funcs(templateFuncs).Option("missingkey=error").Parse(tmpl)
+		if err != nil {
+			return "", fmt.Errorf("Failed to parse template: %v", err)
+		}
+		var out strings.Builder
+		if err := t.Execute(&out, m); err != nil {
+			return "", fmt.Errorf("Failed to render template: %v", err)
+		}
+		return out.String(), nil
+	}
+	return "", fmt.Errorf("Unknown template mode %q", mode)
+}
+
+// RenderFile renders the template at tmplPath into outPath.
+func RenderFile(tmplPath, outPath, mode string, m *FirewallModel) error {
+	tmpl, err := os.ReadFile(tmplPath)
+	if err != nil {
+		return fmt.Errorf("Failed to open template file: %s", err)
+	}
+	out, err := Render(string(tmpl), mode, m)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(outPath, []byte(out), 0644); err != nil {
+		return fmt.Errorf("Failed to write to output file: %s", err)
+	}
+	return nil
+}
+
+var templateFuncs = template.FuncMap{
+	"quote":    Quote,
+	"ifnames":  quotedSet,
+	"set":      func(elems []string) string { return setLiteral(elems, identity) },
+	"elements": elementsLiteral,
+	"family":   Family,
+	"ipv4":     func(prefixes []string) ([]string, error) { return filterFamily(prefixes, "ip") },
+	"ipv6":     func(prefixes []string) ([]string, error) { return filterFamily(prefixes, "ip6") },
+}
+
+// Quote returns s as an nft quoted string. nft strings have no escape
+// sequences and end at the next double quote, so strings holding one, a
+// backslash or a control character are rejected.
+func Quote(s string) (string, error) {
+	if err := CheckQuotable(s); err != nil {
+		return "", err
+	}
+	return `"` + s + `"`, nil
+}
+
+// CheckQuotable tells whether s can be written as an nft quoted string.
+func CheckQuotable(s string) error {
+	for _, c := range s {
+		if c == '"' || c == '\\' || unicode.IsControl(c) {
+			return fmt.Errorf("%s cannot be quoted in a ruleset", strconv.Quote(s))
+		}
+	}
+	return nil
+}
+
+// quotedSet quotes names as a single string or an anonymous set.
+func quotedSet(names []string) (string, error) {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		q, err := Quote(name)
+		if err != nil {
+			return "", err
+		}
+		quoted[i] = q
+	}
+	return setLiteral(quoted, identity), nil
+}
+
+func setLiteral(elems []string, format func(string) string) string {
+	if len(elems) == 1 {
+		return format(elems[0])
+	}
+	quoted := make([]string, len(elems))
+	for i, e := range elems {
+		quoted[i] = format(e)
+	}
+	return "{ " + strings.Join(quoted, ", ") + " }"
+}
+
+// Family returns the nft payload family, "ip" or "ip6", of an address or
+// prefix.
+func Family(prefix string) (string, error) {
+	addr, err := netip.ParseAddr(prefix)
+	if p, perr := netip.ParsePrefix(prefix); perr == nil {
+		addr, err = p.Addr(), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("Invalid address or prefix %s", strconv.Quote(prefix))
+	}
+	if addr.Is4() {
+		return "ip", nil
+	}
+	return "ip6", nil
+}
+
+func filterFamily(prefixes []string, family string) ([]string, error) {
+	var out []string
+	for _, p := range prefixes {
+		f, err := Family(p)
+		if err != nil {
+			return nil, err
+		}
+		if f == family {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,160 @@
+package fwconfig
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	m := &FirewallModel{
+		Name:             "kote",
+		TrustIf:          []string{"eth-a", "eth-b"},
+		UntrustIf:        "vsix-bb",
+		MgmtAddressRange: []string{"2001:db8::/32", "192.0.2.0/24"},
+	}
+	tests := []struct {
+		name    string
+		tmpl    string
+		want    string
+		wantErr bool
+	}{
+		{"quote", `oifname {{quote .UntrustIf}} accept`, `oifname "vsix-bb" accept`, false},
+		{"ifnames", `oifname {{ifnames .TrustIf}} accept`, `oifname { "eth-a", "eth-b" } accept`, false},
+		{"ifnames single", `oifname {{ifnames (slice .TrustIf 0 1)}} accept`, `oifname "eth-a" accept`, false},
+		{"set by family", `ip saddr {{set (ipv4 .MgmtAddressRange)}} accept; ip6 saddr {{set (ipv6 .MgmtAddressRange)}} accept`,
+			`ip saddr 192.0.2.0/24 accept; ip6 saddr 2001:db8::/32 accept`, false},
+		{"family", `{{range .MgmtAddressRange}}{{family .}} saddr {{.}} accept;{{end}}`,
+			`ip6 saddr 2001:db8::/32 accept;ip saddr 192.0.2.0/24 accept;`, false},
+		{"icmp rate default", `limit rate {{.ICMPRate}} accept`, `limit rate 10/second accept`, false},
+		{"comments untouched", "# {{.Name}}: keep # comments\n", "# kote: keep # comments\n", false},
+		{"invalid prefix", `{{family "eth-a"}}`, "", true},
+		{"unknown field", `{{.Interfaces}}`, "", true},
+		{"syntax error", `{{range .TrustIf}}`, "", true},
+		// nft strings have no escapes: the quote would end the string.
+		{"quote injection", `oifname {{quote "a\" ; flush ruleset ; #"}} accept`, "", true},
+		{"quote backslash", `oifname {{quote "a\\"}} accept`, "", true},
+		{"quote newline", `oifname {{quote "a\nflush ruleset"}} accept`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Render(tt.tmpl, TemplateAuto, m)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Render() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderICMPRate(t *testing.T) {
+	m := &FirewallModel{TrustIf: []string{"eth-a"}, UntrustIf: "vsix-bb", ICMPRateLimit: "5/minute"}
+	tmpl, err := os.ReadFile("../../fw/fw-template.rule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered, err := Render(string(tmpl), TemplateAuto, m)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	rs, err := Parse(rendered)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	var limited int
+	for _, r := range rs.Table("inet", "filter").Chain("INPUT").Rules() {
+		if s := r.String(); strings.Contains(s, "limit rate") {
+			if !strings.Contains(s, "limit rate 5/minute accept") {
+				t.Errorf("INPUT rule %q does not use the model's rate", s)
+			}
+			limited++
+		}
+	}
+	if limited != 2 {
+		t.Errorf("INPUT has %d rate limited rules, want the icmp and icmpv6 ones", limited)
+	}
+}
+
+func TestRenderRejectsUnquotable(t *testing.T) {
+	m := &FirewallModel{TrustIf: []string{`eth-a" ; flush ruleset ; #`}, UntrustIf: "vsix-bb"}
+	for _, tmpl := range []string{
+		`oifname {{ifnames .TrustIf}} accept`,
+		"# oifname \"{TRUST_IF_NAME}\" accept\n",
+	} {
+		if got, err := Render(tmpl, TemplateAuto, m); err == nil {
+			t.Errorf("Render(%q) = %q, want an error", tmpl, got)
+		}
+	}
+}
+
+func TestRenderMarkerCompat(t *testing.T) {
+	tmpl, err := os.ReadFile("demo-template.rule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("demo.rule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &FirewallModel{TrustIf: []string{"eth-a", "eth-b", "eth-c"}, UntrustIf: "vsix-bb"}
+	for _, mode := range []string{TemplateAuto, TemplateMarker} {
+		got, err := Render(string(tmpl), mode, m)
+		if err != nil {
+			t.Fatalf("Render(%s) error = %v", mode, err)
+		}
+		if got != string(want) {
+			t.Errorf("Render(%s) = %q, want %q", mode, got, want)
+		}
+	}
+	multi := &FirewallModel{TrustIf: []string{"eth-a"}, UntrustIfs: []string{"vsix-bb", "vsix-ix"}}
+	got, err := Render(string(tmpl), TemplateMarker, multi)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	for _, want := range []string{`oifname "vsix-bb" jump ZONE_UNTRUST;`, `oifname "vsix-ix" jump ZONE_UNTRUST;`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Render() of a marker template does not contain %q", want)
+		}
+	}
+	dual := &FirewallModel{TrustIf: []string{"eth-a"}, UntrustIf: "vsix-bb", MgmtAddressRange: []string{"2001:db8::/32", "192.0.2.0/24"}}
+	got, err = Render(string(tmpl), TemplateMarker, dual)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	for _, want := range []string{"ip6 saddr 2001:db8::/32 accept;", "ip saddr 192.0.2.0/24 accept;"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Render() of a marker template does not contain %q", want)
+		}
+	}
+	dual.MgmtAddressRange = []string{"2001:db8::/129"}
+	if _, err := Render(string(tmpl), TemplateMarker, dual); err == nil {
+		t.Error("Render() accepted an invalid management prefix")
+	}
+	if _, err := Render(string(tmpl), "jinja", m); err == nil {
+		t.Error("Render() accepted an unknown mode")
+	}
+}
+
+func TestRenderFileReadBack(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "fw.rule")
+	m := &FirewallModel{
+		Name:             "kote",
+		TrustIf:          []string{"eth-a", "eth-b"},
+		UntrustIfs:       []string{"vsix-bb", "vsix-ix"},
+		MgmtAddressRange: []string{"192.0.2.0/24", "2001:db8:10:10::/64"},
+	}
+	if err := RenderFile("../../fw/fw-template.rule", out, TemplateAuto, m); err != nil {
+		t.Fatalf("RenderFile() error = %v", err)
+	}
+	trust, untrust, mgmt, err := RulesReader(out)
+	if err != nil {
+		t.Fatalf("RulesReader() error = %v", err)
+	}
+	if !reflect.DeepEqual(trust, m.TrustIf) || !reflect.DeepEqual(untrust, m.UntrustIfs) || !reflect.DeepEqual(mgmt, m.MgmtAddressRange) {
+		t.Errorf("RulesReader() = %v, %v, %v, want %v, %v, %v", trust, untrust, mgmt, m.TrustIf, m.UntrustIfs, m.MgmtAddressRange)
+	}
+}
@@ -0,0 +1,196 @@
+package fwconfig
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Service is a protocol with destination ports or icmp types. A service
+// without ports or types matches the whole protocol.
+type Service struct {
+	// Protocol is tcp, udp, sctp, icmp, icmpv6 or empty for any.
+	Protocol  string
+	Ports     []string
+	ICMPTypes []string
+}
+
+// match renders s as the protocol match of the rule named rule, "" if s
+// matches any protocol.
+func (s *Service) match(rule string) (string, error) {
+	switch s.Protocol {
+	case "":
+		if len(s.Ports) > 0 || len(s.ICMPTypes) > 0 {
+			return "", fmt.Errorf("Rule %q sets ports or icmp types without a protocol", rule)
+		}
+		return "", nil
+	case "tcp", "udp", "sctp":
+		if len(s.ICMPTypes) > 0 {
+			return "", fmt.Errorf("Rule %q sets icmp types for protocol %s", rule, s.Protocol)
+		}
+		if len(s.Ports) > 0 {
+			return fmt.Sprintf("%s dport %s", s.Protocol, setLiteral(s.Ports, identity)), nil
+		}
+	case "icmp", "icmpv6":
+		if len(s.Ports) > 0 {
+			return "", fmt.Errorf("Rule %q sets ports for protocol %s", rule, s.Protocol)
+		}
+		if err := CheckICMPTypes(s.Protocol, s.ICMPTypes); err != nil {
+			return "", fmt.Errorf("Rule %q: %v", rule, err)
+		}
+		if len(s.ICMPTypes) > 0 {
+			return fmt.Sprintf("%s type %s", s.Protocol, setLiteral(s.ICMPTypes, identity)), nil
+		}
+	default:
+		return "", fmt.Errorf("Unknown protocol %q in rule %q", s.Protocol, rule)
+	}
+	return "meta l4proto " + s.Protocol, nil
+}
+
+// icmpTypes are the type names nft knows for icmp and icmpv6, as listed by
+// "nft describe icmp_type" and "nft describe icmpv6_type".
+var icmpTypes = map[string][]string{
+	"icmp": {
+		"echo-reply", "destination-unreachable", "source-quench", "redirect",
+		"echo-request", "router-advertisement", "router-solicitation",
+		"time-exceeded", "parameter-problem", "timestamp-request",
+		"timestamp-reply", "info-request", "info-reply",
+		"address-mask-request", "address-mask-reply",
+	},
+	"icmpv6": {
+		"destination-unreachable", "packet-too-big", "time-exceeded",
+		"parameter-problem", "echo-request", "echo-reply",
+		"mld-listener-query", "mld-listener-report", "mld-listener-done",
+		"mld-listener-reduction", "nd-router-solicit", "nd-router-advert",
+		"nd-neighbor-solicit", "nd-neighbor-advert", "nd-redirect",
+		"router-renumbering", "ind-neighbor-solicit", "ind-neighbor-advert",
+		"mld2-listener-report",
+	},
+}
+
+// ICMPTypes returns the type names nft knows for protocol, icmp or icmpv6.
+func ICMPTypes(protocol string) []string {
+	return append([]string(nil), icmpTypes[protocol]...)
+}
+
+// CheckICMPTypes checks that types are icmp or icmpv6 type names of nft.
+// They are written into rulesets as they are.
+func CheckICMPTypes(protocol string, types []string) error {
+	for _, t := range types {
+		known := false
+		for _, name := range icmpTypes[protocol] {
+			if t == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("Unknown %s type %s", protocol, strconv.Quote(t))
+		}
+	}
+	return nil
+}
+
+// mergeServices merges the services of the same protocol, so that a rule
+// renders one statement per protocol. The protocols keep the order in
+// which they first appear.
+func mergeServices(services []Service) []Service {
+	var merged []Service
+	index := map[string]int{}
+	whole := map[string]bool{}
+	for _, s := range services {
+		i, ok := index[s.Protocol]
+		if !ok {
+			index[s.Protocol] = len(merged)
+			merged = append(merged, Service{Protocol: s.Protocol})
+			i = len(merged) - 1
+		}
+		if whole[s.Protocol] {
+			continue
+		}
+		if len(s.Ports) == 0 && len(s.ICMPTypes) == 0 {
+			// The whole protocol covers any ports.
+			whole[s.Protocol] = true
+			merged[i].Ports, merged[i].ICMPTypes = nil, nil
+			continue
+		}
+		merged[i].Ports = appendMissing(merged[i].Ports, s.Ports...)
+		merged[i].ICMPTypes = appendMissing(merged[i].ICMPTypes, s.ICMPTypes...)
+	}
+	return merged
+}
+
+func appendMissing(list []string, elems ...string) []string {
+	for _, e := range elems {
+		found := false
+		for _, v := range list {
+			if v == e {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// ServiceGroup is a named list of services, such as the ports of ssh or
+// bgp, that rules match by reference.
+type ServiceGroup struct {
+	Name     string
+	Services []Service
+}
+
+// ServiceSet is a named set of the ports or icmp types of a protocol of a
+// ServiceGroup, for templates matching the group in rules of their own.
+type ServiceSet struct {
+	Name     string
+	Protocol string
+	// Type is the nft type of the elements.
+	Type string
+	// Interval tells whether the set needs "flags interval" for port
+	// ranges.
+	Interval bool
+	Elements []string
+}
+
+// ServiceSets returns a set for every protocol of every service group
+// that has ports or icmp types, e.g.
+//
+//	{{range .ServiceSets}}
+//	    set {{.Name}} {
+//	        type {{.Type}};{{if .Interval}} flags interval;{{end}}
+//	        elements = {{elements .Elements}}
+//	    }
+//	{{end}}
+//
+// matched by "tcp dport @svc_ssh_tcp". The name of a set is "svc_", the
+// group name with '-' and '.' replaced by '_', and the protocol.
+func (m *FirewallModel) ServiceSets() ([]ServiceSet, error) {
+	var sets []ServiceSet
+	for _, g := range m.ServiceGroups {
+		for _, s := range mergeServices(g.Services) {
+			if err := CheckICMPTypes(s.Protocol, s.ICMPTypes); err != nil {
+				return nil, fmt.Errorf("Service group %s: %v", strconv.Quote(g.Name), err)
+			}
+			set := ServiceSet{
+				Name:     fmt.Sprintf("svc_%s_%s", strings.NewReplacer("-", "_", ".", "_").Replace(g.Name), s.Protocol),
+				Protocol: s.Protocol,
+			}
+			switch s.Protocol {
+			case "tcp", "udp", "sctp":
+				set.Type, set.Interval, set.Elements = "inet_service", true, s.Ports
+			case "icmp":
+				set.Type, set.Elements = "icmp_type", s.ICMPTypes
+			case "icmpv6":
+				set.Type, set.Elements = "icmpv6_type", s.ICMPTypes
+			}
+			if len(set.Elements) > 0 {
+				sets = append(sets, set)
+			}
+		}
+	}
+	return sets, nil
+}
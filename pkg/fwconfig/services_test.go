@@ -0,0 +1,50 @@
+package fwconfig
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestServiceSetsRender(t *testing.T) {
+	tmpl, err := os.ReadFile("../../fw/fw-template.rule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &FirewallModel{
+		TrustIf:   []string{"eth-a"},
+		UntrustIf: "vsix-bb",
+		ServiceGroups: []ServiceGroup{
+			{Name: "snmp-mgr.v2", Services: []Service{{"udp", []string{"161", "162"}, nil}}},
+			{Name: "ping", Services: []Service{{"icmpv6", nil, []string{"echo-request"}}, {Protocol: "icmp"}}},
+		},
+	}
+	got, err := Render(string(tmpl), TemplateGo, m)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	rs, err := Parse(got)
+	if err != nil {
+		t.Fatalf("Parse() error = %v\n%s", err, got)
+	}
+	filter := rs.Table("inet", "filter")
+	if s := filter.Set("svc_snmp_mgr_v2_udp"); s == nil || !reflect.DeepEqual(s.Elements(), []string{"161", "162"}) {
+		t.Errorf("svc_snmp_mgr_v2_udp missing or wrong:\n%s", got)
+	}
+	if s := filter.Set("svc_ping_icmpv6"); s == nil || !reflect.DeepEqual(s.Elements(), []string{"echo-request"}) {
+		t.Errorf("svc_ping_icmpv6 missing or wrong:\n%s", got)
+	}
+	// The whole of icmp has no elements to put in a set.
+	if s := filter.Set("svc_ping_icmp"); s != nil {
+		t.Errorf("rendered a set for a service without ports or types:\n%s", got)
+	}
+}
+
+func TestServiceSetsRejectUnknownICMPTypes(t *testing.T) {
+	m := &FirewallModel{
+		ServiceGroups: []ServiceGroup{{Name: "ping", Services: []Service{{"icmp", nil, []string{"echo-request }; flush ruleset; #"}}}}},
+	}
+	if sets, err := m.ServiceSets(); err == nil {
+		t.Errorf("ServiceSets() = %+v, want an error", sets)
+	}
+}
@@ -0,0 +1,133 @@
+package fwconfig
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Names of the sets holding the management addresses.
+const (
+	MgmtSetV4 = "mgmt_v4"
+	MgmtSetV6 = "mgmt_v6"
+)
+
+// AddressSet is a named interval set of IPv4 or IPv6 prefixes, matched by
+// a single rule however many prefixes it holds.
+type AddressSet struct {
+	Name string
+	// Family is the payload family of rules matching the set, "ip" or
+	// "ip6".
+	Family   string
+	Elements []string
+}
+
+// Type returns the nft type of the set's elements.
+func (s *AddressSet) Type() string {
+	if s.Family == "ip" {
+		return "ipv4_addr"
+	}
+	return "ipv6_addr"
+}
+
+// MgmtSets returns the IPv4 and IPv6 sets of management addresses, e.g.
+//
+//	{{range .MgmtSets}}
+//	    set {{.Name}} {
+//	        type {{.Type}}; flags interval;
+//	        {{- if .Elements}}
+//	        elements = {{elements .Elements}}
+//	        {{- end}}
+//	    }
+//	{{end}}
+//
+// Both sets are returned even if empty, so that a prefix of a new family
+// only adds an element and does not change the rules.
+func (m *FirewallModel) MgmtSets() ([]AddressSet, error) {
+	v4, err := filterFamily(m.MgmtAddressRange, "ip")
+	if err != nil {
+		return nil, err
+	}
+	v6, err := filterFamily(m.MgmtAddressRange, "ip6")
+	if err != nil {
+		return nil, err
+	}
+	return []AddressSet{
+		{Name: MgmtSetV4, Family: "ip", Elements: v4},
+		{Name: MgmtSetV6, Family: "ip6", Elements: v6},
+	}, nil
+}
+
+// SetUpdates returns the nft script turning old into new if they only
+// differ in the elements of named sets: "delete element" commands for the
+// elements dropped from a set followed by "add element" commands for the
+// new ones. ok is false if anything else changed, in which case new has
+// to be loaded as a whole. An empty script means there is nothing to do.
+func SetUpdates(old, new *Ruleset) (script string, ok bool) {
+	for _, c := range Diff(old, new) {
+		path := strings.Fields(c.Path)
+		if len(path) < 2 || path[len(path)-2] != "set" || !strings.HasPrefix(c.Stmt, "elements ") {
+			return "", false
+		}
+	}
+
+	var deletes, adds []string
+	for _, t := range new.Tables() {
+		prev := old.Table(t.Family(), t.Name())
+		if prev == nil {
+			// Unreachable: a new table shows up in the diff.
+			return "", false
+		}
+		for _, s := range t.Sets() {
+			before := prev.Set(s.Name())
+			if before == nil {
+				return "", false
+			}
+			removed, added := diffElements(before.Elements(), s.Elements())
+			if s.IsMap() && len(removed)+len(added) > 0 {
+				return "", false
+			}
+			target := fmt.Sprintf("%s %s %s", t.Family(), t.Name(), s.Name())
+			if len(removed) > 0 {
+				deletes = append(deletes, fmt.Sprintf("delete element %s %s", target, elementsLiteral(removed)))
+			}
+			if len(added) > 0 {
+				adds = append(adds, fmt.Sprintf("add element %s %s", target, elementsLiteral(added)))
+			}
+		}
+	}
+	// Deleting first lets a prefix be replaced by an overlapping one.
+	cmds := append(deletes, adds...)
+	if len(cmds) == 0 {
+		return "", true
+	}
+	return strings.Join(cmds, "\n") + "\n", true
+}
+
+// diffElements returns the elements of a missing from b and those of b
+// missing from a.
+func diffElements(a, b []string) (removed, added []string) {
+	inA, inB := map[string]bool{}, map[string]bool{}
+	for _, e := range a {
+		inA[e] = true
+	}
+	for _, e := range b {
+		inB[e] = true
+	}
+	for _, e := range a {
+		if !inB[e] {
+			removed = append(removed, e)
+		}
+	}
+	for _, e := range b {
+		if !inA[e] {
+			added = append(added, e)
+		}
+	}
+	return removed, added
+}
+
+// elementsLiteral returns elems as the braced list nft expects after
+// "elements =" and in element commands, even for a single element.
+func elementsLiteral(elems []string) string {
+	return "{ " + strings.Join(elems, ", ") + " }"
+}
@@ -0,0 +1,102 @@
+package fwconfig
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMgmtSetsRender(t *testing.T) {
+	tmpl, err := os.ReadFile("../../fw/fw-template.rule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &FirewallModel{
+		TrustIf:          []string{"eth-a"},
+		UntrustIf:        "vsix-bb",
+		MgmtAddressRange: []string{"2001:db8:10:10::/64", "2001:db8:10:20::/64"},
+	}
+	got, err := Render(string(tmpl), TemplateGo, m)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	rs, err := Parse(got)
+	if err != nil {
+		t.Fatalf("Parse() error = %v\n%s", err, got)
+	}
+	filter := rs.Table("inet", "filter")
+	v4, v6 := filter.Set(MgmtSetV4), filter.Set(MgmtSetV6)
+	if v4 == nil || v6 == nil {
+		t.Fatalf("management sets missing:\n%s", got)
+	}
+	if elems := v4.Elements(); len(elems) != 0 {
+		t.Errorf("%s elements = %v, want none", MgmtSetV4, elems)
+	}
+	if elems := v6.Elements(); len(elems) != 2 || elems[0] != "2001:db8:10:10::/64" {
+		t.Errorf("%s elements = %v", MgmtSetV6, elems)
+	}
+	if n := strings.Count(got, "ip6 saddr"); n != 1 {
+		t.Errorf("rendered %d ip6 saddr rules, want a single one referencing the set", n)
+	}
+}
+
+func TestSetUpdates(t *testing.T) {
+	const base = `flush ruleset
+table inet filter {
+	set mgmt_v6 {
+		type ipv6_addr; flags interval;
+		elements = { 2001:db8:10:10::/64, 2001:db8:10:20::/64 }
+	}
+	set mgmt_v4 {
+		type ipv4_addr; flags interval;
+	}
+	chain INPUT {
+		ip6 saddr @mgmt_v6 accept
+		ip saddr @mgmt_v4 accept
+	}
+}
+`
+	tests := []struct {
+		name   string
+		edit   func(string) string
+		want   string
+		wantOK bool
+	}{
+		{"unchanged", func(s string) string { return s }, "", true},
+		{"layout only", func(s string) string {
+			return strings.Replace(s, "10:10::/64, ", "10:10::/64,\n\t\t\t", 1)
+		}, "", true},
+		{"elements", func(s string) string {
+			s = strings.Replace(s, "2001:db8:10:10::/64, ", "", 1)
+			return strings.Replace(s, "flags interval;\n\t}", "flags interval;\n\t\telements = { 192.0.2.0/24 }\n\t}", 1)
+		}, "delete element inet filter mgmt_v6 { 2001:db8:10:10::/64 }\nadd element inet filter mgmt_v4 { 192.0.2.0/24 }\n", true},
+		{"replaced prefix", func(s string) string {
+			return strings.Replace(s, "2001:db8:10:20::/64", "2001:db8:10::/48", 1)
+		}, "delete element inet filter mgmt_v6 { 2001:db8:10:20::/64 }\nadd element inet filter mgmt_v6 { 2001:db8:10::/48 }\n", true},
+		{"rule", func(s string) string {
+			return strings.Replace(s, "ip saddr @mgmt_v4 accept", "ip saddr @mgmt_v4 drop", 1)
+		}, "", false},
+		{"set type", func(s string) string {
+			return strings.Replace(s, "type ipv4_addr; flags interval;", "type ipv4_addr;", 1)
+		}, "", false},
+		{"new set", func(s string) string {
+			return strings.Replace(s, "\tchain INPUT", "\tset lab { type ipv4_addr; }\n\tchain INPUT", 1)
+		}, "", false},
+	}
+	old, err := Parse(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			new, err := Parse(tt.edit(base))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := SetUpdates(old, new)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("SetUpdates() = %q, %t, want %q, %t", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
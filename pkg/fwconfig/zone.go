@@ -0,0 +1,135 @@
+package fwconfig
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Zone names of the legacy trust/untrust pair.
+const (
+	ZoneTrust   = "trust"
+	ZoneUntrust = "untrust"
+)
+
+// Zone is a set of interfaces sharing a policy.
+type Zone struct {
+	Name       string
+	Interfaces []string
+}
+
+// Chain returns the name of the chain that dispatches traffic leaving
+// through the zone's interfaces, e.g. ZONE_TRUST.
+func (z *Zone) Chain() string {
+	return "ZONE_" + strings.ToUpper(z.Name)
+}
+
+// ZonePolicy is the verdict for traffic from one zone to another that no
+// rule matched.
+type ZonePolicy struct {
+	From    string
+	To      string
+	Default string
+}
+
+// ZonePair is an entry of the zone pair matrix.
+type ZonePair struct {
+	From *Zone
+	To   *Zone
+	// Rules are the rendered statements of the pair's policy rules.
+	Rules []string
+	// Default is the verdict ending the pair's chain.
+	Default string
+}
+
+// Chain returns the name of the chain holding the pair's policy.
+func (p *ZonePair) Chain() string {
+	return PairChain(p.From.Name, p.To.Name)
+}
+
+// AllZones returns the trust and untrust zones, if they have interfaces,
+// followed by the named zones.
+func (m *FirewallModel) AllZones() ([]*Zone, error) {
+	var zones []*Zone
+	if len(m.TrustIf) > 0 {
+		zones = append(zones, &Zone{Name: ZoneTrust, Interfaces: m.TrustIf})
+	}
+	if untrust := m.untrustIfs(); len(untrust) > 0 {
+		zones = append(zones, &Zone{Name: ZoneUntrust, Interfaces: untrust})
+	}
+	seen := map[string]bool{}
+	for _, z := range zones {
+		seen[z.Name] = true
+	}
+	for i := range m.Zones {
+		z := &m.Zones[i]
+		if seen[z.Name] {
+			return nil, fmt.Errorf("Zone %s is defined twice", z.Name)
+		}
+		seen[z.Name] = true
+		zones = append(zones, z)
+	}
+	return zones, nil
+}
+
+// Pairs returns the zone pair matrix: a pair for every ordered pair of
+// distinct zones, with its rules and default verdict. Pairs without a
+// ZonePolicy drop, except trust to untrust which accepts as it always has.
+func (m *FirewallModel) Pairs() ([]*ZonePair, error) {
+	zones, err := m.AllZones()
+	if err != nil {
+		return nil, err
+	}
+	known := map[string]bool{}
+	for _, z := range zones {
+		known[z.Name] = true
+	}
+	defaults := map[[2]string]string{}
+	for _, p := range m.Policies {
+		if !known[p.From] || !known[p.To] {
+			return nil, fmt.Errorf("Zone policy %s to %s references an unknown zone", p.From, p.To)
+		}
+		defaults[[2]string{p.From, p.To}] = p.Default
+	}
+	for _, r := range m.Rules {
+		if !known[r.From] || !known[r.To] {
+			return nil, fmt.Errorf("Rule %q references an unknown zone", r.Name)
+		}
+	}
+
+	var pairs []*ZonePair
+	for _, from := range zones {
+		for _, to := range zones {
+			if from == to {
+				continue
+			}
+			rules, err := m.PairRules(from.Name, to.Name)
+			if err != nil {
+				return nil, err
+			}
+			def, ok := defaults[[2]string{from.Name, to.Name}]
+			if !ok {
+				def = ActionDrop
+				if from.Name == ZoneTrust && to.Name == ZoneUntrust {
+					def = ActionAccept
+				}
+			}
+			pairs = append(pairs, &ZonePair{From: from, To: to, Rules: rules, Default: def})
+		}
+	}
+	return pairs, nil
+}
+
+// PairsTo returns the pairs whose traffic goes to the named zone.
+func (m *FirewallModel) PairsTo(zone string) ([]*ZonePair, error) {
+	pairs, err := m.Pairs()
+	if err != nil {
+		return nil, err
+	}
+	var to []*ZonePair
+	for _, p := range pairs {
+		if p.To.Name == zone {
+			to = append(to, p)
+		}
+	}
+	return to, nil
+}
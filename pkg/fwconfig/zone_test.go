@@ -0,0 +1,85 @@
+package fwconfig
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestPairs(t *testing.T) {
+	m := &FirewallModel{
+		TrustIf:   []string{"eth-a"},
+		UntrustIf: "vsix-bb",
+		Zones:     []Zone{{Name: "dmz", Interfaces: []string{"eth-d"}}},
+		Policies:  []ZonePolicy{{From: "dmz", To: "untrust", Default: ActionAccept}},
+		Rules:     []PolicyRule{{Name: "web", From: "untrust", To: "dmz", Protocol: "tcp", Ports: []string{"443"}, Action: ActionAccept}},
+	}
+	pairs, err := m.Pairs()
+	if err != nil {
+		t.Fatalf("Pairs() error = %v", err)
+	}
+	got := map[string]string{}
+	for _, p := range pairs {
+		got[p.Chain()] = p.Default
+	}
+	want := map[string]string{
+		"PAIR_trust_to_untrust": ActionAccept,
+		"PAIR_trust_to_dmz":     ActionDrop,
+		"PAIR_untrust_to_trust": ActionDrop,
+		"PAIR_untrust_to_dmz":   ActionDrop,
+		"PAIR_dmz_to_trust":     ActionDrop,
+		"PAIR_dmz_to_untrust":   ActionAccept,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Pairs() defaults = %v, want %v", got, want)
+	}
+
+	tmpl, err := os.ReadFile("../../fw/fw-template.rule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered, err := Render(string(tmpl), TemplateAuto, m)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	rs, err := Parse(rendered)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	filter := rs.Table("inet", "filter")
+	for _, chain := range []string{"ZONE_TRUST", "ZONE_UNTRUST", "ZONE_DMZ"} {
+		if filter.Chain(chain) == nil {
+			t.Errorf("chain %s not rendered", chain)
+		}
+	}
+	for chain := range want {
+		if filter.Chain(chain) == nil {
+			t.Errorf("chain %s not rendered", chain)
+		}
+	}
+	var dmz []string
+	for _, r := range filter.Chain("ZONE_DMZ").Rules() {
+		dmz = append(dmz, r.String())
+	}
+	if want := []string{
+		`iifname "eth-d" return`,
+		`iifname "eth-a" jump PAIR_trust_to_dmz`,
+		`iifname "vsix-bb" jump PAIR_untrust_to_dmz`,
+	}; !reflect.DeepEqual(dmz, want) {
+		t.Errorf("ZONE_DMZ = %q, want %q", dmz, want)
+	}
+}
+
+func TestPairsErrors(t *testing.T) {
+	for name, m := range map[string]*FirewallModel{
+		"duplicate zone": {TrustIf: []string{"eth-a"}, Zones: []Zone{{Name: "trust", Interfaces: []string{"eth-b"}}}},
+		"unknown policy": {TrustIf: []string{"eth-a"}, Policies: []ZonePolicy{{From: "trust", To: "dmz", Default: ActionAccept}}},
+		"unknown rule":   {UntrustIf: "vsix-bb", Rules: []PolicyRule{{From: "untrust", To: "trust", Action: ActionAccept}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := m.Pairs(); err == nil {
+				t.Error("Pairs() succeeded")
+			}
+		})
+	}
+}